@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"k8s/k8ssetup"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+const programName = "pets-infrastructure"
+
+// exit codes of the program
+const (
+	exitOK = 0
+	// exitFailed is a command that failed, like a step of the set up or a check of the doctor
+	exitFailed = 1
+	// exitUsage is an unknown command, an invalid flag or flags that could not be used together
+	exitUsage = 2
+	// exitConfig is a spec or a state file that could not be loaded
+	exitConfig = 3
+	// exitInterrupted is a run cancelled by a signal, like shells do for SIGINT
+	exitInterrupted = 130
+)
+
+var (
+	reportFormats = []string{k8ssetup.TableReport, k8ssetup.JSONReport, k8ssetup.JUnitReport}
+	listFormats   = []string{k8ssetup.TableReport, k8ssetup.JSONReport}
+)
+
+// exitError is an error with the exit code of the program
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func usageError(format string, args ...interface{}) error {
+	return exitError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+// command is a subcommand of the program, flags registers its own flags next to the global ones
+type command struct {
+	name    string
+	summary string
+	formats []string
+	flags   func(c *cli, fs *flag.FlagSet)
+	run     func(ctx context.Context, c *cli, spec *k8ssetup.Spec) error
+}
+
+var commands = []command{
+	{
+		name:    "up",
+		summary: "create every component of the spec that does not exist yet",
+		formats: reportFormats,
+		flags:   stepFlags,
+		run: func(ctx context.Context, c *cli, spec *k8ssetup.Spec) error {
+			return c.runSteps(ctx, spec, false, false)
+		},
+	},
+	{
+		name:    "down",
+		summary: "remove every component of the spec and the state file",
+		formats: reportFormats,
+		run: func(ctx context.Context, c *cli, spec *k8ssetup.Spec) error {
+			return c.runSteps(ctx, spec, true, false)
+		},
+	},
+	{
+		name:    "plan",
+		summary: "print the changes of up, or of down with -down, without making them",
+		formats: reportFormats,
+		flags: func(c *cli, fs *flag.FlagSet) {
+			fs.BoolVar(&c.down, "down", false, "plan the changes of down instead of up")
+			stepFlags(c, fs)
+		},
+		run: func(ctx context.Context, c *cli, spec *k8ssetup.Spec) error {
+			c.dryRun = true
+			return c.runSteps(ctx, spec, c.down, false)
+		},
+	},
+	{
+		name:    "resume",
+		summary: "run up again, skipping the steps that the last run completed",
+		formats: reportFormats,
+		run: func(ctx context.Context, c *cli, spec *k8ssetup.Spec) error {
+			return c.runSteps(ctx, spec, false, true)
+		},
+	},
+	{
+		name:    "status",
+		summary: "print the live state of every component, or with -steps the last result of every step",
+		formats: listFormats,
+		flags: func(c *cli, fs *flag.FlagSet) {
+			fs.BoolVar(&c.steps, "steps", false, "print the last result of every step from the state file instead")
+		},
+		run: func(ctx context.Context, c *cli, spec *k8ssetup.Spec) error {
+			if c.steps {
+				return stepStatus(spec, c.stateFile, c.stdout, c.output)
+			}
+			return status(ctx, c.newSetUp(spec, k8ssetup.Options{}), c.stdout, c.output)
+		},
+	},
+	{
+		name:    "doctor",
+		summary: "check that the cluster and the tools are ready for up, without changing anything",
+		formats: listFormats,
+		run: func(ctx context.Context, c *cli, spec *k8ssetup.Spec) error {
+			stp := c.newSetUp(spec, k8ssetup.Options{})
+			return doctor(ctx, stp, c.stdout, c.output)
+		},
+	},
+}
+
+func stepFlags(c *cli, fs *flag.FlagSet) {
+	fs.StringVar(&c.fromStep, "from-step", "", "skip the steps before this one, a step name like database or a step id like database/pets-db.yml")
+	fs.StringVar(&c.onlyStep, "only-step", "", "run only this step, a step name like kafka-topics or a step id like kafka-topics/pets")
+}
+
+func findCommand(name string) (command, bool) {
+	for _, v := range commands {
+		if v.name == name {
+			return v, true
+		}
+	}
+	return command{}, false
+}
+
+// cli parses the arguments of the program and runs its command, newSetUp is the seam that the tests fake
+type cli struct {
+	stdout   io.Writer
+	stderr   io.Writer
+	newSetUp func(spec *k8ssetup.Spec, options k8ssetup.Options) k8ssetup.K8sSetUp
+	logger   *k8ssetup.Logger
+
+	configFile     string
+	namespace      string
+	kubeconfig     string
+	kubeContext    string
+	confirmContext string
+	output         string
+	outputFile     string
+	stateFile      string
+	logLevel       string
+	logFormat      string
+	verbose        bool
+
+	fromStep string
+	onlyStep string
+	down     bool
+	dryRun   bool
+	steps    bool
+}
+
+func newCLI(stdout, stderr io.Writer, newSetUp func(spec *k8ssetup.Spec, options k8ssetup.Options) k8ssetup.K8sSetUp) *cli {
+	return &cli{stdout: stdout, stderr: stderr, newSetUp: newSetUp}
+}
+
+func (c *cli) globalFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet(programName, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&c.configFile, "config", "pets-infrastructure.yml", "spec file of the environment, yaml or json")
+	fs.StringVar(&c.namespace, "namespace", "", "namespace of the environment, overrides namespace of the spec")
+	fs.StringVar(&c.kubeconfig, "kubeconfig", "", "kubeconfig file of the cluster, overrides cluster.kubeconfig of the spec")
+	fs.StringVar(&c.kubeContext, "context", "", "context of the kubeconfig, overrides cluster.context of the spec")
+	fs.StringVar(&c.confirmContext, "confirm-context", "", "name of the protected context that the command is allowed to change")
+	fs.StringVar(&c.output, "output", k8ssetup.TableReport, "output format, table, json or junit for the report of the steps")
+	fs.StringVar(&c.outputFile, "output-file", "", "file of the report of the steps, stdout when empty")
+	fs.StringVar(&c.stateFile, "state-file", ".pets-infrastructure.state.json", "file where the completed steps are saved, no state is saved when empty")
+	fs.StringVar(&c.logLevel, "log-level", "info", "minimum level of the log events, debug, info, warn or error")
+	fs.StringVar(&c.logFormat, "log-format", k8ssetup.ConsoleFormat, "format of the log events, console or json lines")
+	fs.BoolVar(&c.verbose, "v", false, "log the output of every command, same as -log-level debug")
+	fs.Usage = func() { c.usage(fs) }
+	return fs
+}
+
+func (c *cli) usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] <command> [command flags]\n\n", programName)
+	fmt.Fprintf(out, "Sets up the pets environment of the spec in a Kubernetes cluster.\n\nCommands:\n")
+	for _, v := range commands {
+		fmt.Fprintf(out, "  %-8s%s\n", v.name, v.summary)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	fs.PrintDefaults()
+	fmt.Fprintf(out, "\nExit codes: %d success, %d failure, %d usage error, %d invalid spec or state, %d interrupted\n",
+		exitOK, exitFailed, exitUsage, exitConfig, exitInterrupted)
+	fmt.Fprintf(out, "Run '%s help <command>' for the flags of a command.\n", programName)
+}
+
+// commandFlags returns the flags of a command, with the global ones so they could go after the command too
+func (c *cli) commandFlags(global *flag.FlagSet, cmd command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	global.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	if cmd.flags != nil {
+		cmd.flags(c, fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] %s [flags]\n\n  %s\n\nFlags:\n", programName, cmd.name, cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// run runs the command of the arguments and returns the exit code of the program
+func (c *cli) run(args []string) int {
+	global := c.globalFlags()
+	if err := global.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return exitUsage
+	}
+
+	name, args := global.Arg(0), global.Args()[1:]
+	if name == "help" {
+		return c.help(global, args)
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(c.stderr, "Unknown command %q\n\n", name)
+		global.Usage()
+		return exitUsage
+	}
+	fs := c.commandFlags(global, cmd)
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(c.stderr, "Unexpected arguments %v\n\n", fs.Args())
+		fs.Usage()
+		return exitUsage
+	}
+
+	logger, err := newLogger(c.stderr, c.logLevel, c.logFormat, c.verbose)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "Error creating the logger, %v\n", err)
+		return exitUsage
+	}
+	c.logger = logger
+	k8ssetup.SetLogger(logger)
+
+	var interrupted int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cancelOnSignal(ctx, logger, func() {
+		atomic.StoreInt32(&interrupted, 1)
+		cancel()
+	})
+
+	err = c.execute(ctx, cmd)
+	if err == nil {
+		return exitOK
+	}
+	logger.Errorf(ctx, "Error running %s, %v", cmd.name, err)
+	if atomic.LoadInt32(&interrupted) == 1 {
+		return exitInterrupted
+	}
+	var exitErr exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return exitFailed
+}
+
+func (c *cli) help(global *flag.FlagSet, args []string) int {
+	global.SetOutput(c.stdout)
+	if len(args) == 0 {
+		global.Usage()
+		return exitOK
+	}
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		fmt.Fprintf(c.stderr, "Unknown command %q\n", args[0])
+		return exitUsage
+	}
+	fs := c.commandFlags(global, cmd)
+	fs.SetOutput(c.stdout)
+	fs.Usage()
+	return exitOK
+}
+
+// execute checks the flags of the command, loads the spec with the overrides of the flags and runs the command
+func (c *cli) execute(ctx context.Context, cmd command) error {
+	if !contains(cmd.formats, c.output) {
+		return usageError("unsupported output format %q for %s, expect one of %v", c.output, cmd.name, cmd.formats)
+	}
+	if c.fromStep != "" && c.onlyStep != "" {
+		return usageError("-from-step and -only-step could not be used together")
+	}
+
+	spec, err := k8ssetup.LoadSpec(c.configFile)
+	if err != nil {
+		return exitError{code: exitConfig, err: fmt.Errorf("error loading the spec, %v", err)}
+	}
+	// the flags are part of the spec so a state is only resumed on the cluster and the namespace that wrote it
+	if c.namespace != "" {
+		spec.Namespace = c.namespace
+	}
+	if c.kubeconfig != "" {
+		spec.Cluster.Kubeconfig = c.kubeconfig
+	}
+	if c.kubeContext != "" {
+		spec.Cluster.Context = c.kubeContext
+	}
+	return cmd.run(ctx, c, spec)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// runSteps runs the set up or the tear down, saves the state of its steps and writes its report
+func (c *cli) runSteps(ctx context.Context, spec *k8ssetup.Spec, down, resume bool) error {
+	options := k8ssetup.Options{ConfirmContext: c.confirmContext}
+	if c.dryRun {
+		options.Plan = k8ssetup.NewPlan()
+	}
+	stp := c.newSetUp(spec, options)
+	report := k8ssetup.NewReport()
+	r := newRunner(report, c.logger)
+	r.fromStep, r.onlyStep = c.fromStep, c.onlyStep
+	if err := r.loadState(spec, c.stateFile, resume, c.dryRun); err != nil {
+		return exitError{code: exitConfig, err: fmt.Errorf("error loading the state, %v", err)}
+	}
+
+	var err error
+	if down {
+		if err = teardown(ctx, stp, spec, r); err == nil && r.stateFile != "" {
+			if err = os.Remove(r.stateFile); os.IsNotExist(err) {
+				err = nil
+			}
+		}
+	} else {
+		err = run(ctx, stp, spec, r)
+	}
+	if options.Plan != nil {
+		// a json or junit report on stdout is read by a parser, the plan goes with the logs then
+		planOutput := c.stdout
+		if c.output != k8ssetup.TableReport && c.outputFile == "" {
+			planOutput = c.stderr
+		}
+		if planErr := options.Plan.Print(planOutput); planErr != nil && err == nil {
+			err = fmt.Errorf("error printing the plan, %v", planErr)
+		}
+	}
+	if reportErr := writeReport(c.stdout, report, c.output, c.outputFile); reportErr != nil && err == nil {
+		err = reportErr
+	}
+	return err
+}
+
+func cancelOnSignal(ctx context.Context, logger *k8ssetup.Logger, cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case sig := <-signals:
+		logger.Warnf(context.Background(), "Received %v, cancelling ...", sig)
+		cancel()
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"k8s/k8ssetup"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runTestCLI runs the program with a fake set up and a state file in a temporary dir
+func runTestCLI(t *testing.T, stp k8sSetUpFake, stateFile string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	c := newCLI(&out, &errOut, func(spec *k8ssetup.Spec, options k8ssetup.Options) k8ssetup.K8sSetUp {
+		return stp
+	})
+	code = c.run(append([]string{"-state-file", stateFile}, args...))
+	return code, out.String(), errOut.String()
+}
+
+func Test_cli(t *testing.T) {
+	type TestCase struct {
+		name   string
+		stp    k8sSetUpFake
+		args   []string
+		expect int
+		stdout string
+		stderr string
+	}
+
+	cases := []TestCase{
+		{
+			name:   "must set up and report the steps",
+			args:   []string{"up"},
+			expect: exitOK,
+			stdout: "STEP",
+		},
+		{
+			name:   "must return failed when a step fails",
+			stp:    k8sSetUpFake{failOnNamespaceCreation: true},
+			args:   []string{"up"},
+			expect: exitFailed,
+			stderr: "Error running up, error creating namespace, error on namespace creation",
+		},
+		{
+			name:   "must accept the global flags after the command",
+			args:   []string{"up", "-output", "json", "-only-step", "kudo"},
+			expect: exitOK,
+			stdout: `"failed": false`,
+		},
+		{
+			name:   "must tear down",
+			args:   []string{"down"},
+			expect: exitOK,
+			stdout: "teardown",
+		},
+		{
+			name:   "must plan the tear down",
+			args:   []string{"plan", "-down"},
+			expect: exitOK,
+			stdout: "Plan: 0 step(s)",
+		},
+		{
+			name:   "must return a usage error without command",
+			expect: exitUsage,
+			stderr: "Usage: pets-infrastructure [flags] <command>",
+		},
+		{
+			name:   "must return a usage error on an unknown command",
+			args:   []string{"deploy"},
+			expect: exitUsage,
+			stderr: `Unknown command "deploy"`,
+		},
+		{
+			name:   "must return a usage error on an unknown flag",
+			args:   []string{"up", "-force"},
+			expect: exitUsage,
+			stderr: "flag provided but not defined: -force",
+		},
+		{
+			name:   "must return a usage error on arguments after the flags",
+			args:   []string{"down", "now"},
+			expect: exitUsage,
+			stderr: "Unexpected arguments [now]",
+		},
+		{
+			name:   "must return a usage error when the steps flags are used together",
+			args:   []string{"up", "-from-step", "database", "-only-step", "kudo"},
+			expect: exitUsage,
+			stderr: "-from-step and -only-step could not be used together",
+		},
+		{
+			name:   "must return a usage error on an output format the command does not have",
+			args:   []string{"-output", "junit", "doctor"},
+			expect: exitUsage,
+			stderr: `unsupported output format "junit" for doctor`,
+		},
+		{
+			name:   "must return a config error when the spec could not be loaded",
+			args:   []string{"-config", "missing.yml", "up"},
+			expect: exitConfig,
+			stderr: "error loading the spec",
+		},
+		{
+			name:   "must return a config error when there is no state to resume from",
+			args:   []string{"-state-file", "", "resume"},
+			expect: exitConfig,
+			stderr: "no state file to resume from",
+		},
+		{
+			name:   "must print the help of the program",
+			args:   []string{"help"},
+			expect: exitOK,
+			stdout: "  resume  run up again, skipping the steps that the last run completed\n",
+		},
+		{
+			name:   "must print the help of a command",
+			args:   []string{"help", "plan"},
+			expect: exitOK,
+			stdout: "plan the changes of down instead of up",
+		},
+		{
+			name:   "must exit ok on the help flag",
+			args:   []string{"up", "-help"},
+			expect: exitOK,
+			stderr: "-only-step",
+		},
+		{
+			name: "must return failed when a check of the doctor fails",
+			stp: k8sSetUpFake{checklist: k8ssetup.Checklist{Checks: []k8ssetup.Check{
+				{Name: "kubectl", Detail: "not found"},
+			}}},
+			args:   []string{"doctor"},
+			expect: exitFailed,
+			stdout: "[FAIL] kubectl: not found",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runTestCLI(t, tt.stp, filepath.Join(t.TempDir(), "state.json"), tt.args...)
+			if code != tt.expect {
+				t.Fatalf("Got exit code %d, expect %d\nstdout: %s\nstderr: %s", code, tt.expect, stdout, stderr)
+			}
+			if !strings.Contains(stdout, tt.stdout) {
+				t.Fatalf("Got stdout %q, expect %q", stdout, tt.stdout)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Fatalf("Got stderr %q, expect %q", stderr, tt.stderr)
+			}
+		})
+	}
+}
+
+func Test_cliPlan(t *testing.T) {
+	t.Run("must print the plan on stderr when the report is json", func(t *testing.T) {
+		code, stdout, stderr := runTestCLI(t, k8sSetUpFake{}, filepath.Join(t.TempDir(), "state.json"), "-output", "json", "plan")
+		if code != exitOK || !strings.Contains(stderr, "Plan: 0 step(s)") {
+			t.Fatalf("Got %d and %q, expect the plan on stderr", code, stderr)
+		}
+		got := struct {
+			Failed bool `json:"failed"`
+		}{}
+		if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+			t.Fatalf("Got error %v, expect only the json report on stdout %q", err, stdout)
+		}
+	})
+}
+
+func Test_cliStatus(t *testing.T) {
+	healthy := k8ssetup.ComponentStatus{Name: "namespace/pets", Installed: true, Ready: true, Healthy: true}
+	failed := k8ssetup.ComponentStatus{Name: "database/pets-cluster", Installed: true, Job: "failed", Detail: "job failed"}
+
+	t.Run("must print the live state of every component", func(t *testing.T) {
+		code, stdout, _ := runTestCLI(t, k8sSetUpFake{status: k8ssetup.Status{Components: []k8ssetup.ComponentStatus{healthy}}}, "", "status")
+		if code != exitOK || !strings.Contains(stdout, "namespace/pets") {
+			t.Fatalf("Got %d and %q, expect the namespace", code, stdout)
+		}
+	})
+
+	t.Run("must return failed when a component is not healthy", func(t *testing.T) {
+		stp := k8sSetUpFake{status: k8ssetup.Status{Components: []k8ssetup.ComponentStatus{healthy, failed}}}
+		code, stdout, stderr := runTestCLI(t, stp, "", "-output", "json", "status")
+		if code != exitFailed || !strings.Contains(stderr, "1 component(s) not healthy") {
+			t.Fatalf("Got %d and %q, expect %d", code, stderr, exitFailed)
+		}
+		got := struct {
+			Healthy    bool                       `json:"healthy"`
+			Components []k8ssetup.ComponentStatus `json:"components"`
+		}{}
+		if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+			t.Fatalf("Got error %v, expect the status as json", err)
+		}
+		if got.Healthy || len(got.Components) != 2 || got.Components[1].Job != "failed" {
+			t.Fatalf("Got %+v, expect the failed database", got)
+		}
+	})
+
+	t.Run("must return failed when the cluster could not be probed", func(t *testing.T) {
+		if code, _, stderr := runTestCLI(t, k8sSetUpFake{failOnStatus: true}, "", "status"); code != exitFailed {
+			t.Fatalf("Got %d and %q, expect %d", code, stderr, exitFailed)
+		}
+	})
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	t.Run("must tell that no step has run", func(t *testing.T) {
+		code, stdout, _ := runTestCLI(t, k8sSetUpFake{}, stateFile, "status", "-steps")
+		if code != exitOK || stdout != "No step has run\n" {
+			t.Fatalf("Got %d and %q, expect no step", code, stdout)
+		}
+	})
+
+	t.Run("must print the failed step of the last run", func(t *testing.T) {
+		if code, _, _ := runTestCLI(t, k8sSetUpFake{failOnKafkaClusterCreation: true}, stateFile, "-namespace", "pets", "up"); code != exitFailed {
+			t.Fatalf("Got %d, expect the up failed", code)
+		}
+		code, stdout, _ := runTestCLI(t, k8sSetUpFake{}, stateFile, "-namespace", "pets", "-output", "json", "status", "-steps")
+		if code != exitFailed {
+			t.Fatalf("Got %d, expect %d", code, exitFailed)
+		}
+		state := k8ssetup.State{}
+		if err := json.Unmarshal([]byte(stdout), &state); err != nil {
+			t.Fatalf("Got error %v, expect the state as json", err)
+		}
+		for _, v := range state.Steps {
+			if v.ID == "kafka-cluster/pets" && v.Status == k8ssetup.StatusFailed {
+				return
+			}
+		}
+		t.Fatalf("Got %+v, expect the failed kafka cluster", state.Steps)
+	})
+
+	t.Run("must not read the state of another namespace", func(t *testing.T) {
+		if code, _, stderr := runTestCLI(t, k8sSetUpFake{}, stateFile, "status", "-steps"); code != exitConfig {
+			t.Fatalf("Got %d and %q, expect %d", code, stderr, exitConfig)
+		}
+	})
+
+	t.Run("must remove the state once down", func(t *testing.T) {
+		if code, _, _ := runTestCLI(t, k8sSetUpFake{}, stateFile, "-namespace", "pets", "down"); code != exitOK {
+			t.Fatalf("Got %d, expect %d", code, exitOK)
+		}
+		if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+			t.Fatalf("Got %v, expect the state file removed", err)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package k8ssetup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	autoBuilder    = "auto"
+	dockerBuilder  = "docker"
+	podmanBuilder  = "podman"
+	buildahBuilder = "buildah"
+	nerdctlBuilder = "nerdctl"
+)
+
+// pushDigestRegex is the digest in the output of docker push, like "latest: digest: sha256:... size: 1234"
+var pushDigestRegex = regexp.MustCompile(`digest: (sha256:[a-f0-9]{64})`)
+
+// imageBuilderTools are the supported tools in the order they are detected
+var imageBuilderTools = []string{dockerBuilder, podmanBuilder, nerdctlBuilder, buildahBuilder}
+
+// imageBuild is an image to build from a dockerfile, args are passed as --build-arg and target is the stage to build
+type imageBuild struct {
+	context    string
+	dockerFile string
+	tag        string
+	args       map[string]string
+	target     string
+}
+
+// imageBuilder is how we build and push the images of the jobs
+type imageBuilder interface {
+	name() string
+	build(ctx context.Context, image imageBuild) error
+	// push returns the digest of the pushed manifest, empty when the tool does not tell it
+	push(ctx context.Context, tag, configDir string) (string, error)
+	// version returns the version of the daemon, or of the tool when it has no daemon
+	version(ctx context.Context) (string, error)
+}
+
+// cliImageBuilder runs a container tool, docker, podman and nerdctl have the same build command, buildah has its own
+type cliImageBuilder struct {
+	k    *k8sSetUpImpl
+	tool string
+	path string
+}
+
+func newImageBuilder(k *k8sSetUpImpl, tool, path string) imageBuilder {
+	return cliImageBuilder{k: k, tool: tool, path: path}
+}
+
+// builderCommand returns the command of a tool, they are vars so they could be changed
+func builderCommand(tool string) string {
+	switch tool {
+	case podmanBuilder:
+		return podmanCommand
+	case buildahBuilder:
+		return buildahCommand
+	case nerdctlBuilder:
+		return nerdctlCommand
+	default:
+		return dockerCommand
+	}
+}
+
+func (b cliImageBuilder) name() string {
+	return b.tool
+}
+
+func (b cliImageBuilder) build(ctx context.Context, image imageBuild) error {
+	var params []string
+	if b.tool == buildahBuilder {
+		params = []string{"bud", "-f", image.dockerFile, "-t", image.tag}
+	} else {
+		params = []string{"build", image.context, "-f", image.dockerFile, "-t", image.tag}
+	}
+
+	names := make([]string, 0, len(image.args))
+	for name := range image.args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		params = append(params, "--build-arg", name+"="+image.args[name])
+	}
+	if image.target != "" {
+		params = append(params, "--target", image.target)
+	}
+
+	if b.tool == buildahBuilder {
+		params = append(params, image.context)
+	}
+	_, err := b.k.executeCommand(ctx, b.path, params...)
+	return err
+}
+
+// push sends the image to the registry, configDir is a docker config with the credentials when they are not the builder ones,
+// podman and buildah write the digest in a file and docker tells it in its output
+func (b cliImageBuilder) push(ctx context.Context, tag, configDir string) (string, error) {
+	params := []string{"push"}
+	if configDir != "" {
+		switch b.tool {
+		case dockerBuilder:
+			params = []string{"--config", configDir, "push"}
+		case podmanBuilder, buildahBuilder:
+			params = append(params, "--authfile", filepath.Join(configDir, "config.json"))
+		default:
+			logger.Warnf(ctx, "%s pushes with its own login, the registry credentials of the spec are not used", b.tool)
+		}
+	}
+	// docker trusts a plain http or insecure registry in its daemon config, the others need to be told on every push
+	if strings.HasPrefix(b.k.dockerRegistry, "http://") || b.k.spec.Registry.Insecure {
+		switch b.tool {
+		case podmanBuilder, buildahBuilder:
+			params = append(params, "--tls-verify=false")
+		case nerdctlBuilder:
+			params = append(params, "--insecure-registry")
+		}
+	}
+	digestFile := ""
+	if b.tool == podmanBuilder || b.tool == buildahBuilder {
+		file, err := ioutil.TempFile("", "image-digest")
+		if err != nil {
+			return "", fmt.Errorf("error creating digest file: %v", err)
+		}
+		file.Close()
+		digestFile = file.Name()
+		defer removeFile(digestFile)
+		params = append(params, "--digestfile", digestFile)
+	}
+	output, err := b.k.executeCommand(ctx, b.path, append(params, tag)...)
+	if err != nil {
+		return "", err
+	}
+	if digestFile != "" {
+		content, err := ioutil.ReadFile(digestFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading digest file %q: %v", digestFile, err)
+		}
+		output = "digest: " + strings.TrimSpace(string(content))
+	}
+	if match := pushDigestRegex.FindStringSubmatch(output); match != nil {
+		return match[1], nil
+	}
+	return "", nil
+}
+
+// versionParams ask docker and nerdctl for their daemon, podman for its service and buildah for itself
+var versionParams = map[string][]string{
+	dockerBuilder:  {"version", "--format", "{{.Server.Version}}"},
+	podmanBuilder:  {"version", "--format", "{{.Version}}"},
+	buildahBuilder: {"version", "--json"},
+	nerdctlBuilder: {"info", "--format", "{{.ServerVersion}}"},
+}
+
+func (b cliImageBuilder) version(ctx context.Context) (string, error) {
+	output, err := b.k.executeCommand(ctx, b.path, versionParams[b.tool]...)
+	if err != nil {
+		return "", err
+	}
+	if b.tool == buildahBuilder {
+		version := struct {
+			Version string `json:"version"`
+		}{}
+		if err = json.Unmarshal([]byte(output), &version); err != nil {
+			return "", fmt.Errorf("invalid json: %v", err)
+		}
+		return version.Version, nil
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// findImageBuilder returns the tool of the spec or the first supported tool found in the path
+func (k *k8sSetUpImpl) findImageBuilder() (imageBuilder, error) {
+	tools := imageBuilderTools
+	if tool := k.spec.Builder.Tool; tool != autoBuilder {
+		tools = []string{tool}
+	}
+	for _, tool := range tools {
+		path, err := k.findCommandPath(builderCommand(tool))
+		if err == nil {
+			return newImageBuilder(k, tool, path), nil
+		}
+		if len(tools) == 1 {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no image builder found, tried %s", strings.Join(tools, ", "))
+}
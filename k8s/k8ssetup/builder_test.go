@@ -0,0 +1,203 @@
+package k8ssetup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_imageBuilder(t *testing.T) {
+	type TestCase struct {
+		name      string
+		tool      string
+		registry  string
+		configDir string
+		output    string
+		expect    []string
+		digest    string
+	}
+
+	image := imageBuild{
+		context:    "..",
+		dockerFile: "Dockerfile-cluster-job",
+		tag:        "localhost:5000/cluster-job",
+		args:       map[string]string{"VERSION": "1.0", "BASE": "alpine"},
+		target:     "release",
+	}
+
+	buildCases := []TestCase{
+		{
+			name:   "docker builds with the context first",
+			tool:   dockerBuilder,
+			expect: []string{"build", "..", "-f", "Dockerfile-cluster-job", "-t", "localhost:5000/cluster-job", "--build-arg", "BASE=alpine", "--build-arg", "VERSION=1.0", "--target", "release"},
+		},
+		{
+			name:   "podman builds like docker",
+			tool:   podmanBuilder,
+			expect: []string{"build", "..", "-f", "Dockerfile-cluster-job", "-t", "localhost:5000/cluster-job", "--build-arg", "BASE=alpine", "--build-arg", "VERSION=1.0", "--target", "release"},
+		},
+		{
+			name:   "buildah builds with bud and the context last",
+			tool:   buildahBuilder,
+			expect: []string{"bud", "-f", "Dockerfile-cluster-job", "-t", "localhost:5000/cluster-job", "--build-arg", "BASE=alpine", "--build-arg", "VERSION=1.0", "--target", "release", ".."},
+		},
+	}
+
+	for _, tt := range buildCases {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+			var got []string
+			k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+				if cmdName != "/usr/bin/"+tt.tool {
+					t.Fatalf("Got command %q, expect %q", cmdName, tt.tool)
+				}
+				got = params
+				return "", nil
+			}
+			builder := newImageBuilder(k8sImpl, tt.tool, "/usr/bin/"+tt.tool)
+			if err := builder.build(context.Background(), image); err != nil {
+				t.Fatalf("Got error %v, expect nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Fatalf("Got %v, expect %v", got, tt.expect)
+			}
+		})
+	}
+
+	pushCases := []TestCase{
+		{
+			name:     "docker pushes to a plain http registry",
+			tool:     dockerBuilder,
+			registry: "http://localhost:5000",
+			output:   "latest: digest: " + testImageDigest + " size: 528",
+			expect:   []string{"push", "localhost:5000/cluster-job"},
+			digest:   testImageDigest,
+		},
+		{
+			name:     "podman pushes to a plain http registry without tls verify",
+			tool:     podmanBuilder,
+			registry: "http://localhost:5000",
+			expect:   []string{"push", "--tls-verify=false", "--digestfile", "<digest file>", "localhost:5000/cluster-job"},
+			digest:   testImageDigest,
+		},
+		{
+			name:     "buildah pushes to a https registry with tls verify",
+			tool:     buildahBuilder,
+			registry: "https://registry.example.com",
+			expect:   []string{"push", "--digestfile", "<digest file>", "localhost:5000/cluster-job"},
+			digest:   testImageDigest,
+		},
+		{
+			name:     "nerdctl pushes to a plain http registry as insecure",
+			tool:     nerdctlBuilder,
+			registry: "http://localhost:5000",
+			expect:   []string{"push", "--insecure-registry", "localhost:5000/cluster-job"},
+		},
+		{
+			name:      "docker pushes with the config of the credentials",
+			tool:      dockerBuilder,
+			registry:  "https://registry.example.com",
+			configDir: "/tmp/registry-auth",
+			expect:    []string{"--config", "/tmp/registry-auth", "push", "localhost:5000/cluster-job"},
+		},
+		{
+			name:      "buildah pushes with the auth file of the credentials",
+			tool:      buildahBuilder,
+			registry:  "https://registry.example.com",
+			configDir: "/tmp/registry-auth",
+			expect:    []string{"push", "--authfile", "/tmp/registry-auth/config.json", "--digestfile", "<digest file>", "localhost:5000/cluster-job"},
+			digest:    testImageDigest,
+		},
+	}
+
+	for _, tt := range pushCases {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+			k8sImpl.dockerRegistry = tt.registry
+			var got []string
+			k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+				got = append([]string{}, params...)
+				for i, v := range got {
+					if v == "--digestfile" {
+						_ = ioutil.WriteFile(got[i+1], []byte(testImageDigest+"\n"), 0644)
+						got[i+1] = "<digest file>"
+					}
+				}
+				return tt.output, nil
+			}
+			builder := newImageBuilder(k8sImpl, tt.tool, tt.tool)
+			digest, err := builder.push(context.Background(), image.tag, tt.configDir)
+			if err != nil {
+				t.Fatalf("Got error %v, expect nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Fatalf("Got %v, expect %v", got, tt.expect)
+			}
+			if digest != tt.digest {
+				t.Fatalf("Got digest %q, expect %q", digest, tt.digest)
+			}
+		})
+	}
+}
+
+func Test_imageBuilderVersion(t *testing.T) {
+	type TestCase struct {
+		tool   string
+		output string
+		expect string
+	}
+
+	cases := []TestCase{
+		{tool: dockerBuilder, output: "20.10.7\n", expect: "20.10.7"},
+		{tool: podmanBuilder, output: "3.2.1\n", expect: "3.2.1"},
+		{tool: buildahBuilder, output: `{"version": "1.21.0", "goVersion": "go1.16.4"}`, expect: "1.21.0"},
+		{tool: nerdctlBuilder, output: "v1.5.2\n", expect: "v1.5.2"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.tool, func(t *testing.T) {
+			k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+			k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+				if !reflect.DeepEqual(params, versionParams[tt.tool]) {
+					t.Fatalf("Got %v, expect %v", params, versionParams[tt.tool])
+				}
+				return tt.output, nil
+			}
+			got, err := newImageBuilder(k8sImpl, tt.tool, tt.tool).version(context.Background())
+			if err != nil || got != tt.expect {
+				t.Fatalf("Got %q and error %v, expect %q", got, err, tt.expect)
+			}
+		})
+	}
+}
+
+func Test_createDatabaseJobBuildArgs(t *testing.T) {
+	// setup
+	wd, _ := os.Getwd()
+	os.Chdir("_test")
+	defer os.Chdir(wd)
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	k8sImpl.builder = newImageBuilder(k8sImpl, podmanBuilder, "podman")
+	k8sImpl.registry = newDigestRegistry(t)
+	var build string
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		if params[0] == "build" {
+			build = cmdName + " " + strings.Join(params, " ")
+		}
+		if output, ok := succeededJob(params); ok {
+			return output, nil
+		}
+		return "", nil
+	}
+
+	got := k8sImpl.createDatabaseJob(context.Background(), "cluster", JobSpec{BuildArgs: map[string]string{"VERSION": "1.0"}, Target: "release"}, 0)
+	if got != nil {
+		t.Fatalf("Got error %v, expect nil", got)
+	}
+	if expect := "podman build .. -f Dockerfile-cluster-job -t /cluster-job --build-arg VERSION=1.0 --target release"; build != expect {
+		t.Fatalf("Got %q, expect %q", build, expect)
+	}
+}
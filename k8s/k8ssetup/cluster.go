@@ -0,0 +1,201 @@
+package k8ssetup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	kubectlBackend = "kubectl"
+	restBackend    = "rest"
+)
+
+// clusterClient is how we talk with the cluster, resources are referenced as kind/name like kubectl does,
+// the objects of a file without namespace are created in the given namespace
+type clusterClient interface {
+	listResources(ctx context.Context, kind, selector, namespace string) ([]string, error)
+	resourceExists(ctx context.Context, kind, name, namespace string) (bool, error)
+	getField(ctx context.Context, ref, namespace, path string) (string, error)
+	getObject(ctx context.Context, ref, namespace string) (map[string]interface{}, error)
+	createNamespace(ctx context.Context, name string) error
+	create(ctx context.Context, fileName, namespace string) ([]string, error)
+	apply(ctx context.Context, fileName, namespace string) error
+	deleteFile(ctx context.Context, fileName, namespace string) error
+	deleteResource(ctx context.Context, ref, namespace string) error
+	deleteSelected(ctx context.Context, kind, selector, namespace string) error
+	logs(ctx context.Context, podRef, namespace string) (string, error)
+	exec(ctx context.Context, podRef, namespace string, command ...string) (string, error)
+	kudo(ctx context.Context, params ...string) (string, error)
+	// currentContext, serverVersion, canI and listObjects only read, the doctor uses them before any change
+	currentContext(ctx context.Context) (name, server string, err error)
+	serverVersion(ctx context.Context) (string, error)
+	canI(ctx context.Context, verb, resource, namespace string) (bool, error)
+	listObjects(ctx context.Context, kind, namespace string) ([]map[string]interface{}, error)
+}
+
+// kubectlClient runs kubectl for every call
+type kubectlClient struct {
+	k *k8sSetUpImpl
+}
+
+func (c kubectlClient) listResources(ctx context.Context, kind, selector, namespace string) (names []string, err error) {
+	params := []string{"get", kind, "-o", "name", "-n", namespace}
+	if selector != "" {
+		params = append(params, "-l", selector)
+	}
+	var output string
+	if output, err = c.k.kubectl(ctx, params...); err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(output, "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+func (c kubectlClient) resourceExists(ctx context.Context, kind, name, namespace string) (bool, error) {
+	if _, err := c.k.kubectl(ctx, "describe", kind+"/"+name, "-n", namespace); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c kubectlClient) getField(ctx context.Context, ref, namespace, path string) (string, error) {
+	output, err := c.k.kubectl(ctx, "get", ref, "-o", "jsonpath='{"+path+"}'", "-n", namespace)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimSpace(output), "'"), nil
+}
+
+func (c kubectlClient) getObject(ctx context.Context, ref, namespace string) (object map[string]interface{}, err error) {
+	var output string
+	if output, err = c.k.kubectl(ctx, "get", ref, "-o", "json", "-n", namespace); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(output), &object); err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+	return
+}
+
+func (c kubectlClient) createNamespace(ctx context.Context, name string) error {
+	_, err := c.k.kubectl(ctx, "create", "namespace", name)
+	return err
+}
+
+func (c kubectlClient) create(ctx context.Context, fileName, namespace string) (refs []string, err error) {
+	var output string
+	if output, err = c.k.kubectl(ctx, "create", "-f", fileName, "-n", namespace); err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[1] == "created" {
+			refs = append(refs, fields[0])
+		}
+	}
+	return
+}
+
+func (c kubectlClient) apply(ctx context.Context, fileName, namespace string) error {
+	_, err := c.k.kubectl(ctx, "apply", "-f", fileName, "-n", namespace)
+	return err
+}
+
+func (c kubectlClient) deleteFile(ctx context.Context, fileName, namespace string) error {
+	_, err := c.k.kubectl(ctx, "delete", "-f", fileName, "-n", namespace, "--ignore-not-found")
+	return err
+}
+
+func (c kubectlClient) deleteResource(ctx context.Context, ref, namespace string) error {
+	_, err := c.k.kubectl(ctx, "delete", ref, "-n", namespace, "--ignore-not-found")
+	return err
+}
+
+func (c kubectlClient) deleteSelected(ctx context.Context, kind, selector, namespace string) error {
+	_, err := c.k.kubectl(ctx, "delete", kind, "-l", selector, "-n", namespace, "--ignore-not-found")
+	return err
+}
+
+func (c kubectlClient) logs(ctx context.Context, podRef, namespace string) (string, error) {
+	return c.k.kubectl(ctx, "logs", podRef, "-n", namespace)
+}
+
+func (c kubectlClient) exec(ctx context.Context, podRef, namespace string, command ...string) (string, error) {
+	return c.k.kubectl(ctx, append([]string{"exec", podRef, "-n", namespace, "--"}, command...)...)
+}
+
+// kudo runs the plugin without the kubectl flags, kubectl does not accept flags before the name of a plugin
+func (c kubectlClient) kudo(ctx context.Context, params ...string) (string, error) {
+	return c.k.runKudo(ctx, c.k.kubectlPath, append([]string{"kudo"}, params...)...)
+}
+
+// currentContext returns the context of the spec, config current-context ignores the context flag
+func (c kubectlClient) currentContext(ctx context.Context) (name, server string, err error) {
+	if name = c.k.spec.Cluster.Context; name == "" {
+		if name, err = c.k.kubectl(ctx, "config", "current-context"); err != nil {
+			return "", "", err
+		}
+	}
+	if server, err = c.k.kubectl(ctx, "config", "view", "--minify", "-o", "jsonpath={.clusters[0].cluster.server}"); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(name), strings.TrimSpace(server), nil
+}
+
+func (c kubectlClient) serverVersion(ctx context.Context) (string, error) {
+	output, err := c.k.kubectl(ctx, "version", "-o", "json")
+	if err != nil {
+		return "", err
+	}
+	version := struct {
+		ServerVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"serverVersion"`
+	}{}
+	if err = json.Unmarshal([]byte(output), &version); err != nil {
+		return "", fmt.Errorf("invalid json: %v", err)
+	}
+	return version.ServerVersion.GitVersion, nil
+}
+
+// canI runs kubectl auth can-i, it answers no with an error exit code
+func (c kubectlClient) canI(ctx context.Context, verb, resource, namespace string) (bool, error) {
+	params := []string{"auth", "can-i", verb, resource}
+	if namespace != "" {
+		params = append(params, "-n", namespace)
+	}
+	output, err := c.k.kubectl(ctx, params...)
+	switch strings.TrimSpace(output) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("unexpected answer %q", strings.TrimSpace(output))
+	}
+	return false, err
+}
+
+func (c kubectlClient) listObjects(ctx context.Context, kind, namespace string) ([]map[string]interface{}, error) {
+	params := []string{"get", kind, "-o", "json"}
+	if namespace != "" {
+		params = append(params, "-n", namespace)
+	}
+	output, err := c.k.kubectl(ctx, params...)
+	if err != nil {
+		return nil, err
+	}
+	list := struct {
+		Items []map[string]interface{} `json:"items"`
+	}{}
+	if err = json.Unmarshal([]byte(output), &list); err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+	return list.Items, nil
+}
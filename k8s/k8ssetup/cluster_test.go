@@ -0,0 +1,158 @@
+package k8ssetup
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_kubectlClientListResources(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	client := kubectlClient{k: k8sImpl}
+
+	t.Run("must return the resource names", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if strings.Join(params, " ") == "get pod -o name -n default -l app=test" {
+				return "pod/test-0\npod/test-1\n", nil
+			}
+			return "", errors.New("unexpected command")
+		}
+
+		expect := []string{"pod/test-0", "pod/test-1"}
+		got, gotErr := client.listResources(context.Background(), "pod", "app=test", "default")
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must return error when kubectl fails", func(t *testing.T) {
+		var errInvalid = errors.New("invalid")
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			return "", errInvalid
+		}
+
+		got, gotErr := client.listResources(context.Background(), "pod", "", "default")
+		if gotErr != errInvalid {
+			t.Fatalf("Got error %v, expect %v", gotErr, errInvalid)
+		}
+		if got != nil {
+			t.Fatalf("Got %v, expect nil", got)
+		}
+	})
+}
+
+func Test_kubectlClientGetField(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	client := kubectlClient{k: k8sImpl}
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		if strings.Join(params, " ") == "get pod/test-0 -o jsonpath='{.status.phase}' -n default" {
+			return "'Running'", nil
+		}
+		return "", errors.New("unexpected command")
+	}
+
+	got, gotErr := client.getField(context.Background(), "pod/test-0", "default", ".status.phase")
+	if gotErr != nil {
+		t.Fatalf("Got error %v, expect nil", gotErr)
+	}
+	if got != "Running" {
+		t.Fatalf("Got %q, expect %q", got, "Running")
+	}
+}
+
+func Test_kubectlClientCreate(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	client := kubectlClient{k: k8sImpl}
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		if strings.Join(params, " ") == "create -f job.yml -n pets" {
+			return "job.batch/petstore-pets-cluster-run-x1 created\nconfigmap/petstore created\n", nil
+		}
+		return "", errors.New("unexpected command")
+	}
+
+	expect := []string{"job.batch/petstore-pets-cluster-run-x1", "configmap/petstore"}
+	got, gotErr := client.create(context.Background(), "job.yml", "pets")
+	if gotErr != nil {
+		t.Fatalf("Got error %v, expect nil", gotErr)
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("Got %v, expect %v", got, expect)
+	}
+}
+
+func Test_kubectlClientKudo(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	client := kubectlClient{k: k8sImpl}
+	var got []string
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		got = params
+		return "", nil
+	}
+
+	if _, err := client.kudo(context.Background(), "get", "instances"); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	if expect := []string{"kudo", "get", "instances"}; !reflect.DeepEqual(got, expect) {
+		t.Fatalf("Got %v, expect %v", got, expect)
+	}
+
+	t.Run("must pin the context of the spec in a kubeconfig", func(t *testing.T) {
+		k8sImpl.spec = DefaultSpec()
+		k8sImpl.spec.Cluster.Kubeconfig = writeTestKubeconfig(t)
+		k8sImpl.spec.Cluster.Context = "prod"
+		var pinned string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			got = params
+			content, err := ioutil.ReadFile(params[len(params)-1])
+			pinned = string(content)
+			return "", err
+		}
+
+		if _, err := client.kudo(context.Background(), "get", "instances"); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if len(got) != 5 || got[3] != "--kubeconfig" || !strings.Contains(pinned, "current-context: prod\n") {
+			t.Fatalf("Got %v with %q, expect the kubeconfig of the prod context", got, pinned)
+		}
+		if _, err := os.Stat(got[4]); !os.IsNotExist(err) {
+			t.Fatalf("Got %v, expect the pinned kubeconfig removed", err)
+		}
+	})
+}
+
+func Test_kubectlClientCurrentContext(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	client := kubectlClient{k: k8sImpl}
+	var commands []string
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		commands = append(commands, strings.Join(params, " "))
+		if params[1] == "current-context" {
+			return "dev\n", nil
+		}
+		return "https://dev.example.com:6443", nil
+	}
+
+	t.Run("must return the current context", func(t *testing.T) {
+		name, server, err := client.currentContext(context.Background())
+		if err != nil || name != "dev" || server != "https://dev.example.com:6443" {
+			t.Fatalf("Got %q at %q and error %v, expect dev", name, server, err)
+		}
+	})
+
+	t.Run("must return the context of the spec", func(t *testing.T) {
+		k8sImpl.spec.Cluster.Context = "prod"
+		commands = nil
+		name, _, err := client.currentContext(context.Background())
+		expect := []string{"config --context prod view --minify -o jsonpath={.clusters[0].cluster.server}"}
+		if err != nil || name != "prod" || !reflect.DeepEqual(commands, expect) {
+			t.Fatalf("Got %q and %v, expect prod and %v", name, commands, expect)
+		}
+	})
+}
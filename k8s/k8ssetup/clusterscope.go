@@ -0,0 +1,222 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const clusterRoleBindingKind = "ClusterRoleBinding"
+
+// clusterScopedKinds are the kinds of the operator manifests that are not in a namespace, every environment of the
+// cluster shares them
+var clusterScopedKinds = map[string]bool{
+	"ClusterRole":          true,
+	clusterRoleBindingKind: true,
+}
+
+// splitManifest returns a temp file with the objects of the manifest that go in our namespace, empty when there are
+// none, and the cluster scoped objects
+func splitManifest(fileName string) (namespaced string, clusterScoped []manifestObject, err error) {
+	objects, err := readManifest(fileName)
+	if err != nil {
+		return "", nil, err
+	}
+	var others []manifestObject
+	for _, v := range objects {
+		if clusterScopedKinds[v.Kind] {
+			clusterScoped = append(clusterScoped, v)
+		} else {
+			others = append(others, v)
+		}
+	}
+	if len(others) == 0 {
+		return "", clusterScoped, nil
+	}
+	if namespaced, err = writeManifest(fileName, others); err != nil {
+		return "", nil, err
+	}
+	return namespaced, clusterScoped, nil
+}
+
+// writeManifest writes the objects in a temp file named after the manifest and returns its name
+func writeManifest(fileName string, objects []manifestObject) (string, error) {
+	var content bytes.Buffer
+	encoder := yaml.NewEncoder(&content)
+	for _, v := range objects {
+		if err := encoder.Encode(v.body()); err != nil {
+			return "", fmt.Errorf("error writting manifest %q: %v", fileName, err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("error writting manifest %q: %v", fileName, err)
+	}
+
+	_, onlyFileName := path.Split(fileName)
+	newFile, err := ioutil.TempFile("", "*-"+onlyFileName)
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file for %q", fileName)
+	}
+	defer newFile.Close()
+	if _, err = newFile.Write(content.Bytes()); err != nil {
+		removeFile(newFile.Name())
+		return "", fmt.Errorf("error writting in temp file %q", newFile.Name())
+	}
+	return newFile.Name(), nil
+}
+
+func objectRef(object manifestObject) string {
+	return strings.ToLower(object.Kind) + "/" + object.metadata("name")
+}
+
+// subjects returns the subjects of a binding
+func subjects(object map[string]interface{}) (subjects []map[string]interface{}) {
+	items, _ := object["subjects"].([]interface{})
+	for _, v := range items {
+		if subject, ok := v.(map[string]interface{}); ok {
+			subjects = append(subjects, subject)
+		}
+	}
+	return
+}
+
+func subjectKey(subject map[string]interface{}) string {
+	return fmt.Sprintf("%v/%v/%v", subject["kind"], subject["namespace"], subject["name"])
+}
+
+// mergeSubjects returns the subjects of the binding in the cluster with ours added, changed is false when it
+// already had all of them
+func mergeSubjects(live, ours []map[string]interface{}) (merged []interface{}, changed bool) {
+	keys := map[string]bool{}
+	for _, v := range live {
+		keys[subjectKey(v)] = true
+		merged = append(merged, v)
+	}
+	for _, v := range ours {
+		if !keys[subjectKey(v)] {
+			merged = append(merged, v)
+			changed = true
+		}
+	}
+	return
+}
+
+// removeSubjects returns the subjects of the binding in the cluster without ours
+func removeSubjects(live, ours []map[string]interface{}) (remaining []interface{}) {
+	keys := map[string]bool{}
+	for _, v := range ours {
+		keys[subjectKey(v)] = true
+	}
+	for _, v := range live {
+		if !keys[subjectKey(v)] {
+			remaining = append(remaining, v)
+		}
+	}
+	return
+}
+
+// applySubjects updates the subjects of a binding with the binding of the manifest
+func (k k8sSetUpImpl) applySubjects(ctx context.Context, object manifestObject, subjects []interface{}) error {
+	updated := object
+	updated.Fields = map[string]interface{}{}
+	for key, value := range object.Fields {
+		updated.Fields[key] = value
+	}
+	updated.Fields["subjects"] = subjects
+	fileName, err := writeManifest(object.metadata("name")+".yaml", []manifestObject{updated})
+	if err != nil {
+		return err
+	}
+	defer removeFile(fileName)
+	return k.cluster.apply(ctx, fileName, k.namespace())
+}
+
+// ensureClusterScoped creates a cluster scoped object when it is missing, a binding that exists gets the subjects
+// of our namespace
+func (k k8sSetUpImpl) ensureClusterScoped(ctx context.Context, object manifestObject) error {
+	ref := objectRef(object)
+	if exists, err := k.cluster.resourceExists(ctx, strings.ToLower(object.Kind), object.metadata("name"), k.namespace()); err != nil || !exists {
+		fileName, err := writeManifest(object.metadata("name")+".yaml", []manifestObject{object})
+		if err != nil {
+			return err
+		}
+		defer removeFile(fileName)
+		if _, err = k.cluster.create(ctx, fileName, k.namespace()); err != nil {
+			return fmt.Errorf("error creating %s: %v", ref, err)
+		}
+		recordResource(ctx, ref, resourceCreated)
+		return nil
+	}
+	if object.Kind != clusterRoleBindingKind {
+		logger.Infof(ctx, "%s already exists ...", ref)
+		recordResource(ctx, ref, resourcePresent)
+		return nil
+	}
+
+	live, err := k.cluster.getObject(ctx, ref, k.namespace())
+	if err != nil {
+		return fmt.Errorf("error getting %s: %v", ref, err)
+	}
+	merged, changed := mergeSubjects(subjects(live), subjects(object.Fields))
+	if !changed {
+		recordResource(ctx, ref, resourcePresent)
+		return nil
+	}
+	logger.Infof(ctx, "Adding the subjects of namespace %q to %s ...", k.namespace(), ref)
+	if err = k.applySubjects(ctx, object, merged); err != nil {
+		return fmt.Errorf("error updating %s: %v", ref, err)
+	}
+	recordResource(ctx, ref, resourceUpdated)
+	return nil
+}
+
+// releaseClusterScoped removes the subjects of our namespace from the bindings, the objects are only deleted when
+// no binding has the subjects of another namespace
+func (k k8sSetUpImpl) releaseClusterScoped(ctx context.Context, objects []manifestObject) (remaining []string) {
+	inUse := false
+	for _, object := range objects {
+		if object.Kind != clusterRoleBindingKind {
+			continue
+		}
+		ref := objectRef(object)
+		live, err := k.cluster.getObject(ctx, ref, k.namespace())
+		if err != nil {
+			// it is already deleted or we could not read it, deleting it later tells which one
+			continue
+		}
+		others := removeSubjects(subjects(live), subjects(object.Fields))
+		if len(others) == 0 {
+			continue
+		}
+		inUse = true
+		if len(others) == len(subjects(live)) {
+			continue
+		}
+		logger.Infof(ctx, "Removing the subjects of namespace %q from %s ...", k.namespace(), ref)
+		if err = k.applySubjects(ctx, object, others); err != nil {
+			remaining = append(remaining, fmt.Sprintf("%s (%v)", ref, err))
+		} else {
+			recordResource(ctx, ref, resourceUpdated)
+		}
+	}
+	if inUse {
+		logger.Infof(ctx, "Keeping the cluster scoped objects of the operator, another namespace uses them ...")
+		return
+	}
+
+	for i := len(objects) - 1; i >= 0; i-- {
+		ref := objectRef(objects[i])
+		logger.Infof(ctx, "Deleting %s ...", ref)
+		if err := k.cluster.deleteResource(ctx, ref, k.namespace()); err != nil && !isNotFound(err) {
+			remaining = append(remaining, fmt.Sprintf("%s (%v)", ref, err))
+		} else {
+			recordResource(ctx, ref, resourceDeleted)
+		}
+	}
+	return
+}
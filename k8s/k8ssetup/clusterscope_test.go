@@ -0,0 +1,147 @@
+package k8ssetup
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testOperatorManifests are operator manifests like the ones of the repository, written for the default namespace
+var testOperatorManifests = map[string]string{
+	"configmap.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: postgres-operator
+`,
+	"operator-service-account-rbac.yaml": `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: postgres-operator
+  namespace: default
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: postgres-operator
+rules:
+- apiGroups: ["acid.zalan.do"]
+  resources: ["postgresqls"]
+  verbs: ["get", "list", "watch"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: postgres-operator
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: postgres-operator
+subjects:
+- kind: ServiceAccount
+  name: postgres-operator
+  namespace: default
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: postgres-pod
+rules:
+- apiGroups: [""]
+  resources: ["endpoints"]
+  verbs: ["get"]
+`,
+	"postgres-operator.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: postgres-operator
+`,
+	"api-service.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: postgres-operator
+`,
+}
+
+const (
+	testBindingPath     = "/apis/rbac.authorization.k8s.io/v1/clusterrolebindings/postgres-operator"
+	testClusterRolePath = "/apis/rbac.authorization.k8s.io/v1/clusterroles/postgres-operator"
+	testPodRolePath     = "/apis/rbac.authorization.k8s.io/v1/clusterroles/postgres-pod"
+)
+
+func Test_psqlOperatorInTwoNamespaces(t *testing.T) {
+	fake := newFakeAPIServer(t)
+	dir := tempDir(t)
+	for name, content := range testOperatorManifests {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+	}
+	newOperatorSetUp := func(namespace string) *k8sSetUpImpl {
+		spec := DefaultSpec()
+		spec.Namespace = namespace
+		spec.Operator.Source = directoryOperatorSource
+		spec.Operator.Directory = dir
+		spec.Timeouts.Deletion = Duration(time.Second)
+		k8sImpl := NewK8sSetUpWithSpec(spec, Options{}).(*k8sSetUpImpl)
+		k8sImpl.cluster = fake.newClient(t, nil)
+		return k8sImpl
+	}
+	subjectNamespaces := func() (namespaces []string) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		for _, v := range subjects(fake.objects[testBindingPath]) {
+			namespaces = append(namespaces, v["namespace"].(string))
+		}
+		return
+	}
+	exists := func(path string) bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		_, ok := fake.objects[path]
+		return ok
+	}
+	first, second := newOperatorSetUp("pets-a"), newOperatorSetUp("pets-b")
+
+	t.Run("must share the cluster scoped objects with a binding subject per namespace", func(t *testing.T) {
+		for _, k8sImpl := range []*k8sSetUpImpl{first, second} {
+			if err := k8sImpl.doPsqlOperatorInstallation(context.Background()); err != nil {
+				t.Fatalf("Got error %v installing in %s, expect nil", err, k8sImpl.namespace())
+			}
+		}
+		if got, expect := subjectNamespaces(), []string{"pets-a", "pets-b"}; !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got subjects in %v, expect %v", got, expect)
+		}
+		for _, v := range []string{"/api/v1/namespaces/pets-a/serviceaccounts/postgres-operator", "/api/v1/namespaces/pets-b/serviceaccounts/postgres-operator"} {
+			if !exists(v) {
+				t.Fatalf("Got %v, expect %s", fake.paths(), v)
+			}
+		}
+	})
+
+	t.Run("must keep the cluster scoped objects while another namespace uses them", func(t *testing.T) {
+		if got := first.uninstallPsqlOperator(context.Background()); len(got) != 0 {
+			t.Fatalf("Got %v, expect nothing remaining", got)
+		}
+		if got, expect := subjectNamespaces(), []string{"pets-b"}; !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got subjects in %v, expect %v", got, expect)
+		}
+		if !exists(testClusterRolePath) || !exists(testPodRolePath) {
+			t.Fatalf("Got %v, expect the cluster roles", fake.paths())
+		}
+		if exists("/api/v1/namespaces/pets-a/serviceaccounts/postgres-operator") {
+			t.Fatalf("Got %v, expect the service account of pets-a deleted", fake.paths())
+		}
+	})
+
+	t.Run("must delete the cluster scoped objects with the last namespace", func(t *testing.T) {
+		if got := second.uninstallPsqlOperator(context.Background()); len(got) != 0 {
+			t.Fatalf("Got %v, expect nothing remaining", got)
+		}
+		if got := fake.paths(); len(got) != 0 {
+			t.Fatalf("Got %v, expect nothing", got)
+		}
+	})
+}
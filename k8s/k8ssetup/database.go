@@ -1,31 +1,35 @@
 package k8ssetup
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v2"
 )
 
+var databaseFailureStatus = []string{"CreateFailed", "UpdateFailed", "SyncFailed", "Invalid"}
+
 // DatabaseYml is used to read a yml file in order to get the cluster name
-//noinspection GoStructTag
 type DatabaseYml struct {
 	Metadata struct {
-		Name string `yaml: "name"`
-	} `yaml: "metadata"`
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
 }
 
-func (k k8sSetUpImpl) isDatabaseCreated(cluster string) (bool, error) {
-	return k.isResourceCreated("postgresql", cluster, "default")
+func (k k8sSetUpImpl) isDatabaseCreated(ctx context.Context, cluster string) (bool, error) {
+	return k.isResourceCreated(ctx, "postgresql", cluster, k.namespace())
 }
 
-func (k k8sSetUpImpl) createDatabase(fileName string) error {
-	log.Println("Installing database ...")
-	_, err := k.kubectl("create", "-f", fileName)
+func (k k8sSetUpImpl) createDatabase(ctx context.Context, fileName string) error {
+	logger.Infof(ctx, "Installing database ...")
+	_, err := k.cluster.create(ctx, fileName, k.namespace())
 	return err
 }
 
@@ -49,50 +53,178 @@ func (k k8sSetUpImpl) getClusterName(fileName string) (clusterName string, err e
 	return
 }
 
-func (k k8sSetUpImpl) isDatabaseRunning(cluster string) (bool, error) {
-	log.Printf("Checking if database cluster %q is already running ...", cluster)
-	output, err := k.kubectl("get", "postgresql/"+cluster, "-o", "jsonpath={.status}")
+func (k k8sSetUpImpl) isDatabaseRunning(ctx context.Context, cluster string) (bool, error) {
+	logger.Infof(ctx, "Checking if database cluster %q is already running ...", cluster)
+	return k.databaseClusterRunning(ctx, cluster)
+}
+
+// databaseClusterRunning reads the status that the operator gives to a database cluster, failed ones are permanent
+// errors
+func (k k8sSetUpImpl) databaseClusterRunning(ctx context.Context, cluster string) (bool, error) {
+	output, err := k.cluster.getField(ctx, "postgresql/"+cluster, k.namespace(), ".status.PostgresClusterStatus")
 	if err != nil {
 		return false, err
 	}
+	for _, v := range databaseFailureStatus {
+		if strings.Contains(output, v) {
+			return false, permanentError{fmt.Errorf("database cluster %q status is %s", cluster, output)}
+		}
+	}
 
 	return strings.Contains(output, "Running"), nil
 }
 
-func (k k8sSetUpImpl) waitDatabaseCreation(cluster string) {
-	cnt := true
-	for cnt {
-		running, err := k.isDatabaseRunning(cluster)
-		cnt = !(err == nil && running)
+func (k k8sSetUpImpl) waitDatabaseCreation(ctx context.Context, cluster string) error {
+	if err := k.waitFor(ctx, fmt.Sprintf("database cluster %q running", cluster), k.spec.Timeouts.Database, func(ctx context.Context) (bool, error) {
+		return k.isDatabaseRunning(ctx, cluster)
+	}); err != nil {
+		return err
+	}
+	logger.Infof(ctx, "Database cluster %q is running", cluster)
+	return nil
+}
+
+// desiredDatabaseSpec returns the spec of the database cluster in the manifest as json would read it
+func desiredDatabaseSpec(fileName string) (spec interface{}, err error) {
+	var objects []manifestObject
+	if objects, err = readManifest(fileName); err != nil {
+		return nil, err
+	}
+	for _, v := range objects {
+		if strings.ToLower(v.Kind) == "postgresql" {
+			var content []byte
+			if content, err = json.Marshal(v.Fields["spec"]); err == nil {
+				err = json.Unmarshal(content, &spec)
+			}
+			return
+		}
+	}
+	return nil, fmt.Errorf("no postgresql found in %q", fileName)
+}
+
+// containsFields returns true when every field of desired has the same value in live, the
+// API server and the operator add defaults so live could have more fields
+func containsFields(live, desired interface{}) bool {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range d {
+			if !containsFields(l[k], v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return false
+		}
+		for i := range d {
+			if !containsFields(l[i], d[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(live, desired)
+	}
+}
+
+// isDatabaseUpToDate compares the database cluster in the cluster with its manifest
+func (k k8sSetUpImpl) isDatabaseUpToDate(ctx context.Context, fileName, cluster string) (bool, error) {
+	desired, err := desiredDatabaseSpec(fileName)
+	if err != nil {
+		return false, err
+	}
+	live, err := k.cluster.getObject(ctx, "postgresql/"+cluster, k.namespace())
+	if err != nil {
+		return false, err
+	}
+	return containsFields(live["spec"], desired), nil
+}
+
+// reconcileDatabase updates the database cluster in place when it differs from its manifest
+func (k k8sSetUpImpl) reconcileDatabase(ctx context.Context, fileName, cluster string) error {
+	upToDate, err := k.isDatabaseUpToDate(ctx, fileName, cluster)
+	if err != nil {
+		return err
+	}
+	if upToDate {
+		logger.Infof(ctx, "Database cluster %q is up to date ...", cluster)
+		recordResource(ctx, "postgresql/"+cluster, resourcePresent)
+		return nil
+	}
+	logger.Infof(ctx, "Database cluster %q differs from its manifest, updating it ...", cluster)
+	if err = k.cluster.apply(ctx, fileName, k.namespace()); err != nil {
+		return err
 	}
-	log.Printf("Database cluster %q is running", cluster)
+	recordResource(ctx, "postgresql/"+cluster, resourceUpdated)
+	return nil
 }
 
-func (k *k8sSetUpImpl) DatabaseCreation(fileName string) error {
-	log.Printf("Creating database from file %q ...", fileName)
+// DatabaseCreation creates the database cluster or updates it when it exists, then runs its job unless a job already
+// applied its last migration
+func (k *k8sSetUpImpl) DatabaseCreation(ctx context.Context, fileName string) error {
+	logger.Infof(ctx, "Creating database from file %q ...", fileName)
+
+	job := k.spec.databaseJob(fileName)
+	migrations, err := loadMigrations(job.Migrations)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := k.renderManifest(fileName, nil)
+	if err != nil {
+		return err
+	}
+	defer removeFile(manifest)
 
 	var cluster string
-	var err error
-	if cluster, err = k.getClusterName(fileName); err != nil {
+	if cluster, err = k.getClusterName(manifest); err != nil {
 		return fmt.Errorf("error getting cluster name from yaml file: %v", err)
 	}
+	recordOutput(ctx, "cluster", cluster)
 
-	if created, err := k.isDatabaseCreated(cluster); err == nil && created {
-		return fmt.Errorf("database cluster %q already exists", cluster)
+	if created, err := k.isDatabaseCreated(ctx, cluster); err == nil && created {
+		logger.Infof(ctx, "Database cluster %q already exists ...", cluster)
+		if err = k.reconcileDatabase(ctx, manifest, cluster); err != nil {
+			return fmt.Errorf("error updating database cluster %q: %v", cluster, err)
+		}
+	} else if err = k.createDatabase(ctx, manifest); err == nil {
+		logger.Infof(ctx, "Database cluster %q created ...", cluster)
+		recordResource(ctx, "postgresql/"+cluster, resourceCreated)
+	} else {
+		return fmt.Errorf("error creating database cluster %q: %v", cluster, err)
 	}
 
-	if err = k.createDatabase(fileName); err == nil {
-		log.Printf("Database cluster %q created ...", cluster)
-	} else {
+	if err = k.waitDatabaseCreation(ctx, cluster); err != nil {
 		return fmt.Errorf("error creating database cluster %q: %v", cluster, err)
 	}
 
-	k.waitDatabaseCreation(cluster)
+	version := schemaVersion(migrations)
+	applied, completed, err := k.appliedSchemaVersion(ctx, cluster)
+	if err == nil && completed && applied >= version {
+		logger.Infof(ctx, "Database job for cluster %q already completed ...", cluster)
+		if len(migrations) != 0 {
+			recordOutput(ctx, schemaVersionOutput, strconv.Itoa(applied))
+		}
+		return nil
+	}
+	// the job plans again from the tracking table of the database, these are only pending for the set up
+	for _, v := range pendingMigrations(migrations, applied) {
+		logger.Infof(ctx, "Migration %s of cluster %q is pending ...", v.name, cluster)
+	}
 
-	if err = k.createDatabaseJob(cluster); err == nil {
-		log.Printf("Database job created for cluster %q...", cluster)
+	if err = k.createDatabaseJob(ctx, cluster, job, version); err == nil {
+		logger.Infof(ctx, "Database job created for cluster %q...", cluster)
 	} else {
 		return fmt.Errorf("error creating job for cluster %q: %v", cluster, err)
 	}
+	if len(migrations) != 0 {
+		recordOutput(ctx, schemaVersionOutput, strconv.Itoa(version))
+	}
 	return nil
 }
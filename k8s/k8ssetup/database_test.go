@@ -1,13 +1,15 @@
 package k8ssetup
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
 
-
 func Test_getClusterName(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
@@ -65,13 +67,13 @@ func Test_isDatabaseCreated(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
 	t.Run("must return true if database exists", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", nil
 		}
 
 		expect := true
 		var expectErr error = nil
-		got, gotErr := k8sImpl.isDatabaseCreated("cluster")
+		got, gotErr := k8sImpl.isDatabaseCreated(context.Background(), "cluster")
 
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -83,13 +85,13 @@ func Test_isDatabaseCreated(t *testing.T) {
 
 	t.Run("must return false if database does not exist", func(t *testing.T) {
 		var errInvalid = errors.New("invalid")
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", errInvalid
 		}
 
 		expect := false
 		expectErr := errInvalid
-		got, gotErr := k8sImpl.isDatabaseCreated("cluster")
+		got, gotErr := k8sImpl.isDatabaseCreated(context.Background(), "cluster")
 
 		if !errors.Is(gotErr, expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -104,12 +106,12 @@ func Test_createDatabase(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
 	t.Run("must return no error if database is created", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", nil
 		}
 
 		var expectErr error = nil
-		gotErr := k8sImpl.createDatabase("cluster")
+		gotErr := k8sImpl.createDatabase(context.Background(), "cluster")
 
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -118,12 +120,12 @@ func Test_createDatabase(t *testing.T) {
 
 	t.Run("must return error if database is not created", func(t *testing.T) {
 		var errInvalid = errors.New("invalid")
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", errInvalid
 		}
 
 		expectErr := errInvalid
-		gotErr := k8sImpl.createDatabase("cluster")
+		gotErr := k8sImpl.createDatabase(context.Background(), "cluster")
 
 		if !errors.Is(gotErr, expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -135,12 +137,12 @@ func Test_isDatabaseRunning(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
 	t.Run("must return true if database is running", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "map[PostgresClusterStatus:Running]", nil
 		}
 		expect := true
 		var expectErr error = nil
-		got, gotErr := k8sImpl.isDatabaseRunning("cluster")
+		got, gotErr := k8sImpl.isDatabaseRunning(context.Background(), "cluster")
 
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -151,13 +153,13 @@ func Test_isDatabaseRunning(t *testing.T) {
 	})
 
 	t.Run("must return false if database is not running", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "map[PostgresClusterStatus:Waiting]", nil
 		}
 
 		expect := false
 		var expectErr error = nil
-		got, gotErr := k8sImpl.isDatabaseRunning("cluster")
+		got, gotErr := k8sImpl.isDatabaseRunning(context.Background(), "cluster")
 
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -167,15 +169,31 @@ func Test_isDatabaseRunning(t *testing.T) {
 		}
 	})
 
+	t.Run("must return permanent error if database creation failed", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			return "map[PostgresClusterStatus:CreateFailed]", nil
+		}
+
+		expect := false
+		got, gotErr := k8sImpl.isDatabaseRunning(context.Background(), "cluster")
+
+		if !isPermanentError(gotErr) {
+			t.Fatalf("Got error %v, expect permanent error", gotErr)
+		}
+		if got != expect {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
 	t.Run("must return false if database check fails", func(t *testing.T) {
 		var errInvalid = errors.New("invalid")
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", errInvalid
 		}
 
 		expect := false
 		expectErr := errInvalid
-		got, gotErr := k8sImpl.isDatabaseRunning("cluster")
+		got, gotErr := k8sImpl.isDatabaseRunning(context.Background(), "cluster")
 
 		if !errors.Is(gotErr, expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -191,7 +209,7 @@ func Test_waitDatabaseCreation(t *testing.T) {
 
 	t.Run("must run until database is running", func(t *testing.T) {
 		count := 0
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			count++
 			if count == 3 {
 				return "map[PostgresClusterStatus:Running]", nil
@@ -199,7 +217,7 @@ func Test_waitDatabaseCreation(t *testing.T) {
 			return "map[PostgresClusterStatus:Waiting]", nil
 		}
 
-		k8sImpl.waitDatabaseCreation("cluster")
+		k8sImpl.waitDatabaseCreation(context.Background(), "cluster")
 		expect := 3
 		got := count
 		if got != expect {
@@ -210,7 +228,7 @@ func Test_waitDatabaseCreation(t *testing.T) {
 	t.Run("must run until there is no error and running", func(t *testing.T) {
 		var errInvalid = errors.New("invalid")
 		count := 0
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			count++
 			if count == 2 {
 				return "map[PostgresClusterStatus:Running]", nil
@@ -218,7 +236,7 @@ func Test_waitDatabaseCreation(t *testing.T) {
 			return "", errInvalid
 		}
 
-		k8sImpl.waitDatabaseCreation("cluster")
+		k8sImpl.waitDatabaseCreation(context.Background(), "cluster")
 		expect := 2
 		got := count
 		if got != expect {
@@ -227,87 +245,251 @@ func Test_waitDatabaseCreation(t *testing.T) {
 	})
 }
 
+// liveDatabase is psql-cluster.yml as the API server returns it, with defaults and status
+const liveDatabase = `{
+	"apiVersion": "acid.zalan.do/v1",
+	"kind": "postgresql",
+	"metadata": {"name": "cluster", "namespace": "default", "uid": "1234"},
+	"spec": {
+		"teamId": "petstore",
+		"volume": {"size": "1Gi"},
+		"numberOfInstances": 2,
+		"users": {"petdba": ["superuser", "createdb"], "petuser": []},
+		"databases": {"pets": "petdba"},
+		"postgresql": {"version": "11", "parameters": {}}
+	},
+	"status": {"PostgresClusterStatus": "Running"}
+}`
+
+func Test_containsFields(t *testing.T) {
+	type TestCase struct {
+		name    string
+		live    string
+		desired string
+		expect  bool
+	}
+
+	cases := []TestCase{
+		{name: "equal", live: `{"a": 1, "b": ["x"]}`, desired: `{"a": 1, "b": ["x"]}`, expect: true},
+		{name: "live has defaults", live: `{"a": 1, "c": {"d": true}}`, desired: `{"a": 1}`, expect: true},
+		{name: "different value", live: `{"a": 1}`, desired: `{"a": 2}`, expect: false},
+		{name: "missing field", live: `{"a": 1}`, desired: `{"b": 1}`, expect: false},
+		{name: "different list", live: `{"b": ["x", "y"]}`, desired: `{"b": ["x"]}`, expect: false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var live, desired interface{}
+			_ = json.Unmarshal([]byte(tt.live), &live)
+			_ = json.Unmarshal([]byte(tt.desired), &desired)
+			if got := containsFields(live, desired); got != tt.expect {
+				t.Fatalf("Got %v, expect %v", got, tt.expect)
+			}
+		})
+	}
+}
+
 func Test_DatabaseCreation(t *testing.T) {
 	// setup
 	wd, _ := os.Getwd()
 	os.Chdir("_test")
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	k8sImpl.registry = newDigestRegistry(t)
 
 	t.Run("we should create the database", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "describe" && params[1] == "postgresql/cluster" {
 				return "error", errors.New("error kubectl describe")
 			}
+			if output, ok := succeededJob(params); ok {
+				return output, nil
+			}
 			return "map[PostgresClusterStatus:Running]", nil
 		}
 
 		var expect error = nil
-		got := k8sImpl.DatabaseCreation("psql-cluster.yml")
+		got := k8sImpl.DatabaseCreation(context.Background(), "psql-cluster.yml")
 		if got != expect {
 			t.Fatalf("Got error %v, expect  error %v", got, expect)
 		}
 	})
 
 	t.Run("we should return an error when getting cluster name fails", func(t *testing.T) {
-		expect := "error getting cluster name"
-		got := k8sImpl.DatabaseCreation("cluster1.yml")
+		expect := "error reading file \"cluster1.yml\""
+		got := k8sImpl.DatabaseCreation(context.Background(), "cluster1.yml")
 
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect  error %v", got, expect)
 		}
 	})
 
-	t.Run("we should return an error when database already exists", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
-			if params[0] == "describe" && params[1] == "postgresql/cluster" {
+	t.Run("we should do nothing when database exists and its job completed", func(t *testing.T) {
+		var mutating []string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if isMutatingCommand(params) {
+				mutating = append(mutating, strings.Join(params, " "))
+			}
+			switch {
+			case params[0] == "describe" && params[1] == "postgresql/cluster":
 				return "", nil
+			case params[1] == "postgresql/cluster" && params[3] == "json":
+				return liveDatabase, nil
+			case params[1] == "job":
+				return "job.batch/cluster-run-x1", nil
+			case params[1] == "job.batch/cluster-run-x1":
+				return "'1'", nil
 			}
 			return "map[PostgresClusterStatus:Running]", nil
 		}
 
-		expect := "already exists"
-		got := k8sImpl.DatabaseCreation("psql-cluster.yml")
-		if !strings.Contains(got.Error(), expect) {
+		got := k8sImpl.DatabaseCreation(context.Background(), "psql-cluster.yml")
+		if got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		if mutating != nil {
+			t.Fatalf("Got commands %v, expect none", mutating)
+		}
+	})
+
+	t.Run("we should update the database when it differs and run its job", func(t *testing.T) {
+		var mutating []string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if isMutatingCommand(params) {
+				mutating = append(mutating, params[0])
+			}
+			if output, ok := succeededJob(params); ok {
+				return output, nil
+			}
+			switch {
+			case params[0] == "describe" && params[1] == "postgresql/cluster":
+				return "", nil
+			case params[1] == "postgresql/cluster" && params[3] == "json":
+				return strings.Replace(liveDatabase, `"numberOfInstances": 2`, `"numberOfInstances": 1`, 1), nil
+			case params[1] == "job":
+				return "", nil
+			}
+			return "map[PostgresClusterStatus:Running]", nil
+		}
+
+		got := k8sImpl.DatabaseCreation(context.Background(), "psql-cluster.yml")
+		if got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		if expect := []string{"apply", "build", "push", "create"}; !reflect.DeepEqual(mutating, expect) {
+			t.Fatalf("Got commands %v, expect %v", mutating, expect)
+		}
+	})
+
+	t.Run("we should return an error when database update fails", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			switch {
+			case params[0] == "describe" && params[1] == "postgresql/cluster":
+				return "", nil
+			case params[1] == "postgresql/cluster" && params[3] == "json":
+				return "{}", nil
+			case params[0] == "apply":
+				return "error", errors.New("error kubectl apply")
+			}
+			return "map[PostgresClusterStatus:Running]", nil
+		}
+
+		expect := "error updating database cluster \"cluster\""
+		got := k8sImpl.DatabaseCreation(context.Background(), "psql-cluster.yml")
+		if got == nil || !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect  error %v", got, expect)
 		}
 	})
 
 	t.Run("we should return an error when database creation fails", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "describe" && params[1] == "postgresql/cluster" {
 				return "error", errors.New("error kubectl describe")
 			}
-			if params[0] == "create" && params[1] == "-f" && params[2] == "psql-cluster.yml" {
+			if params[0] == "create" && params[1] == "-f" && strings.HasSuffix(params[2], "-psql-cluster.yml") {
 				return "error", errors.New("error kubectl create")
 			}
 			return "map[PostgresClusterStatus:Running]", nil
 		}
 
 		expect := "error creating database cluster"
-		got := k8sImpl.DatabaseCreation("psql-cluster.yml")
+		got := k8sImpl.DatabaseCreation(context.Background(), "psql-cluster.yml")
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect  error %v", got, expect)
 		}
 	})
 
 	t.Run("we should return an error when job creation fails", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "describe" && params[1] == "postgresql/cluster" {
 				return "error", errors.New("error kubectl describe")
 			}
 			if params[0] == "build" && params[3] == "Dockerfile-cluster-job" {
 				return "error", errors.New("error docker build")
 			}
+			if params[0] == "get" && params[1] == "job" {
+				return "", nil
+			}
 			return "map[PostgresClusterStatus:Running]", nil
 		}
 
 		expect := "error creating job for cluster"
-		got := k8sImpl.DatabaseCreation("psql-cluster.yml")
+		got := k8sImpl.DatabaseCreation(context.Background(), "psql-cluster.yml")
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect  error %v", got, expect)
 		}
 	})
 
+	t.Run("we should run the job again only when a migration is pending", func(t *testing.T) {
+		k8sImpl.spec.Databases = []DatabaseSpec{{Manifest: "psql-cluster.yml", Job: JobSpec{
+			Migrations: writeMigrations(t, "0001_create_pets_schema.sql", "0002_add_pets_weight.sql"),
+		}}}
+		defer func() { k8sImpl.spec.Databases = DefaultSpec().Databases }()
+
+		for applied, expect := range map[string][]string{"1": {"build", "push", "create"}, "2": nil} {
+			var mutating []string
+			k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+				if isMutatingCommand(params) {
+					mutating = append(mutating, params[0])
+				}
+				if output, ok := succeededJob(params); ok {
+					return output, nil
+				}
+				switch {
+				case params[0] == "describe" && params[1] == "postgresql/cluster":
+					return "", nil
+				case params[1] == "postgresql/cluster" && params[3] == "json":
+					return liveDatabase, nil
+				case params[1] == "job":
+					return "job.batch/cluster-run-x0", nil
+				case params[1] == "job.batch/cluster-run-x0" && strings.Contains(params[3], schemaVersionLabel):
+					return "'" + applied + "'", nil
+				case params[1] == "job.batch/cluster-run-x0":
+					return "'1'", nil
+				}
+				return "map[PostgresClusterStatus:Running]", nil
+			}
+
+			if got := k8sImpl.DatabaseCreation(context.Background(), "psql-cluster.yml"); got != nil {
+				t.Fatalf("Got error %v, expect nil", got)
+			}
+			if !reflect.DeepEqual(mutating, expect) {
+				t.Fatalf("Got commands %v at schema version %s, expect %v", mutating, applied, expect)
+			}
+		}
+	})
+
+	t.Run("we should return an error when the migrations are invalid", func(t *testing.T) {
+		k8sImpl.spec.Databases = []DatabaseSpec{{Manifest: "psql-cluster.yml", Job: JobSpec{
+			Migrations: writeMigrations(t, "0001_create_pets_schema.sql", "create_pets.sql"),
+		}}}
+		defer func() { k8sImpl.spec.Databases = DefaultSpec().Databases }()
+
+		expect := `migration "create_pets.sql" is not named <version>_<description>.sql`
+		if got := k8sImpl.DatabaseCreation(context.Background(), "psql-cluster.yml"); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect error %v", got, expect)
+		}
+	})
+
 	//tear down
 	os.Chdir(wd)
 }
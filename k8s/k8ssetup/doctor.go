@@ -0,0 +1,332 @@
+package k8ssetup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	kudoNamespace  = "kudo-system"
+	kudoController = "statefulset/kudo-controller-manager"
+)
+
+var defaultStorageClassAnnotations = []string{
+	"storageclass.kubernetes.io/is-default-class",
+	"storageclass.beta.kubernetes.io/is-default-class",
+}
+
+// permission is what the set up does with a resource, cluster ones are not in a namespace
+type permission struct {
+	resource string
+	verbs    []string
+	cluster  bool
+	kafka    bool
+}
+
+// requiredPermissions are the verbs of every resource that the set up and the operator manifests use
+var requiredPermissions = []permission{
+	{resource: "namespaces", verbs: []string{"get", "create"}, cluster: true},
+	{resource: "clusterroles.rbac.authorization.k8s.io", verbs: []string{"get", "create", "delete"}, cluster: true},
+	{resource: "clusterrolebindings.rbac.authorization.k8s.io", verbs: []string{"get", "create", "patch", "delete"}, cluster: true},
+	{resource: "configmaps", verbs: []string{"create", "delete"}},
+	{resource: "serviceaccounts", verbs: []string{"create", "delete"}},
+	{resource: "services", verbs: []string{"get", "create", "delete"}},
+	{resource: "deployments.apps", verbs: []string{"create", "delete"}},
+	{resource: "pods", verbs: []string{"get", "list"}},
+	{resource: "pods/log", verbs: []string{"get"}},
+	{resource: "jobs.batch", verbs: []string{"create", "list", "delete"}},
+	{resource: "persistentvolumeclaims", verbs: []string{"list", "delete"}},
+	{resource: "secrets", verbs: []string{"list"}},
+	{resource: "postgresqls.acid.zalan.do", verbs: []string{"get", "create", "patch", "delete"}},
+	{resource: "pods/exec", verbs: []string{"create"}, kafka: true},
+	{resource: "instances.kudo.dev", verbs: []string{"get", "list", "create", "delete"}, kafka: true},
+}
+
+// Check is a readiness check of the doctor, the hint tells how to fix it when it fails
+type Check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// Checklist are the checks of the doctor in order
+type Checklist struct {
+	Checks []Check `json:"checks"`
+}
+
+func (c *Checklist) pass(name, format string, args ...interface{}) {
+	c.Checks = append(c.Checks, Check{Name: name, Passed: true, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (c *Checklist) fail(name, hint, format string, args ...interface{}) {
+	c.Checks = append(c.Checks, Check{Name: name, Detail: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+// Failed returns the number of failed checks
+func (c Checklist) Failed() (failed int) {
+	for _, v := range c.Checks {
+		if !v.Passed {
+			failed++
+		}
+	}
+	return
+}
+
+// Print writes a line per check and the hint of the failed ones
+func (c Checklist) Print(w io.Writer) (err error) {
+	if _, err = fmt.Fprintf(w, "Doctor: %d check(s), %d failed\n", len(c.Checks), c.Failed()); err != nil {
+		return
+	}
+	for _, v := range c.Checks {
+		status := "PASS"
+		if !v.Passed {
+			status = "FAIL"
+		}
+		if _, err = fmt.Fprintf(w, "[%s] %s: %s\n", status, v.Name, v.Detail); err != nil {
+			return
+		}
+		if !v.Passed && v.Hint != "" {
+			if _, err = fmt.Fprintf(w, "       hint: %s\n", v.Hint); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Doctor checks that the cluster and the tools are ready for the set up, it changes nothing and runs every check
+// even when some fail
+func (k *k8sSetUpImpl) Doctor(ctx context.Context) Checklist {
+	var list Checklist
+	if k.checkClusterAccess(ctx, &list) {
+		k.checkContext(ctx, &list)
+		k.checkPermissions(ctx, &list)
+		k.checkStorageClass(ctx, &list)
+		k.checkNodes(ctx, &list)
+		if len(k.spec.Kafka) != 0 {
+			k.checkKudo(ctx, &list)
+		}
+	}
+	k.checkImageBuilder(ctx, &list)
+	k.checkRegistries(ctx, &list)
+	return list
+}
+
+// checkClusterAccess finds kubectl or the kubeconfig of the rest backend, the cluster checks need one of them
+func (k *k8sSetUpImpl) checkClusterAccess(ctx context.Context, list *Checklist) bool {
+	if k.spec.Cluster.Backend == restBackend {
+		k.checkRestBackend(list)
+		if err := k.initializeRestClient(ctx); err != nil {
+			list.fail("kubeconfig", "set cluster.kubeconfig in the spec or KUBECONFIG to a kubeconfig with a current context", "%v", err)
+			return false
+		}
+		list.pass("kubeconfig", "loaded for the %s backend", restBackend)
+		return true
+	}
+	kubectlPath, err := k.findKubectlPath()
+	if err != nil {
+		list.fail("kubectl", "install kubectl in the PATH or set cluster.backend to rest in the spec", "%v", err)
+		return false
+	}
+	k.kubectlPath = kubectlPath
+	list.pass("kubectl", "found in %s", kubectlPath)
+	return true
+}
+
+func (k k8sSetUpImpl) checkContext(ctx context.Context, list *Checklist) {
+	if name, server, err := k.cluster.currentContext(ctx); err != nil {
+		list.fail("kube context", "select a context with cluster.context in the spec or kubectl config use-context", "%v", err)
+	} else if k.spec.isProtectedContext(name) {
+		list.pass("kube context", "%q at %s, protected so the set up needs -confirm-context %s", name, server, name)
+	} else {
+		list.pass("kube context", "%q at %s", name, server)
+	}
+	if version, err := k.cluster.serverVersion(ctx); err != nil {
+		list.fail("server version", "check that the API server is reachable with the credentials of the context", "%v", err)
+	} else {
+		list.pass("server version", "%s", version)
+	}
+}
+
+// checkPermissions asks the API server for every verb of every resource the set up uses, a check per resource
+func (k k8sSetUpImpl) checkPermissions(ctx context.Context, list *Checklist) {
+	for _, v := range requiredPermissions {
+		if v.kafka && len(k.spec.Kafka) == 0 {
+			continue
+		}
+		namespace := k.namespace()
+		if v.cluster {
+			namespace = ""
+		}
+		var denied, failed []string
+		for _, verb := range v.verbs {
+			allowed, err := k.cluster.canI(ctx, verb, v.resource, namespace)
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s (%v)", verb, err))
+			} else if !allowed {
+				denied = append(denied, verb)
+			}
+		}
+
+		name := "RBAC " + v.resource
+		where := fmt.Sprintf("in namespace %q", namespace)
+		if v.cluster {
+			where = "in the cluster"
+		}
+		switch {
+		case len(failed) != 0:
+			list.fail(name, "check that the API server is reachable and answers access reviews", "could not check %s", strings.Join(failed, ", "))
+		case len(denied) != 0:
+			list.fail(name, fmt.Sprintf("ask a cluster admin for a role that allows %s on %s %s", strings.Join(denied, ", "), v.resource, where),
+				"denied %s", strings.Join(denied, ", "))
+		default:
+			list.pass(name, "%s allowed %s", strings.Join(v.verbs, ", "), where)
+		}
+	}
+}
+
+func objectName(object map[string]interface{}) string {
+	name, _ := fieldValue(object, ".metadata.name")
+	return name
+}
+
+func (k k8sSetUpImpl) checkStorageClass(ctx context.Context, list *Checklist) {
+	const name = "default storage class"
+	classes, err := k.cluster.listObjects(ctx, "storageclass", "")
+	if err != nil {
+		list.fail(name, "check that the user could list storage classes", "%v", err)
+		return
+	}
+	for _, class := range classes {
+		metadata, _ := class["metadata"].(map[string]interface{})
+		annotations, _ := metadata["annotations"].(map[string]interface{})
+		for _, v := range defaultStorageClassAnnotations {
+			if annotations[v] == "true" {
+				list.pass(name, "%s", objectName(class))
+				return
+			}
+		}
+	}
+	list.fail(name, fmt.Sprintf("the volumes of the databases and kafka need one, mark a storage class with the annotation %s=true",
+		defaultStorageClassAnnotations[0]), "none of %d storage class(es) is the default", len(classes))
+}
+
+// isSchedulable returns true when a node is ready and accepts new pods
+func isSchedulable(node map[string]interface{}) bool {
+	if unschedulable, _ := fieldValue(node, ".spec.unschedulable"); unschedulable == "true" {
+		return false
+	}
+	if ready, _ := fieldValue(node, ".status.conditions[?(@.type==\"Ready\")].status"); ready != "True" {
+		return false
+	}
+	spec, _ := node["spec"].(map[string]interface{})
+	taints, _ := spec["taints"].([]interface{})
+	for _, v := range taints {
+		if taint, ok := v.(map[string]interface{}); ok && (taint["effect"] == "NoSchedule" || taint["effect"] == "NoExecute") {
+			return false
+		}
+	}
+	return true
+}
+
+// requiredNodes returns the nodes the replicas of the biggest kafka or zookeeper cluster need to run apart
+func (k k8sSetUpImpl) requiredNodes() (nodes int, reason string) {
+	nodes, reason = 1, "the set up"
+	for _, v := range k.spec.Kafka {
+		if v.Brokers > nodes {
+			nodes, reason = v.Brokers, fmt.Sprintf("the %d brokers of kafka cluster %q", v.Brokers, v.Name)
+		}
+		if v.Zookeeper.Nodes > nodes {
+			nodes, reason = v.Zookeeper.Nodes, fmt.Sprintf("the %d zookeeper nodes of kafka cluster %q", v.Zookeeper.Nodes, v.Name)
+		}
+	}
+	return
+}
+
+func (k k8sSetUpImpl) checkNodes(ctx context.Context, list *Checklist) {
+	const name = "schedulable nodes"
+	nodes, err := k.cluster.listObjects(ctx, "node", "")
+	if err != nil {
+		list.fail(name, "check that the user could list nodes", "%v", err)
+		return
+	}
+	schedulable := 0
+	for _, v := range nodes {
+		if isSchedulable(v) {
+			schedulable++
+		}
+	}
+	required, reason := k.requiredNodes()
+	if schedulable < required {
+		list.fail(name, "add nodes or lower the brokers and the zookeeper nodes in the spec",
+			"%d of %d node(s) schedulable, %s need %d", schedulable, len(nodes), reason, required)
+		return
+	}
+	list.pass(name, "%d of %d node(s) schedulable, %s need %d", schedulable, len(nodes), reason, required)
+}
+
+// checkRestBackend tells that the rest backend only supports specs without kafka topics or kudo, kudo runs the
+// kubectl-kudo plugin and the topics are created with exec in a broker
+func (k k8sSetUpImpl) checkRestBackend(list *Checklist) {
+	const hint = "set cluster.backend to kubectl in the spec"
+	switch {
+	case k.spec.hasTopics():
+		list.fail("rest backend", hint, "only supports specs without kafka topics or kudo, the topics are created with exec")
+	case len(k.spec.Kafka) != 0:
+		list.fail("rest backend", hint, "only supports specs without kafka topics or kudo, kafka needs the kubectl-kudo plugin")
+	default:
+		list.pass("rest backend", "the spec has no kafka topics or kudo")
+	}
+}
+
+func (k k8sSetUpImpl) checkKudo(ctx context.Context, list *Checklist) {
+	if output, err := k.cluster.kudo(ctx, "version"); err != nil {
+		list.fail("kudo plugin", "install the kubectl-kudo plugin in the PATH", "%v", err)
+	} else {
+		list.pass("kudo plugin", "%s", strings.TrimSpace(output))
+	}
+
+	image, err := k.cluster.getField(ctx, kudoController, kudoNamespace, ".spec.template.spec.containers[0].image")
+	if err == nil && image == "" {
+		err = fmt.Errorf("no image in %s", kudoController)
+	}
+	if err != nil {
+		list.fail("kudo controller", "install the kudo controller with kubectl kudo init", "%v", err)
+		return
+	}
+	list.pass("kudo controller", "version %s in namespace %q", imageVersion(image), kudoNamespace)
+}
+
+func (k *k8sSetUpImpl) checkImageBuilder(ctx context.Context, list *Checklist) {
+	const name = "image builder"
+	builder, err := k.findImageBuilder()
+	if err != nil {
+		list.fail(name, "install docker, podman, nerdctl or buildah in the PATH, or set builder.tool in the spec", "%v", err)
+		return
+	}
+	k.builder = builder
+	version, err := builder.version(ctx)
+	if err != nil {
+		list.fail(name, fmt.Sprintf("start the %s daemon and check that the user could reach it", builder.name()), "%v", err)
+		return
+	}
+	list.pass(name, "%s %s", builder.name(), version)
+}
+
+func (k *k8sSetUpImpl) checkRegistries(ctx context.Context, list *Checklist) {
+	if registry, err := k.findDockerRegistry(ctx); err != nil {
+		list.fail("docker registry", fmt.Sprintf("set registry.url in the spec or %s, its credentials and check it answers %s", dockerRegistryVar, dockerRegistryPath), "%v", err)
+	} else {
+		k.registry = registry
+		k.dockerRegistry = registry.url
+		list.pass("docker registry", "%s", registry.url)
+	}
+	if registry, err := k.findDockerRegistryK8s(); err != nil {
+		list.fail("K8s docker registry", fmt.Sprintf("set registry.k8sUrl in the spec or %s", dockerRegistryK8sVar), "%v", err)
+	} else {
+		list.pass("K8s docker registry", "%s", registry)
+	}
+}
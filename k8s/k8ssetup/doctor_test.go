@@ -0,0 +1,210 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testNodes = `{"items": [
+		{"metadata": {"name": "a"}, "status": {"conditions": [{"type": "Ready", "status": "True"}]}},
+		{"metadata": {"name": "b"}, "status": {"conditions": [{"type": "Ready", "status": "True"}]}},
+		{"metadata": {"name": "c"}, "spec": {"unschedulable": true}, "status": {"conditions": [{"type": "Ready", "status": "True"}]}},
+		{"metadata": {"name": "d"}, "spec": {"taints": [{"key": "node-role.kubernetes.io/master", "effect": "NoSchedule"}]},
+			"status": {"conditions": [{"type": "Ready", "status": "True"}]}},
+		{"metadata": {"name": "e"}, "status": {"conditions": [{"type": "Ready", "status": "False"}]}}
+	]}`
+	testStorageClasses = `{"items": [
+		{"metadata": {"name": "slow"}},
+		{"metadata": {"name": "standard", "annotations": {"storageclass.kubernetes.io/is-default-class": "true"}}}
+	]}`
+)
+
+// doctorCommands answers the commands of the doctor like a cluster where pvc could not be deleted
+func doctorCommands(ctx context.Context, cmdName string, params ...string) (string, error) {
+	command := strings.Join(params, " ")
+	switch {
+	case command == "config current-context":
+		return "test\n", nil
+	case strings.HasPrefix(command, "config view --minify"):
+		return "https://cluster.test:6443", nil
+	case command == "version -o json":
+		return `{"clientVersion": {"gitVersion": "v1.21.2"}, "serverVersion": {"gitVersion": "v1.21.1"}}`, nil
+	case command == "auth can-i delete persistentvolumeclaims -n default":
+		return "no\n", errors.New("exit status 1")
+	case strings.HasPrefix(command, "auth can-i"):
+		return "yes\n", nil
+	case command == "get storageclass -o json":
+		return testStorageClasses, nil
+	case command == "get node -o json":
+		return testNodes, nil
+	case command == "kudo version":
+		return "KUDO Version: 0.15.0\n", nil
+	case strings.HasPrefix(command, "get "+kudoController):
+		return "'kudobuilder/controller:v0.15.0'", nil
+	case command == "version --format {{.Server.Version}}":
+		return "20.10.7\n", nil
+	}
+	return "", errors.New("unexpected command " + command)
+}
+
+func Test_Doctor(t *testing.T) {
+	registry := newBasicRegistry()
+	defer registry.Close()
+	setRegistryPassword(t, testRegistryPassword)
+
+	newDoctor := func(spec *Spec) *k8sSetUpImpl {
+		spec.Registry.URL = registry.URL
+		spec.Registry.K8sURL = "registry.cluster:5000"
+		spec.Registry.Username = testRegistryUser
+		k8sImpl := NewK8sSetUpWithSpec(spec, Options{}).(*k8sSetUpImpl)
+		k8sImpl.executeCommand = doctorCommands
+		return k8sImpl
+	}
+	checks := func(list Checklist) map[string]Check {
+		checks := map[string]Check{}
+		for _, v := range list.Checks {
+			checks[v.Name] = v
+		}
+		return checks
+	}
+
+	t.Run("must check the cluster and the tools", func(t *testing.T) {
+		_ = setUpTestFindKubectlPath(true)
+		_ = setUpTestFindDockerPath(true)
+		defer tearDown()
+		list := newDoctor(DefaultSpec()).Doctor(context.Background())
+
+		got := checks(list)
+		expect := map[string]string{
+			"kube context":                                `"test" at https://cluster.test:6443`,
+			"server version":                              "v1.21.1",
+			"RBAC persistentvolumeclaims":                 "denied delete",
+			"RBAC jobs.batch":                             `create, list, delete allowed in namespace "default"`,
+			"RBAC namespaces":                             "get, create allowed in the cluster",
+			"default storage class":                       "standard",
+			"schedulable nodes":                           `2 of 5 node(s) schedulable, the 3 brokers of kafka cluster "pets" need 3`,
+			"kudo plugin":                                 "KUDO Version: 0.15.0",
+			"kudo controller":                             `version v0.15.0 in namespace "kudo-system"`,
+			"image builder":                               "docker 20.10.7",
+			"docker registry":                             registry.URL,
+			"K8s docker registry":                         "registry.cluster:5000",
+			"RBAC instances.kudo.dev":                     `get, list, create, delete allowed in namespace "default"`,
+			"RBAC postgresqls.acid.zalan.do":              `get, create, patch, delete allowed in namespace "default"`,
+			"RBAC clusterroles.rbac.authorization.k8s.io": "get, create, delete allowed in the cluster",
+		}
+		for name, detail := range expect {
+			if got[name].Detail != detail {
+				t.Errorf("Got %q for %s, expect %q", got[name].Detail, name, detail)
+			}
+		}
+		if failed := list.Failed(); failed != 2 || got["schedulable nodes"].Passed || got["RBAC persistentvolumeclaims"].Passed {
+			t.Fatalf("Got %d failed, expect the nodes and the pvc permissions failed", failed)
+		}
+		if hint := got["RBAC persistentvolumeclaims"].Hint; hint != `ask a cluster admin for a role that allows delete on persistentvolumeclaims in namespace "default"` {
+			t.Fatalf("Got hint %q, expect the denied verb", hint)
+		}
+	})
+
+	t.Run("must not check kudo nor its permissions without kafka clusters", func(t *testing.T) {
+		_ = setUpTestFindKubectlPath(true)
+		_ = setUpTestFindDockerPath(true)
+		defer tearDown()
+		spec := DefaultSpec()
+		spec.Kafka = nil
+		got := checks(newDoctor(spec).Doctor(context.Background()))
+
+		for _, name := range []string{"kudo plugin", "kudo controller", "RBAC pods/exec", "RBAC instances.kudo.dev"} {
+			if _, ok := got[name]; ok {
+				t.Errorf("Got check %s, expect no kudo check", name)
+			}
+		}
+		if !got["schedulable nodes"].Passed {
+			t.Fatalf("Got %+v, expect one schedulable node is enough", got["schedulable nodes"])
+		}
+	})
+
+	t.Run("must tell that the rest backend only supports specs without kafka topics or kudo", func(t *testing.T) {
+		_ = setUpTestFindDockerPath(true)
+		defer tearDown()
+		tests := []struct {
+			name   string
+			kafka  []KafkaSpec
+			passed bool
+			detail string
+		}{
+			{name: "topics", kafka: []KafkaSpec{{Name: "pets", Topics: []TopicSpec{{Name: "pet-commands"}}}},
+				detail: "only supports specs without kafka topics or kudo, the topics are created with exec"},
+			{name: "kudo", kafka: []KafkaSpec{{Name: "pets"}},
+				detail: "only supports specs without kafka topics or kudo, kafka needs the kubectl-kudo plugin"},
+			{name: "no kafka", passed: true, detail: "the spec has no kafka topics or kudo"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				spec := DefaultSpec()
+				spec.Cluster = ClusterSpec{Backend: restBackend, Kubeconfig: "_test/missing-kubeconfig"}
+				spec.Kafka = tt.kafka
+				got := checks(newDoctor(spec).Doctor(context.Background()))["rest backend"]
+
+				if got.Passed != tt.passed || got.Detail != tt.detail {
+					t.Fatalf("Got %+v, expect passed %v and %q", got, tt.passed, tt.detail)
+				}
+				if !tt.passed && got.Hint != "set cluster.backend to kubectl in the spec" {
+					t.Fatalf("Got hint %q, expect to set the kubectl backend", got.Hint)
+				}
+			})
+		}
+	})
+
+	t.Run("must tell that the context is protected", func(t *testing.T) {
+		_ = setUpTestFindKubectlPath(true)
+		_ = setUpTestFindDockerPath(true)
+		defer tearDown()
+		spec := DefaultSpec()
+		spec.Cluster.ProtectedContexts = []string{"test"}
+		got := checks(newDoctor(spec).Doctor(context.Background()))["kube context"]
+
+		if expect := `"test" at https://cluster.test:6443, protected so the set up needs -confirm-context test`; !got.Passed || got.Detail != expect {
+			t.Fatalf("Got %+v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must check the tools when kubectl is not found", func(t *testing.T) {
+		_ = setUpTestFindKubectlPath(false)
+		_ = setUpTestFindDockerPath(false)
+		defer tearDown()
+		list := newDoctor(DefaultSpec()).Doctor(context.Background())
+
+		var names []string
+		for _, v := range list.Checks {
+			names = append(names, v.Name)
+		}
+		if expect := "kubectl, image builder, docker registry, K8s docker registry"; strings.Join(names, ", ") != expect {
+			t.Fatalf("Got %v, expect %s", names, expect)
+		}
+		if list.Failed() != 2 {
+			t.Fatalf("Got %d failed, expect kubectl and the image builder failed", list.Failed())
+		}
+	})
+}
+
+func Test_ChecklistPrint(t *testing.T) {
+	var out bytes.Buffer
+	list := Checklist{Checks: []Check{
+		{Name: "kubectl", Passed: true, Detail: "found in /usr/bin/kubectl"},
+		{Name: "default storage class", Detail: "none of 1 storage class(es) is the default", Hint: "mark one"},
+	}}
+	if err := list.Print(&out); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	expect := "Doctor: 2 check(s), 1 failed\n" +
+		"[PASS] kubectl: found in /usr/bin/kubectl\n" +
+		"[FAIL] default storage class: none of 1 storage class(es) is the default\n" +
+		"       hint: mark one\n"
+	if got := out.String(); got != expect {
+		t.Fatalf("Got %q, expect %q", got, expect)
+	}
+}
@@ -1,9 +1,8 @@
 package k8ssetup
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -13,49 +12,52 @@ import (
 var (
 	pathVar              = "PATH"
 	kubectlCommand       = "kubectl"
+	kudoCommand          = "kubectl-kudo"
 	dockerCommand        = "docker"
+	podmanCommand        = "podman"
+	buildahCommand       = "buildah"
+	nerdctlCommand       = "nerdctl"
 	dockerRegistryVar    = "DOCKER_REGISTRY"
 	dockerRegistryPath   = "/v2/"
 	dockerRegistryK8sVar = "DOCKER_REGISTRY_K8S"
 )
 
 func (k k8sSetUpImpl) findDockerRegistryK8s() (string, error) {
-	log.Print("Checking K8s docker registry ...")
-	registry := os.Getenv(dockerRegistryK8sVar)
+	logger.Infof(context.Background(), "Checking K8s docker registry ...")
+	registry := k.spec.Registry.K8sURL
+	if registry == "" {
+		registry = os.Getenv(dockerRegistryK8sVar)
+	}
 	if registry == "" {
 		return "", fmt.Errorf("error checking K8s docker registry, variable %s does not exist", dockerRegistryK8sVar)
 	}
 	return registry, nil
 }
 
-func (k k8sSetUpImpl) findDockerRegistry() (string, error) {
-	log.Print("Checking docker registry ...")
-	registry := os.Getenv(dockerRegistryVar)
+// findDockerRegistry returns a client of the registry once it answers its API with our credentials
+func (k k8sSetUpImpl) findDockerRegistry(ctx context.Context) (*registryClient, error) {
+	logger.Infof(ctx, "Checking docker registry ...")
+	registry := k.spec.Registry.URL
 	if registry == "" {
-		return "", fmt.Errorf("error checking docker registry, variable %s does not exist", dockerRegistryVar)
+		registry = os.Getenv(dockerRegistryVar)
 	}
-	var resp *http.Response
-	var err error
-	if resp, err = http.Get(registry + dockerRegistryPath); err != nil {
-		return "", fmt.Errorf("error checking docker registry, %v", err)
+	if registry == "" {
+		return nil, fmt.Errorf("error checking docker registry, variable %s does not exist", dockerRegistryVar)
 	}
-	//noinspection GoUnhandledErrorResult
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("error checking docker registry, status is %d", resp.StatusCode)
+	client, err := newRegistryClient(ctx, registry, k.spec.Registry)
+	if err != nil {
+		return nil, fmt.Errorf("error checking docker registry, %v", err)
 	}
-
-	return registry, nil
+	if err = client.ping(ctx); err != nil {
+		return nil, fmt.Errorf("error checking docker registry, %v", err)
+	}
+	return client, nil
 }
 
 func (k *k8sSetUpImpl) findKubectlPath() (string, error) {
 	return k.findCommandPath(kubectlCommand)
 }
 
-func (k *k8sSetUpImpl) findDockerPath() (string, error) {
-	return k.findCommandPath(dockerCommand)
-}
-
 func (k *k8sSetUpImpl) findCommandPath(cmdName string) (string, error) {
 	path := os.Getenv(pathVar)
 	sep := ":"
@@ -72,31 +74,112 @@ func (k *k8sSetUpImpl) findCommandPath(cmdName string) (string, error) {
 	return "", fmt.Errorf("not %q path found", cmdName)
 }
 
-func (k *k8sSetUpImpl) Initialize() error {
-	if kubectlPath, err := k.findKubectlPath(); err == nil {
+// initializeRestClient connects to the API server, kubectl is not needed but kudo needs its kubectl plugin
+func (k *k8sSetUpImpl) initializeRestClient(ctx context.Context) error {
+	config, err := loadRestConfig(k.spec.Cluster.Kubeconfig, k.spec.Cluster.Context)
+	if err != nil {
+		return err
+	}
+	logger.Debugf(ctx, "API server found at %s with context %q", config.server, config.context)
+
+	if kudoPath, err := k.findCommandPath(kudoCommand); err == nil {
+		k.kudoPath = kudoPath
+		logger.Debugf(ctx, "Kudo found in %s", kudoPath)
+	} else {
+		logger.Warnf(ctx, "Kudo not found, kafka clusters could not be created: %v", err)
+	}
+	k.cluster = newRestClient(config, k.plan, func(ctx context.Context, params ...string) (string, error) {
+		if k.kudoPath == "" {
+			return "", fmt.Errorf("not %q path found", kudoCommand)
+		}
+		return k.runKudo(ctx, k.kudoPath, params...)
+	})
+	return nil
+}
+
+// runKudo runs a kudo command with the kubeconfig of the spec, kudo has no context flag so the context of the spec
+// is pinned in a copy of the kubeconfig that is removed once the command ends
+func (k k8sSetUpImpl) runKudo(ctx context.Context, cmdName string, params ...string) (string, error) {
+	kubeconfig := k.spec.Cluster.Kubeconfig
+	if k.spec.Cluster.Context != "" {
+		fileName, err := pinContext(kubeconfig, k.spec.Cluster.Context)
+		if err != nil {
+			return "", err
+		}
+		defer removeFile(fileName)
+		kubeconfig = fileName
+	}
+	if kubeconfig != "" {
+		params = append(params, "--kubeconfig", kubeconfig)
+	}
+	return k.executeCommand(ctx, cmdName, params...)
+}
+
+// useContext logs and records the cluster of the run, a protected context is only changed when it is confirmed
+func (k k8sSetUpImpl) useContext(ctx context.Context) error {
+	name, server, err := k.cluster.currentContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting kube context: %v", err)
+	}
+	logger.Infof(ctx, "Using context %q of the cluster at %s", name, server)
+	recordOutput(ctx, contextOutput, name)
+	recordOutput(ctx, serverOutput, server)
+
+	if !k.spec.isProtectedContext(name) {
+		return nil
+	}
+	if k.planMode() {
+		logger.Warnf(ctx, "Context %q is protected, the plan does not change it", name)
+		return nil
+	}
+	if k.confirmContext != name {
+		return fmt.Errorf("context %q is protected, confirm its changes with -confirm-context %s", name, name)
+	}
+	logger.Warnf(ctx, "Changing protected context %q as confirmed", name)
+	return nil
+}
+
+// initializeCluster connects to the API server or finds kubectl, depending on the backend of the spec
+func (k *k8sSetUpImpl) initializeCluster(ctx context.Context) error {
+	if k.spec.Cluster.Backend == restBackend {
+		if err := k.initializeRestClient(ctx); err != nil {
+			return fmt.Errorf("error connecting to the API server: %v", err)
+		}
+	} else if kubectlPath, err := k.findKubectlPath(); err == nil {
 		k.kubectlPath = kubectlPath
-		log.Printf("Kubectl found in %s", kubectlPath)
+		logger.Debugf(ctx, "Kubectl found in %s", kubectlPath)
 	} else {
 		return fmt.Errorf("error getting kubectl path: %v", err)
 	}
+	return nil
+}
+
+func (k *k8sSetUpImpl) Initialize(ctx context.Context) error {
+	if err := k.initializeCluster(ctx); err != nil {
+		return err
+	}
+	if err := k.useContext(ctx); err != nil {
+		return err
+	}
 
-	if dockerPath, err := k.findDockerPath(); err == nil {
-		k.dockerPath = dockerPath
-		log.Printf("docker found in %s", dockerPath)
+	if builder, err := k.findImageBuilder(); err == nil {
+		k.builder = builder
+		logger.Infof(ctx, "Image builder %s found", builder.name())
 	} else {
-		return fmt.Errorf("error getting docker path: %v", err)
+		return fmt.Errorf("error getting image builder: %v", err)
 	}
 
-	if dockerRegistry, err := k.findDockerRegistry(); err == nil {
-		k.dockerRegistry = dockerRegistry
-		log.Printf("Docker registry found at %s", dockerRegistry)
+	if registry, err := k.findDockerRegistry(ctx); err == nil {
+		k.registry = registry
+		k.dockerRegistry = registry.url
+		logger.Infof(ctx, "Docker registry found at %s", registry.url)
 	} else {
 		return fmt.Errorf("error checking docker registry: %v", err)
 	}
 
 	if dockerRegistryK8s, err := k.findDockerRegistryK8s(); err == nil {
 		k.dockerRegistryK8s = dockerRegistryK8s
-		log.Printf("K8s docker registry found at %s", dockerRegistryK8s)
+		logger.Infof(ctx, "K8s docker registry found at %s", dockerRegistryK8s)
 	} else {
 		return fmt.Errorf("error checking K8s docker registry: %v", err)
 	}
@@ -1,7 +1,10 @@
 package k8ssetup
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -115,33 +118,59 @@ func setUpTestFindDockerPath(existing bool) (path string) {
 	return
 }
 
-func Test_findDockerPath(t *testing.T) {
+func Test_findImageBuilder(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
-	t.Run("must find the kubectl command", func(t *testing.T) {
+	t.Run("must find the docker command", func(t *testing.T) {
 		path := setUpTestFindDockerPath(true)
-		expect := filepath.Join(path, existingCommand)
-		got, gotErr := k8sImpl.findDockerPath()
+		expect := newImageBuilder(k8sImpl, dockerBuilder, filepath.Join(path, existingCommand))
+		got, gotErr := k8sImpl.findImageBuilder()
 		tearDown()
 		if gotErr != nil {
 			t.Fatalf("Got error %v, expect nil error", gotErr)
 		}
 		if got != expect {
-			t.Fatalf("Got %q, expect %q", got, expect)
+			t.Fatalf("Got %v, expect %v", got, expect)
 		}
 	})
 
-	t.Run("must not find the kubectl command", func(t *testing.T) {
+	t.Run("must find the podman command when docker does not exist", func(t *testing.T) {
+		path := setUpTestFindDockerPath(false)
+		podmanCommand = existingCommand
+		defer func() { podmanCommand = "podman" }()
+		expect := newImageBuilder(k8sImpl, podmanBuilder, filepath.Join(path, existingCommand))
+		got, gotErr := k8sImpl.findImageBuilder()
+		tearDown()
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil error", gotErr)
+		}
+		if got != expect {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must not find any image builder", func(t *testing.T) {
 		_ = setUpTestFindDockerPath(false)
-		expect := ""
-		expectErr := fmt.Errorf("not %q path found", notExistingCommand)
-		got, gotErr := k8sImpl.findDockerPath()
+		expectErr := "no image builder found, tried docker, podman, nerdctl, buildah"
+		got, gotErr := k8sImpl.findImageBuilder()
 		tearDown()
-		if gotErr.Error() != expectErr.Error() {
+		if gotErr == nil || gotErr.Error() != expectErr {
 			t.Fatalf("Got error %v, expect %v error", gotErr, expectErr)
 		}
-		if got != expect {
-			t.Fatalf("Got %q, expect %q", got, expect)
+		if got != nil {
+			t.Fatalf("Got %v, expect nil", got)
+		}
+	})
+
+	t.Run("must not find the image builder of the spec", func(t *testing.T) {
+		_ = setUpTestFindDockerPath(true)
+		k8sImpl.spec.Builder.Tool = buildahBuilder
+		defer func() { k8sImpl.spec.Builder.Tool = autoBuilder }()
+		expectErr := fmt.Errorf("not %q path found", buildahCommand)
+		_, gotErr := k8sImpl.findImageBuilder()
+		tearDown()
+		if gotErr == nil || gotErr.Error() != expectErr.Error() {
+			t.Fatalf("Got error %v, expect %v error", gotErr, expectErr)
 		}
 	})
 }
@@ -182,55 +211,102 @@ func Test_findDockerRegistry(t *testing.T) {
 	t.Run("must find the docker registry", func(t *testing.T) {
 		setUpTestFindDockerRegistry(dockerRegistryFound)
 		expect := existingHost
-		got, gotErr := k8sImpl.findDockerRegistry()
+		got, gotErr := k8sImpl.findDockerRegistry(context.Background())
 		tearDown()
 		if gotErr != nil {
 			t.Fatalf("Got error %v, expect nil error", gotErr)
 		}
-		if got != expect {
-			t.Fatalf("Got %q, expect %q", got, expect) //Got "http://192.168.64.3:32000", expect "https://google.com"
+		if got.url != expect {
+			t.Fatalf("Got %q, expect %q", got.url, expect) //Got "http://192.168.64.3:32000", expect "https://google.com"
 		}
 	})
 
 	t.Run("must not find the docker registry when host does not exist", func(t *testing.T) {
 		setUpTestFindDockerRegistry(dockerRegistryHostNotExists)
-		expect := ""
 		expectErr := "error checking docker registry, "
-		got, gotErr := k8sImpl.findDockerRegistry()
+		got, gotErr := k8sImpl.findDockerRegistry(context.Background())
 		tearDown()
 		if !strings.Contains(gotErr.Error(), expectErr) {
 			t.Fatalf("Got error %q, expect %q error", gotErr, expectErr)
 		}
-		if got != expect {
-			t.Fatalf("Got %q, expect %q", got, expect)
+		if got != nil {
+			t.Fatalf("Got %v, expect nil", got)
 		}
 	})
 
 	t.Run("must not find the docker registry when host does not return ok", func(t *testing.T) {
 		setUpTestFindDockerRegistry(dockerRegistryPathNotFound)
-		expect := ""
 		expectErr := "error checking docker registry, status is 404"
-		got, gotErr := k8sImpl.findDockerRegistry()
+		got, gotErr := k8sImpl.findDockerRegistry(context.Background())
 		tearDown()
 		if gotErr.Error() != expectErr {
 			t.Fatalf("Got error %q, expect %q error", gotErr, expectErr)
 		}
-		if got != expect {
-			t.Fatalf("Got %q, expect %q", got, expect)
+		if got != nil {
+			t.Fatalf("Got %v, expect nil", got)
 		}
 	})
 
 	t.Run("must not find the docker registry when env var does not exist", func(t *testing.T) {
 		setUpTestFindDockerRegistry(dockerRegistryEnvVarNotExists)
-		expect := ""
 		expectErr := fmt.Sprintf("error checking docker registry, variable %s does not exist", testRegistryVar)
-		got, gotErr := k8sImpl.findDockerRegistry()
+		got, gotErr := k8sImpl.findDockerRegistry(context.Background())
 		if gotErr == nil {
 			t.Fatalf("Got error nil, expect %q error", expectErr)
 		}
 		if gotErr.Error() != expectErr {
 			t.Fatalf("Got error %q, expect %q error", gotErr, expectErr)
 		}
+		if got != nil {
+			t.Fatalf("Got %v, expect nil", got)
+		}
+	})
+}
+
+func Test_findDockerRegistryFromSpec(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	k8sImpl.spec.Registry = RegistrySpec{URL: server.URL, K8sURL: "localhost:32000"}
+	setUpTestFindDockerRegistry(dockerRegistryEnvVarNotExists)
+	setUpTestFindDockerRegistryK8s(false)
+
+	t.Run("must find the docker registry in the spec", func(t *testing.T) {
+		got, gotErr := k8sImpl.findDockerRegistry(context.Background())
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil error", gotErr)
+		}
+		if got.url != server.URL {
+			t.Fatalf("Got %q, expect %q", got.url, server.URL)
+		}
+	})
+
+	t.Run("must find an authenticated docker registry in the spec", func(t *testing.T) {
+		registry := newBasicRegistry()
+		defer registry.Close()
+		setRegistryPassword(t, testRegistryPassword)
+		k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+		k8sImpl.spec.Registry.URL = registry.URL
+		k8sImpl.spec.Registry.Username = testRegistryUser
+
+		got, gotErr := k8sImpl.findDockerRegistry(context.Background())
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil error", gotErr)
+		}
+		if got.url != registry.URL {
+			t.Fatalf("Got %q, expect %q", got.url, registry.URL)
+		}
+	})
+
+	t.Run("must find the k8s docker registry in the spec", func(t *testing.T) {
+		expect := "localhost:32000"
+		got, gotErr := k8sImpl.findDockerRegistryK8s()
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil error", gotErr)
+		}
 		if got != expect {
 			t.Fatalf("Got %q, expect %q", got, expect)
 		}
@@ -278,6 +354,67 @@ func Test_findDockerRegistryK8s(t *testing.T) {
 	})
 }
 
+func Test_useContext(t *testing.T) {
+	type TestCase struct {
+		name    string
+		plan    *Plan
+		confirm string
+		expect  string
+	}
+
+	cases := []TestCase{
+		{
+			name:   "must not change a protected context without confirmation",
+			expect: `context "prod" is protected, confirm its changes with -confirm-context prod`,
+		},
+		{
+			name:    "must not change a protected context confirmed with another name",
+			confirm: "dev",
+			expect:  `context "prod" is protected, confirm its changes with -confirm-context prod`,
+		},
+		{
+			name:    "must change a protected context once confirmed",
+			confirm: "prod",
+		},
+		{
+			name: "must plan the changes of a protected context",
+			plan: NewPlan(),
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := DefaultSpec()
+			spec.Cluster.Context = "prod"
+			spec.Cluster.ProtectedContexts = []string{"staging", "prod"}
+			k8sImpl := NewK8sSetUpWithSpec(spec, Options{Plan: tt.plan, ConfirmContext: tt.confirm}).(*k8sSetUpImpl)
+			k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+				return "https://prod.example.com", nil
+			}
+
+			report := NewReport()
+			result, got := report.Run(context.Background(), "initialize", "", k8sImpl.useContext)
+			if tt.expect == "" && got != nil || tt.expect != "" && (got == nil || got.Error() != tt.expect) {
+				t.Fatalf("Got error %v, expect %q", got, tt.expect)
+			}
+			if result.Outputs[contextOutput] != "prod" || result.Outputs[serverOutput] != "https://prod.example.com" {
+				t.Fatalf("Got outputs %v, expect the prod cluster", result.Outputs)
+			}
+		})
+	}
+
+	t.Run("must change a context that is not protected", func(t *testing.T) {
+		k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+		k8sImpl.spec.Cluster.ProtectedContexts = []string{"prod"}
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			return "dev", nil
+		}
+		if got := k8sImpl.useContext(context.Background()); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+	})
+}
+
 func Test_Initialize(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
@@ -286,7 +423,7 @@ func Test_Initialize(t *testing.T) {
 		_ = setUpTestFindDockerPath(true)
 		setUpTestFindDockerRegistry(dockerRegistryFound)
 		setUpTestFindDockerRegistryK8s(true)
-		got := k8sImpl.Initialize()
+		got := k8sImpl.Initialize(context.Background())
 		if got != nil {
 			t.Fatalf("Got error %q, expect nil", got)
 		}
@@ -298,19 +435,19 @@ func Test_Initialize(t *testing.T) {
 		setUpTestFindDockerRegistry(dockerRegistryFound)
 		setUpTestFindDockerRegistryK8s(true)
 		expect := "error getting kubectl path"
-		got := k8sImpl.Initialize()
+		got := k8sImpl.Initialize(context.Background())
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %q, expect %q", got, expect)
 		}
 	})
 
-	t.Run("must not initialize find docker command fails", func(t *testing.T) {
+	t.Run("must not initialize find image builder fails", func(t *testing.T) {
 		_ = setUpTestFindKubectlPath(true)
 		_ = setUpTestFindDockerPath(false)
 		setUpTestFindDockerRegistry(dockerRegistryFound)
 		setUpTestFindDockerRegistryK8s(true)
-		expect := "error getting docker path"
-		got := k8sImpl.Initialize()
+		expect := "error getting image builder"
+		got := k8sImpl.Initialize(context.Background())
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %q, expect %q", got, expect)
 		}
@@ -322,7 +459,7 @@ func Test_Initialize(t *testing.T) {
 		setUpTestFindDockerRegistry(dockerRegistryHostNotExists)
 		setUpTestFindDockerRegistryK8s(true)
 		expect := "error checking docker registry"
-		got := k8sImpl.Initialize()
+		got := k8sImpl.Initialize(context.Background())
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %q, expect %q", got, expect)
 		}
@@ -334,7 +471,7 @@ func Test_Initialize(t *testing.T) {
 		setUpTestFindDockerRegistry(dockerRegistryFound)
 		setUpTestFindDockerRegistryK8s(false)
 		expect := "error checking K8s docker registry"
-		got := k8sImpl.Initialize()
+		got := k8sImpl.Initialize(context.Background())
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %q, expect %q", got, expect)
 		}
@@ -1,84 +1,245 @@
 package k8ssetup
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"os"
-	"path"
+	"strconv"
 	"strings"
 )
 
-func (k k8sSetUpImpl) dockerBuild(dockerFile string, tag string) error {
-	if file, err := os.Open(dockerFile); err == nil {
+func (k k8sSetUpImpl) buildImage(ctx context.Context, image imageBuild) error {
+	if file, err := os.Open(image.dockerFile); err == nil {
 		file.Close()
-		if _, err := k.docker("build", "..", "-f", dockerFile, "-t", tag); err != nil {
-			return fmt.Errorf("error creating docker image with tag %q, %v", tag, err)
+		if err := k.builder.build(ctx, image); err != nil {
+			return fmt.Errorf("error creating %s image with tag %q, %v", k.builder.name(), image.tag, err)
 		}
 
 	} else {
-		return fmt.Errorf("the file %q does not exist", dockerFile)
+		return fmt.Errorf("the file %q does not exist", image.dockerFile)
 	}
 	return nil
 }
 
-func (k k8sSetUpImpl) dockerPush(tag string) error {
-	if _, err := k.docker("push", tag); err != nil {
-		return fmt.Errorf("error pushing docker image with tag %q, %v", tag, err)
+// pushImage pushes the image and returns its digest, empty when the builder does not tell it
+func (k k8sSetUpImpl) pushImage(ctx context.Context, tag string) (string, error) {
+	configDir, err := k.registry.pushConfigDir()
+	if err != nil {
+		return "", err
 	}
-	return nil
+	if configDir != "" {
+		defer removeDir(configDir)
+	}
+	digest, err := k.builder.push(ctx, tag, configDir)
+	if err != nil {
+		return "", fmt.Errorf("error pushing %s image with tag %q, %v", k.builder.name(), tag, err)
+	}
+	return digest, nil
 }
 
-func (k k8sSetUpImpl) createK8sJob(fileName string) error {
-	if content, err := ioutil.ReadFile(fileName); err == nil {
-		registryK8s := strings.Replace(k.dockerRegistryK8s, "https://", "", 1)
-		registryK8s = strings.Replace(registryK8s, "http://", "", 1)
-
-		newContent := strings.Replace(string(content), "$DOCKER_REGISTRY_K8S", registryK8s, 1)
-		_, onlyFileName := path.Split(fileName)
-		if newFile, err := ioutil.TempFile("", onlyFileName); err == nil {
-			defer newFile.Close()
-			if _, err := newFile.WriteString(newContent); err != nil {
-				return fmt.Errorf("error writting in temp file %q", newFile.Name())
-			}
-			if _, err := k.kubectl("create", "-f", newFile.Name()); err != nil {
-				return fmt.Errorf("error creating job in kubectl, %v", err)
+// createK8sJob creates the job of the manifest with its variables replaced and returns its generated name
+func (k k8sSetUpImpl) createK8sJob(ctx context.Context, fileName string, values map[string]string) (string, error) {
+	manifest, err := k.renderManifest(fileName, values)
+	if err != nil {
+		return "", err
+	}
+	defer removeFile(manifest)
+
+	refs, err := k.cluster.create(ctx, manifest, k.namespace())
+	if err != nil {
+		return "", fmt.Errorf("error creating job in kubectl, %v", err)
+	}
+	for _, v := range refs {
+		if strings.HasPrefix(v, "job/") || strings.HasPrefix(v, "job.") {
+			return v[strings.Index(v, "/")+1:], nil
+		}
+	}
+	if !k.planMode() {
+		return "", fmt.Errorf("no job created from file %q", fileName)
+	}
+	return "", nil
+}
+
+// printJobLogs writes the lines of the job pods that were not printed yet
+func (k k8sSetUpImpl) printJobLogs(ctx context.Context, pods []string, namespace string, printed map[string]int) {
+	for _, pod := range pods {
+		output, err := k.cluster.logs(ctx, pod, namespace)
+		if err != nil {
+			// the container could be still starting
+			continue
+		}
+		lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+		if output == "" {
+			lines = nil
+		}
+		for _, line := range lines[min(printed[pod], len(lines)):] {
+			logger.Infof(ctx, "[%s] %s", pod, line)
+		}
+		printed[pod] = len(lines)
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// podExitReason returns why the container of a finished pod ended
+func (k k8sSetUpImpl) podExitReason(ctx context.Context, pod, namespace string) string {
+	reason, _ := k.cluster.getField(ctx, pod, namespace, ".status.containerStatuses[0].state.terminated.reason")
+	exitCode, _ := k.cluster.getField(ctx, pod, namespace, ".status.containerStatuses[0].state.terminated.exitCode")
+	if reason == "" && exitCode == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s terminated with %s exit code %s", pod, reason, exitCode)
+}
+
+// lastPod returns the pod that started last, the names of the pods of a job are random so they do not tell which
+// one is its last try
+func (k k8sSetUpImpl) lastPod(ctx context.Context, pods []string, namespace string) (last string) {
+	var lastStart string
+	for _, pod := range pods {
+		start, _ := k.cluster.getField(ctx, pod, namespace, ".status.startTime")
+		if start == "" {
+			start, _ = k.cluster.getField(ctx, pod, namespace, ".metadata.creationTimestamp")
+		}
+		// RFC 3339 times in UTC sort as strings
+		if last == "" || start > lastStart {
+			last, lastStart = pod, start
+		}
+	}
+	return
+}
+
+// isJobCompleted returns true when the job succeeded and a permanent error when it failed
+func (k k8sSetUpImpl) isJobCompleted(ctx context.Context, name, namespace string, printed map[string]int) (bool, error) {
+	job := "job.batch/" + name
+	pods, err := k.cluster.listResources(ctx, "pod", "job-name="+name, namespace)
+	if err != nil {
+		return false, err
+	}
+	k.printJobLogs(ctx, pods, namespace, printed)
+
+	succeeded, err := k.cluster.getField(ctx, job, namespace, ".status.succeeded")
+	if err != nil {
+		return false, err
+	}
+	if succeeded != "" && succeeded != "0" {
+		return true, nil
+	}
+
+	failed, err := k.cluster.getField(ctx, job, namespace, `.status.conditions[?(@.type=="Failed")].status`)
+	if err != nil {
+		return false, err
+	}
+	if failed == "True" {
+		reason, _ := k.cluster.getField(ctx, job, namespace, `.status.conditions[?(@.type=="Failed")].reason`)
+		message, _ := k.cluster.getField(ctx, job, namespace, `.status.conditions[?(@.type=="Failed")].message`)
+		err = fmt.Errorf("job %q failed, %s: %s", name, reason, message)
+		if len(pods) != 0 {
+			if exit := k.podExitReason(ctx, k.lastPod(ctx, pods, namespace), namespace); exit != "" {
+				err = fmt.Errorf("%v, %s", err, exit)
 			}
+		}
+		return false, permanentError{err}
+	}
 
-		} else {
-			return fmt.Errorf("error creating temp file for %q", fileName)
+	for _, pod := range pods {
+		if err = k.checkPodFailure(ctx, pod, namespace); err != nil {
+			return false, err
 		}
+	}
+	return false, nil
+}
 
-	} else {
-		return fmt.Errorf("error reading file %q", fileName)
+// waitJobCompletion waits for the job to succeed while its logs are printed
+func (k k8sSetUpImpl) waitJobCompletion(ctx context.Context, name, namespace string) error {
+	printed := map[string]int{}
+	if err := k.waitFor(ctx, fmt.Sprintf("job %q completed", name), k.spec.Timeouts.Job, func(ctx context.Context) (bool, error) {
+		return k.isJobCompleted(ctx, name, namespace, printed)
+	}); err != nil {
+		return err
 	}
+	logger.Infof(ctx, "Job %q completed", name)
 	return nil
 }
 
-func (k k8sSetUpImpl) createDatabaseJob(cluster string) error {
+// pinnedImage returns the image of the k8s registry by the digest that our push returned, so the job runs the
+// image that we built even when the image is pushed again, when the builder does not tell the digest it is the one
+// of the manifest right after the push
+func (k k8sSetUpImpl) pinnedImage(ctx context.Context, tag, digest, label string) (string, error) {
+	image := trimScheme(k.dockerRegistryK8s) + "/" + label
+	if k.planMode() {
+		return image + "@sha256:<digest of " + tag + ">", nil
+	}
+	if digest == "" {
+		if k.registry == nil {
+			return "", fmt.Errorf("error getting digest of %q, no docker registry", tag)
+		}
+		var err error
+		if digest, err = k.registry.manifestDigest(ctx, k.registry.repository(tag), "latest"); err != nil {
+			return "", fmt.Errorf("error getting digest of %q: %v", tag, err)
+		}
+	}
+	logger.Infof(ctx, "Database job image %q pushed with digest %s ...", tag, digest)
+	return image + "@" + digest, nil
+}
+
+// createDatabaseJob builds and pushes the job image then runs the job, its manifest labels it with the schema version
+// of its migrations
+func (k k8sSetUpImpl) createDatabaseJob(ctx context.Context, cluster string, job JobSpec, version int) error {
 	label := cluster + "-job"
+	if job.Dockerfile == "" {
+		job.Dockerfile = "Dockerfile-" + label
+	}
+	if job.Manifest == "" {
+		job.Manifest = label + ".yml"
+	}
+	if job.Context == "" {
+		job.Context = ".."
+	}
 
-	registry := strings.Replace(k.dockerRegistry, "https://", "", 1)
-	registry = strings.Replace(registry, "http://", "", 1)
-	tag := registry + "/" + label
+	tag := trimScheme(k.dockerRegistry) + "/" + label
 
-	if err := k.dockerBuild("Dockerfile-"+label, tag); err == nil {
-		log.Printf("Database job image created with label %q ...", label)
+	if err := k.buildImage(ctx, imageBuild{
+		context:    job.Context,
+		dockerFile: job.Dockerfile,
+		tag:        tag,
+		args:       job.BuildArgs,
+		target:     job.Target,
+	}); err == nil {
+		logger.Infof(ctx, "Database job image created with label %q ...", label)
 	} else {
 		return err
 	}
 
-	if err := k.dockerPush(tag); err == nil {
-		log.Printf("Database job image pushed with label %q ...", label)
+	digest, err := k.pushImage(ctx, tag)
+	if err == nil {
+		logger.Infof(ctx, "Database job image pushed with label %q ...", label)
 	} else {
 		return err
 	}
 
-	fileName := label + ".yml"
-	if err := k.createK8sJob(fileName); err == nil {
-		log.Printf("K8s database job created from file %q ...", fileName)
+	image, err := k.pinnedImage(ctx, tag, digest, label)
+	if err != nil {
+		return err
+	}
+	recordOutput(ctx, "image", image)
+
+	name, err := k.createK8sJob(ctx, job.Manifest, map[string]string{
+		clusterNameTemplateVar:   cluster,
+		jobImageTemplateVar:      image,
+		schemaVersionTemplateVar: strconv.Itoa(version),
+	})
+	if err == nil {
+		logger.Infof(ctx, "K8s database job %q created from file %q ...", name, job.Manifest)
+		recordResource(ctx, "job/"+name, resourceCreated)
+		recordOutput(ctx, "job", name)
 	} else {
 		return err
 	}
-	return nil
+
+	return k.waitJobCompletion(ctx, name, k.namespace())
 }
@@ -1,23 +1,28 @@
 package k8ssetup
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
 
-func Test_dockerBuild(t *testing.T) {
+func Test_buildImage(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
 	t.Run("must return no error if docker build works", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", nil
 		}
 
 		var expect error = nil
-		got := k8sImpl.dockerBuild(getFilePath("Dockerfile-cluster-job"), "1")
+		got := k8sImpl.buildImage(context.Background(), imageBuild{context: "..", dockerFile: getFilePath("Dockerfile-cluster-job"), tag: "1"})
 
 		if got != expect {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
@@ -26,7 +31,7 @@ func Test_dockerBuild(t *testing.T) {
 
 	t.Run("must return error if dockerfile does not exist", func(t *testing.T) {
 		expect := "does not exist"
-		got := k8sImpl.dockerBuild(getFilePath("dockerfile-not-existing"), "1")
+		got := k8sImpl.buildImage(context.Background(), imageBuild{context: "..", dockerFile: getFilePath("dockerfile-not-existing"), tag: "1"})
 
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
@@ -35,12 +40,12 @@ func Test_dockerBuild(t *testing.T) {
 
 	t.Run("must return no error if docker build fails", func(t *testing.T) {
 		var invalidErr = errors.New("invalid")
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", invalidErr
 		}
 
 		expect := "error creating docker image"
-		got := k8sImpl.dockerBuild(getFilePath("Dockerfile-cluster-job"), "1")
+		got := k8sImpl.buildImage(context.Background(), imageBuild{context: "..", dockerFile: getFilePath("Dockerfile-cluster-job"), tag: "1"})
 
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
@@ -48,15 +53,15 @@ func Test_dockerBuild(t *testing.T) {
 	})
 }
 
-func Test_dockerPush(t *testing.T) {
+func Test_pushImage(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
 	t.Run("must return no error if docker push works", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", nil
 		}
 		var expect error = nil
-		got := k8sImpl.dockerPush("1")
+		_, got := k8sImpl.pushImage(context.Background(), "1")
 
 		if got != expect {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
@@ -65,11 +70,11 @@ func Test_dockerPush(t *testing.T) {
 
 	t.Run("must return no error if docker push fails", func(t *testing.T) {
 		var invalidErr = errors.New("invalid")
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", invalidErr
 		}
 		expect := "error pushing docker image"
-		got := k8sImpl.dockerPush("1")
+		_, got := k8sImpl.pushImage(context.Background(), "1")
 
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
@@ -82,7 +87,7 @@ func Test_createK8sJob(t *testing.T) {
 	k8sImpl.dockerRegistryK8s = "http://localhost:8081"
 
 	t.Run("must create job when no errors", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if content, err := ioutil.ReadFile(params[2]); err != nil {
 				t.Fatalf("Error reading file %q", params[2])
 			} else {
@@ -91,7 +96,7 @@ func Test_createK8sJob(t *testing.T) {
 				if got != expect {
 					t.Fatalf("Got %q, expect %q", got, expect)
 				} else {
-					return "", nil
+					return "job.batch/cluster-run-x1 created", nil
 				}
 			}
 
@@ -99,16 +104,32 @@ func Test_createK8sJob(t *testing.T) {
 		}
 
 		var expect error = nil
-		got := k8sImpl.createK8sJob(getFilePath("cluster-job.yml"))
+		got, gotErr := k8sImpl.createK8sJob(context.Background(), getFilePath("cluster-job.yml"), nil)
 
-		if got != expect {
-			t.Fatalf("Got %v, expect %v", got, expect)
+		if gotErr != expect {
+			t.Fatalf("Got error %v, expect %v", gotErr, expect)
+		}
+		if got != "cluster-run-x1" {
+			t.Fatalf("Got %q, expect %q", got, "cluster-run-x1")
+		}
+	})
+
+	t.Run("must return error when no job is created", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			return "configmap/cluster created", nil
+		}
+
+		expect := "no job created from file"
+		_, got := k8sImpl.createK8sJob(context.Background(), getFilePath("cluster-job.yml"), nil)
+
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect error %v", got, expect)
 		}
 	})
 
 	t.Run("must return error when file does not exist", func(t *testing.T) {
 		expect := "error reading file"
-		got := k8sImpl.createK8sJob("not-existing.yml")
+		_, got := k8sImpl.createK8sJob(context.Background(), "not-existing.yml", nil)
 
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
@@ -117,12 +138,12 @@ func Test_createK8sJob(t *testing.T) {
 
 	t.Run("must eturn error when job creation fails", func(t *testing.T) {
 		invalidErr := errors.New("invalid")
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", invalidErr
 		}
 
 		expect := "error creating job in kubectl"
-		got := k8sImpl.createK8sJob(getFilePath("cluster-job.yml"))
+		_, got := k8sImpl.createK8sJob(context.Background(), getFilePath("cluster-job.yml"), nil)
 
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got %v, expect %v", got, expect)
@@ -130,66 +151,319 @@ func Test_createK8sJob(t *testing.T) {
 	})
 }
 
+// succeededJob answers the kubectl commands about a job that completes without errors
+func succeededJob(params []string) (string, bool) {
+	command := strings.Join(params, " ")
+	switch {
+	case params[0] == "create" && strings.Contains(params[2], "job.yml"):
+		return "job.batch/cluster-run-x1 created", true
+	case strings.HasPrefix(command, "get pod -o name -n default -l job-name=cluster-run-x1"):
+		return "pod/cluster-run-x1-abcde", true
+	case params[0] == "logs":
+		return "CREATE TABLE\n", true
+	case strings.HasPrefix(command, "get job.batch/cluster-run-x1"):
+		if strings.Contains(command, ".status.succeeded") {
+			return "'1'", true
+		}
+		return "''", true
+	}
+	return "", false
+}
+
+func Test_waitJobCompletion(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+
+	t.Run("must wait until the job succeeds", func(t *testing.T) {
+		count := 0
+		var logs []string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			command := strings.Join(params, " ")
+			switch {
+			case params[0] == "logs":
+				logs = append(logs, command)
+				return "line 1\nline 2\n", nil
+			case strings.Contains(command, ".status.succeeded"):
+				if count++; count == 3 {
+					return "'1'", nil
+				}
+				return "''", nil
+			case params[1] == "pod":
+				return "pod/cluster-run-x1-abcde", nil
+			}
+			return "''", nil
+		}
+
+		got := k8sImpl.waitJobCompletion(context.Background(), "cluster-run-x1", "default")
+		if got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		if count != 3 {
+			t.Fatalf("Got %d checks, expect %d", count, 3)
+		}
+		if expect := "logs pod/cluster-run-x1-abcde -n default"; len(logs) != 3 || logs[0] != expect {
+			t.Fatalf("Got logs %v, expect 3 times %q", logs, expect)
+		}
+	})
+
+	t.Run("must fail with the exit reason when the job fails", func(t *testing.T) {
+		count := 0
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			command := strings.Join(params, " ")
+			switch {
+			case params[0] == "logs":
+				return "ERROR: syntax error at or near \"TABLE\"", nil
+			case params[1] == "pod":
+				return "pod/cluster-run-x1-abcde", nil
+			case strings.Contains(command, ".status.succeeded"):
+				count++
+				return "''", nil
+			case strings.Contains(command, `Failed")].status`):
+				return "'True'", nil
+			case strings.Contains(command, `Failed")].reason`):
+				return "'BackoffLimitExceeded'", nil
+			case strings.Contains(command, `Failed")].message`):
+				return "'Job has reached the specified backoff limit'", nil
+			case strings.Contains(command, "terminated.reason"):
+				return "'Error'", nil
+			case strings.Contains(command, "terminated.exitCode"):
+				return "'1'", nil
+			}
+			return "''", nil
+		}
+
+		expect := "job \"cluster-run-x1\" completed failed: job \"cluster-run-x1\" failed, BackoffLimitExceeded: Job has reached the specified backoff limit, pod/cluster-run-x1-abcde terminated with Error exit code 1"
+		got := k8sImpl.waitJobCompletion(context.Background(), "cluster-run-x1", "default")
+		if got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+		if count != 1 {
+			t.Fatalf("Got %d checks, expect %d", count, 1)
+		}
+	})
+
+	t.Run("must fail with the exit reason of the pod that started last", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			command := strings.Join(params, " ")
+			switch {
+			case params[0] == "logs":
+				return "", nil
+			case params[1] == "pod":
+				return "pod/cluster-run-x1-abcde\npod/cluster-run-x1-zzzzz", nil
+			case command == "get pod/cluster-run-x1-abcde -o jsonpath='{.status.startTime}' -n default":
+				return "'2026-10-02T10:00:00Z'", nil
+			case command == "get pod/cluster-run-x1-zzzzz -o jsonpath='{.status.startTime}' -n default":
+				return "'2026-10-01T10:00:00Z'", nil
+			case strings.Contains(command, `Failed")].status`):
+				return "'True'", nil
+			case strings.Contains(command, "terminated.reason"):
+				return "'Error'", nil
+			case strings.HasPrefix(command, "get pod/cluster-run-x1-abcde") && strings.Contains(command, "terminated.exitCode"):
+				return "'2'", nil
+			case strings.Contains(command, "terminated.exitCode"):
+				return "'1'", nil
+			}
+			return "''", nil
+		}
+
+		got := k8sImpl.waitJobCompletion(context.Background(), "cluster-run-x1", "default")
+		if got == nil || !strings.HasSuffix(got.Error(), "pod/cluster-run-x1-abcde terminated with Error exit code 2") {
+			t.Fatalf("Got error %v, expect the exit of pod/cluster-run-x1-abcde", got)
+		}
+	})
+
+	t.Run("must fail when the job image could not be pulled", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			command := strings.Join(params, " ")
+			switch {
+			case params[0] == "logs":
+				return "", errors.New("container is waiting to start")
+			case params[1] == "pod":
+				return "pod/cluster-run-x1-abcde", nil
+			case strings.Contains(command, "waiting.reason"):
+				return "'ErrImagePull'", nil
+			}
+			return "''", nil
+		}
+
+		got := k8sImpl.waitJobCompletion(context.Background(), "cluster-run-x1", "default")
+		if got == nil || !strings.Contains(got.Error(), "pod/cluster-run-x1-abcde is ErrImagePull") {
+			t.Fatalf("Got error %v, expect image pull failure", got)
+		}
+	})
+}
+
+func Test_printJobLogs(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	output := "line 1\n"
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		return output, nil
+	}
+
+	printed := map[string]int{}
+	k8sImpl.printJobLogs(context.Background(), []string{"pod/job-0"}, "default", printed)
+	output = "line 1\nline 2\nline 3\n"
+	k8sImpl.printJobLogs(context.Background(), []string{"pod/job-0"}, "default", printed)
+
+	if got := printed["pod/job-0"]; got != 3 {
+		t.Fatalf("Got %d lines printed, expect %d", got, 3)
+	}
+}
+
 func Test_createDatabaseJob(t *testing.T) {
 	// setup
 	wd, _ := os.Getwd()
 	os.Chdir("_test")
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	k8sImpl.registry = newDigestRegistry(t)
 
 	t.Run("we could create the database without errors", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if output, ok := succeededJob(params); ok {
+				return output, nil
+			}
 			return "", nil
 		}
 
 		var expect error = nil
-		got := k8sImpl.createDatabaseJob("cluster")
+		got := k8sImpl.createDatabaseJob(context.Background(), "cluster", JobSpec{}, 0)
 		if got != expect {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
 		}
 	})
 
 	t.Run("we should error when docker build error", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "build" {
 				return "", errors.New("error on docker build")
 			}
 			return "", nil
 		}
 
-		got := k8sImpl.createDatabaseJob("cluster")
+		got := k8sImpl.createDatabaseJob(context.Background(), "cluster", JobSpec{}, 0)
 		if got == nil {
 			t.Fatal("Got nil, expect error")
 		}
 	})
 
 	t.Run("we should error when docker push error", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "push" {
 				return "", errors.New("error on docker push")
 			}
 			return "", nil
 		}
 
-		got := k8sImpl.createDatabaseJob("cluster")
+		got := k8sImpl.createDatabaseJob(context.Background(), "cluster", JobSpec{}, 0)
 		if got == nil {
 			t.Fatal("Got nil, expect error")
 		}
 	})
 
 	t.Run("we should error when kubectl create error", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "create" {
 				return "", errors.New("error on kubeclt create")
 			}
 			return "", nil
 		}
 
-		got := k8sImpl.createDatabaseJob("cluster")
+		got := k8sImpl.createDatabaseJob(context.Background(), "cluster", JobSpec{}, 0)
 		if got == nil {
 			t.Fatal("Got nil, expect error")
 		}
 	})
 
+	t.Run("we should run the job with the digest that the push returns", func(t *testing.T) {
+		registry := k8sImpl.registry
+		defer func() { k8sImpl.registry = registry }()
+		k8sImpl.registry = &registryClient{url: "http://localhost:1", client: &http.Client{}}
+		var image string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "push" {
+				return "latest: digest: " + testImageDigest + " size: 528", nil
+			}
+			if params[0] == "create" {
+				image = params[2]
+				return "job.batch/cluster-run-x1 created", nil
+			}
+			if output, ok := succeededJob(params); ok {
+				return output, nil
+			}
+			return "", nil
+		}
+
+		if got := k8sImpl.createDatabaseJob(context.Background(), "cluster", JobSpec{}, 0); got != nil {
+			t.Fatalf("Got error %v, expect nil without asking the registry", got)
+		}
+		if image == "" {
+			t.Fatal("Got no job created, expect a job")
+		}
+	})
+
+	t.Run("we should run the job with the digest of the pushed image", func(t *testing.T) {
+		k8sImpl.dockerRegistryK8s = "localhost:32000"
+		defer func() { k8sImpl.dockerRegistryK8s = "" }()
+		var manifests []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			manifests = append(manifests, r.URL.Path)
+			w.Header().Set("Docker-Content-Digest", testImageDigest)
+		}))
+		defer server.Close()
+		registry := k8sImpl.registry
+		defer func() { k8sImpl.registry = registry }()
+		k8sImpl.registry = &registryClient{url: server.URL, client: server.Client()}
+		k8sImpl.dockerRegistry = server.URL
+		defer func() { k8sImpl.dockerRegistry = "" }()
+		var pushed []string
+		manifest := filepath.Join(tempDir(t), "digest-job.yml")
+		_ = ioutil.WriteFile(manifest, []byte("image: $JOB_IMAGE\nschema-version: \"$SCHEMA_VERSION\""), 0644)
+		var image string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "create" {
+				content, _ := ioutil.ReadFile(params[2])
+				image = string(content)
+				return "job.batch/cluster-run-x1 created", nil
+			}
+			if params[0] == "push" {
+				pushed = params[1:]
+			}
+			if output, ok := succeededJob(params); ok {
+				return output, nil
+			}
+			return "", nil
+		}
+
+		if got := k8sImpl.createDatabaseJob(context.Background(), "cluster", JobSpec{Manifest: manifest}, 2); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		if expect := "image: localhost:32000/cluster-job@" + testImageDigest + "\nschema-version: \"2\""; image != expect {
+			t.Fatalf("Got %q, expect %q", image, expect)
+		}
+		if expect := []string{registryHost(server.URL) + "/cluster-job"}; !reflect.DeepEqual(pushed, expect) {
+			t.Fatalf("Got push of %v, expect %v", pushed, expect)
+		}
+		if expect := []string{"/v2/cluster-job/manifests/latest"}; !reflect.DeepEqual(manifests, expect) {
+			t.Fatalf("Got manifests %v, expect the digest right after the push %v", manifests, expect)
+		}
+	})
+
+	t.Run("we should error when the digest of the pushed image is not found", func(t *testing.T) {
+		registry := k8sImpl.registry
+		defer func() { k8sImpl.registry = registry }()
+		k8sImpl.registry = &registryClient{url: "http://localhost:1", client: &http.Client{}}
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "create" {
+				t.Fatal("Unexpected job created without digest")
+			}
+			return "", nil
+		}
+
+		expect := "error getting digest of \"/cluster-job\""
+		if got := k8sImpl.createDatabaseJob(context.Background(), "cluster", JobSpec{}, 0); got == nil || !strings.HasPrefix(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
 	//tear down
 	os.Chdir(wd)
 }
@@ -2,86 +2,118 @@ package k8ssetup
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
-
-	"github.com/go-git/go-git/v5"
+	"time"
 )
 
 // K8sSetUp is an interface that defines our steps
 type K8sSetUp interface {
-	Initialize() error
-	InstallPostgresqlOperator() error
-	DatabaseCreation(fileName string) error
-	CheckKudoInstallation() error
-	KafkaClusterCreation(fileName string) error
+	Initialize(ctx context.Context) error
+	NamespaceCreation(ctx context.Context) error
+	InstallPostgresqlOperator(ctx context.Context) error
+	DatabaseCreation(ctx context.Context, fileName string) error
+	CheckKudoInstallation(ctx context.Context) error
+	KafkaClusterCreation(ctx context.Context, fileName string) error
+	KafkaTopicsCreation(ctx context.Context, clusterName string) error
+	Teardown(ctx context.Context, databaseFiles []string, kafkaClusters []string) error
+	Doctor(ctx context.Context) Checklist
+	Status(ctx context.Context) (Status, error)
+}
+
+// Options are the settings of a run that are not part of the spec
+type Options struct {
+	// Plan when set records the mutating commands instead of running them
+	Plan *Plan
+	// ConfirmContext is the name of the protected context that the run is allowed to change
+	ConfirmContext string
 }
 
 type k8sSetUpImpl struct {
+	spec              *Spec
+	plan              *Plan
+	confirmContext    string
+	cluster           clusterClient
 	kubectlPath       string
-	dockerPath        string
+	kudoPath          string
+	builder           imageBuilder
+	registry          *registryClient
 	dockerRegistry    string
 	dockerRegistryK8s string
 	psqlOperatorRepo  string
-	executeCommand    func(cmdName string, params ...string) (string, error)
+	executeCommand    func(ctx context.Context, cmdName string, params ...string) (string, error)
 }
 
 const (
-	zalandoPsqlOperator = "https://github.com/zalando/postgres-operator.git"
+	zalandoPsqlOperator        = "https://github.com/zalando/postgres-operator.git"
+	zalandoPsqlOperatorVersion = "v1.5.0"
 )
 
-func (k k8sSetUpImpl) InstallPostgresqlOperator() error {
-	log.Println("Installing PostgreSQL operator ...")
+var (
+	podFailureReasons = map[string]bool{
+		"ErrImagePull":               true,
+		"ImagePullBackOff":           true,
+		"InvalidImageName":           true,
+		"CreateContainerConfigError": true,
+	}
+)
 
-	if installed := k.isPostgreSQLOperatorInstalled(); !installed {
-		log.Println("PostgreSQL operator not installed ...")
-		if err := k.doPsqlOperatorInstallation(); err != nil {
+func (k k8sSetUpImpl) InstallPostgresqlOperator(ctx context.Context) error {
+	logger.Infof(ctx, "Installing PostgreSQL operator ...")
+
+	if installed := k.isPostgreSQLOperatorInstalled(ctx); !installed {
+		logger.Infof(ctx, "PostgreSQL operator not installed ...")
+		if err := k.doPsqlOperatorInstallation(ctx); err != nil {
+			return fmt.Errorf("error installing PostgreSQL operator: %v", err)
+		}
+		if err := k.waitPsqlOperatorRunning(ctx); err != nil {
 			return fmt.Errorf("error installing PostgreSQL operator: %v", err)
 		}
-		k.waitPsqlOperatorRunning()
 
 	} else {
-		log.Println("PostgreSQL operator is installed ...")
+		logger.Infof(ctx, "PostgreSQL operator is installed ...")
+		recordResource(ctx, "service/postgres-operator", resourcePresent)
 	}
 
 	return nil
 }
 
-func (k k8sSetUpImpl) CheckKudoInstallation() error {
-	log.Println("Checking kudo installation ...")
+func (k k8sSetUpImpl) CheckKudoInstallation(ctx context.Context) error {
+	logger.Infof(ctx, "Checking kudo installation ...")
 
-	if _, err := k.kubectl("kudo", "version"); err != nil {
+	if _, err := k.cluster.kudo(ctx, "version"); err != nil {
 		return fmt.Errorf("kudo is not installed: %v", err)
 	}
-	log.Println("Kudo is installed ...")
+	logger.Infof(ctx, "Kudo is installed ...")
 	return nil
 }
 
-func (k k8sSetUpImpl) waitPsqlOperatorRunning() {
-	cnt := true
-	for cnt {
-		running, err := k.isPsqlOperatorRunning()
-		cnt = !(err == nil && running)
+func (k k8sSetUpImpl) waitPsqlOperatorRunning(ctx context.Context) error {
+	if err := k.waitFor(ctx, "psql operator running", k.spec.Timeouts.Operator, k.isPsqlOperatorRunning); err != nil {
+		return err
 	}
-	log.Print("Psql operator is running")
+	logger.Infof(ctx, "Psql operator is running")
+	return nil
 }
 
-func (k k8sSetUpImpl) isPodRunning(name, namespace string) (running bool, err error) {
-	log.Printf("Checking if %s operator is already running ...", name)
+func (k k8sSetUpImpl) isPodRunning(ctx context.Context, name, namespace string) (running bool, err error) {
+	logger.Infof(ctx, "Checking if %s operator is already running ...", name)
 
-	var podNames, output string
-	if podNames, err = k.kubectl("get", "pod", "-o", "name", "-n", namespace); err == nil {
-		for _, podName := range strings.Split(podNames, "\n") {
+	var podNames []string
+	var output string
+	if podNames, err = k.cluster.listResources(ctx, "pod", "", namespace); err == nil {
+		for _, podName := range podNames {
 			if strings.Contains(podName, name) {
-				if output, err = k.kubectl("get", podName, "-o", "jsonpath='{.status.containerStatuses[0].ready}'", "-n", namespace); err != nil {
+				if output, err = k.cluster.getField(ctx, podName, namespace, ".status.containerStatuses[0].ready"); err != nil {
 					return false, err
 				}
-				running = output == "'true'"
+				if running = output == "true"; !running {
+					err = k.checkPodFailure(ctx, podName, namespace)
+				}
 				break
 			}
 		}
@@ -89,92 +121,142 @@ func (k k8sSetUpImpl) isPodRunning(name, namespace string) (running bool, err er
 	return
 }
 
-func (k k8sSetUpImpl) isPsqlOperatorRunning() (bool, error) {
-	return k.isPodRunning("postgres-operator", "default")
+// checkPodFailure returns a permanent error when the pod is waiting for something that will not happen
+func (k k8sSetUpImpl) checkPodFailure(ctx context.Context, podName, namespace string) error {
+	reason, err := k.cluster.getField(ctx, podName, namespace, ".status.containerStatuses[0].state.waiting.reason")
+	if err != nil {
+		return err
+	}
+	if podFailureReasons[reason] {
+		return permanentError{fmt.Errorf("%s is %s", podName, reason)}
+	}
+	return nil
 }
 
-func (k k8sSetUpImpl) isPostgreSQLOperatorInstalled() bool {
-	log.Println("Checking if postgresql operator is already installed ...")
-	if _, err := k.kubectl("describe", "service/postgres-operator"); err != nil {
-		return false
-	}
+func (k k8sSetUpImpl) isPsqlOperatorRunning(ctx context.Context) (bool, error) {
+	return k.isPodRunning(ctx, "postgres-operator", k.namespace())
+}
 
-	return true
+func (k k8sSetUpImpl) isPostgreSQLOperatorInstalled(ctx context.Context) bool {
+	logger.Infof(ctx, "Checking if postgresql operator is already installed ...")
+	installed, err := k.cluster.resourceExists(ctx, "service", "postgres-operator", k.namespace())
+	return err == nil && installed
 }
 
-func (k *k8sSetUpImpl) doPsqlOperatorInstallation() error {
-	log.Println("Installing postgreSQL operator ...")
-	dir, err := ioutil.TempDir("", "pets-go-infra")
-	if err != nil {
-		return fmt.Errorf("error creating temp dir: %v", err)
-	}
-	//noinspection GoUnhandledErrorResult
-	defer os.RemoveAll(dir)
-	log.Printf("Created temp dir %s ...", dir)
+// psqlOperatorManifests are the manifests of the operator that we apply in order, they are in the manifests dir of its repository
+var psqlOperatorManifests = []string{
+	"configmap.yaml",
+	"operator-service-account-rbac.yaml",
+	"postgres-operator.yaml",
+	"api-service.yaml",
+}
 
-	if _, err = git.PlainClone(dir, false, &git.CloneOptions{
-		URL:      k.psqlOperatorRepo,
-		Progress: os.Stdout,
-	}); err != nil {
-		return fmt.Errorf("error clonning postgres operator: %v", err)
+func removeDir(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		logger.Warnf(context.Background(), "error removing dir %s: %v", dir, err)
 	}
-	log.Println("Zalando postgresSQL operator repo cloned ...")
+}
 
-	var files = []string{
-		"manifests/configmap.yaml",
-		"manifests/operator-service-account-rbac.yaml",
-		"manifests/postgres-operator.yaml",
-		"manifests/api-service.yaml",
+func (k *k8sSetUpImpl) doPsqlOperatorInstallation(ctx context.Context) error {
+	logger.Infof(ctx, "Installing postgreSQL operator ...")
+	fsys, err := k.psqlOperatorFiles(ctx)
+	if err != nil {
+		return err
 	}
-	for _, v := range files {
-		log.Printf("Creating %q", v)
-		if _, err := k.kubectl("create", "-f", filepath.Join(dir, v)); err != nil {
+
+	for _, v := range psqlOperatorManifests {
+		logger.Infof(ctx, "Creating %q", v)
+		namespaced, clusterScoped, err := k.splitPsqlOperatorManifest(fsys, v)
+		if err != nil {
+			return err
+		}
+		// the cluster scoped objects go first, the service account of a binding could not run without its role
+		for _, object := range clusterScoped {
+			if err = k.ensureClusterScoped(ctx, object); err != nil {
+				removeFile(namespaced)
+				return fmt.Errorf("error in kubectl: %v", err)
+			}
+		}
+		if namespaced == "" {
+			continue
+		}
+		_, err = k.cluster.create(ctx, namespaced, k.namespace())
+		removeFile(namespaced)
+		if err != nil {
 			return fmt.Errorf("error in kubectl: %v", err)
 		}
+		recordResource(ctx, v, resourceCreated)
 	}
 
 	return nil
 }
 
-func (k k8sSetUpImpl) kubectl(params ...string) (output string, err error) {
-	return k.executeCommand(k.kubectlPath, params...)
+// kubectl runs a kubectl command with the kubeconfig and the context of the spec, their flags go after the command
+// so the plan still finds its verb and before the -- of exec
+func (k k8sSetUpImpl) kubectl(ctx context.Context, params ...string) (output string, err error) {
+	if flags := k.kubectlFlags(); len(flags) != 0 && len(params) != 0 {
+		params = append(append([]string{params[0]}, flags...), params[1:]...)
+	}
+	return k.executeCommand(ctx, k.kubectlPath, params...)
 }
 
-func (k k8sSetUpImpl) docker(params ...string) (output string, err error) {
-	return k.executeCommand(k.dockerPath, params...)
+func (k k8sSetUpImpl) kubectlFlags() (flags []string) {
+	if k.spec.Cluster.Kubeconfig != "" {
+		flags = append(flags, "--kubeconfig", k.spec.Cluster.Kubeconfig)
+	}
+	if k.spec.Cluster.Context != "" {
+		flags = append(flags, "--context", k.spec.Cluster.Context)
+	}
+	return
 }
 
-func (k k8sSetUpImpl) defaultExecuteCommand(cmdName string, params ...string) (output string, err error) {
-	cmd := exec.Command(cmdName, params...)
+func (k k8sSetUpImpl) defaultExecuteCommand(ctx context.Context, cmdName string, params ...string) (output string, err error) {
+	timeout := time.Duration(k.spec.Timeouts.Command)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, cmdName, params...)
 
 	var stdBuffer bytes.Buffer
 	cmd.Stdout = &stdBuffer
 	cmd.Stderr = &stdBuffer
 
+	start := time.Now()
 	err = cmd.Run()
 	output = stdBuffer.String()
-	log.Println(output)
-	if err != nil {
+	logCtx := WithLogField(ctx, commandField, formatCommand(cmdName, params...))
+	logger.Debugf(WithLogField(logCtx, durationField, time.Since(start).Round(time.Millisecond)), "%s", strings.TrimSpace(output))
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v in %q %q", timeout, cmdName, output)
+	} else if err != nil {
 		err = fmt.Errorf("error '%v' in %q %q", err, cmdName, output)
 	}
 	return
 }
 
-func (k k8sSetUpImpl) isResourceCreated(rtype, name, namespace string) (bool, error) {
-	log.Printf("Checking if resource %q name %q is already created ...", rtype, name)
-	if _, err := k.kubectl("describe", rtype+"/"+name, "-n", namespace); err != nil {
-		return false, err
-	}
-
-	return true, nil
+func (k k8sSetUpImpl) isResourceCreated(ctx context.Context, rtype, name, namespace string) (bool, error) {
+	logger.Infof(ctx, "Checking if resource %q name %q is already created ...", rtype, name)
+	return k.cluster.resourceExists(ctx, rtype, name, namespace)
 }
 
-// NewK8sSetUp returns a K8sSetUp interface
+// NewK8sSetUp returns a K8sSetUp interface for the default spec
 func NewK8sSetUp() K8sSetUp {
+	return NewK8sSetUpWithSpec(DefaultSpec(), Options{})
+}
+
+// NewK8sSetUpWithSpec returns a K8sSetUp interface for the given spec
+func NewK8sSetUpWithSpec(spec *Spec, options Options) K8sSetUp {
 	impl := &k8sSetUpImpl{
-		psqlOperatorRepo: zalandoPsqlOperator,
+		spec:             spec,
+		plan:             options.Plan,
+		confirmContext:   options.ConfirmContext,
+		psqlOperatorRepo: spec.Operator.Repository,
 	}
 	impl.executeCommand = impl.defaultExecuteCommand
+	if impl.planMode() {
+		impl.executeCommand = planExecuteCommand(impl.plan, impl.executeCommand)
+	}
+	impl.cluster = kubectlClient{k: impl}
+	impl.builder = newImageBuilder(impl, dockerBuilder, dockerCommand)
 
 	return impl
 }
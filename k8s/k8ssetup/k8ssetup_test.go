@@ -1,11 +1,20 @@
 package k8ssetup
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
+	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 const (
@@ -20,6 +29,57 @@ func getFilePath(cmd string) string {
 	return path
 }
 
+func TestMain(m *testing.M) {
+	// we do not want to wait between checks in our tests
+	defaultBackoff = BackoffSpec{
+		Initial: Duration(time.Millisecond),
+		Max:     Duration(time.Millisecond),
+		Factor:  1,
+	}
+	// nor to clone the operator in the user cache
+	cacheDir, err := ioutil.TempDir("", "operator-cache")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %v", err)
+	}
+	defaultOperatorCacheDir = cacheDir
+	code := m.Run()
+	os.RemoveAll(cacheDir)
+	os.Exit(code)
+}
+
+// newLocalPsqlOperatorRepo creates a git repository with the operator manifests so it could be cloned offline
+func newLocalPsqlOperatorRepo(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "psql-operator-repo")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("error creating repo: %v", err)
+	}
+	wt, _ := repo.Worktree()
+	for _, v := range psqlOperatorManifests {
+		file := filepath.Join(dir, "manifests", v)
+		_ = os.MkdirAll(filepath.Dir(file), 0755)
+		if err = ioutil.WriteFile(file, []byte("kind: "+v), 0644); err != nil {
+			t.Fatalf("error writing %q: %v", file, err)
+		}
+		_, _ = wt.Add(path.Join("manifests", v))
+	}
+	hash, err := wt.Commit("manifests", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@test.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("error committing: %v", err)
+	}
+	if _, err = repo.CreateTag(zalandoPsqlOperatorVersion, hash, nil); err != nil {
+		t.Fatalf("error tagging: %v", err)
+	}
+	return dir
+}
+
 func Test_executeCommand(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
@@ -27,7 +87,7 @@ func Test_executeCommand(t *testing.T) {
 		expect := "ok params: param-1 param-2 param-3\n"
 		var expectErr error = nil
 		cmd := getFilePath(okCommand)
-		got, gotErr := k8sImpl.executeCommand(cmd, "param-1", "param-2", "param-3")
+		got, gotErr := k8sImpl.executeCommand(context.Background(), cmd, "param-1", "param-2", "param-3")
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
@@ -40,7 +100,7 @@ func Test_executeCommand(t *testing.T) {
 		expect := "ko params: param-1 param-2 param-3\n"
 		expectErr := "error 'exit status 255'"
 		cmd := getFilePath(koCommand)
-		got, gotErr := k8sImpl.executeCommand(cmd, "param-1", "param-2", "param-3")
+		got, gotErr := k8sImpl.executeCommand(context.Background(), cmd, "param-1", "param-2", "param-3")
 		if !strings.Contains(gotErr.Error(), expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
@@ -50,12 +110,23 @@ func Test_executeCommand(t *testing.T) {
 	})
 }
 
+func Test_executeCommandTimeout(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	k8sImpl.spec.Timeouts.Command = Duration(20 * time.Millisecond)
+
+	expectErr := "timed out after 20ms in \"sleep\""
+	_, gotErr := k8sImpl.executeCommand(context.Background(), "sleep", "5")
+	if gotErr == nil || !strings.Contains(gotErr.Error(), expectErr) {
+		t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
+	}
+}
+
 func Test_kubectl(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 	expect := "ok params: param-1 param-2 param-3\n"
 	var expectErr error = nil
 	k8sImpl.kubectlPath = getFilePath(okCommand)
-	got, gotErr := k8sImpl.kubectl("param-1", "param-2", "param-3")
+	got, gotErr := k8sImpl.kubectl(context.Background(), "param-1", "param-2", "param-3")
 	if gotErr != expectErr {
 		t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 	}
@@ -64,17 +135,23 @@ func Test_kubectl(t *testing.T) {
 	}
 }
 
-func Test_docker(t *testing.T) {
+func Test_kubectlFlags(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
-	expect := "ok params: param-1 param-2 param-3\n"
-	var expectErr error = nil
-	k8sImpl.dockerPath = getFilePath(okCommand)
-	got, gotErr := k8sImpl.docker("param-1", "param-2", "param-3")
-	if gotErr != expectErr {
-		t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
+	k8sImpl.spec.Cluster.Kubeconfig = "/tmp/kubeconfig"
+	k8sImpl.spec.Cluster.Context = "dev"
+	var got []string
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		got = params
+		return "", nil
 	}
-	if got != expect {
-		t.Fatalf("Got %q, expect %q", got, expect)
+
+	_, _ = k8sImpl.kubectl(context.Background(), "exec", "pod/kafka-0", "-n", "pets", "--", "kafka-topics.sh", "--create")
+	expect := []string{"exec", "--kubeconfig", "/tmp/kubeconfig", "--context", "dev", "pod/kafka-0", "-n", "pets", "--", "kafka-topics.sh", "--create"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("Got %v, expect %v", got, expect)
+	}
+	if !isMutatingCommand(got) {
+		t.Fatalf("Got not mutating, expect the plan finds the verb")
 	}
 }
 
@@ -84,7 +161,7 @@ func Test_isPostgreSQLOperatorInstalled(t *testing.T) {
 	t.Run("postgresql is installed", func(t *testing.T) {
 		expect := true
 		k8sImpl.kubectlPath = getFilePath(okCommand)
-		got := k8sImpl.isPostgreSQLOperatorInstalled()
+		got := k8sImpl.isPostgreSQLOperatorInstalled(context.Background())
 		if got != expect {
 			t.Fatalf("Got %v, expect %v", got, expect)
 		}
@@ -93,7 +170,7 @@ func Test_isPostgreSQLOperatorInstalled(t *testing.T) {
 	t.Run("postgresql is not installed", func(t *testing.T) {
 		expect := false
 		k8sImpl.kubectlPath = getFilePath(koCommand)
-		got := k8sImpl.isPostgreSQLOperatorInstalled()
+		got := k8sImpl.isPostgreSQLOperatorInstalled(context.Background())
 		if got != expect {
 			t.Fatalf("Got %v, expect %v", got, expect)
 		}
@@ -106,7 +183,7 @@ func Test_doPsqlOperatorInstallation(t *testing.T) {
 	t.Run("install psql operator runs ok", func(t *testing.T) {
 		k8sImpl.kubectlPath = getFilePath(okCommand)
 		var expect error = nil
-		got := k8sImpl.doPsqlOperatorInstallation()
+		got := k8sImpl.doPsqlOperatorInstallation(context.Background())
 		if got != expect {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
 		}
@@ -115,7 +192,7 @@ func Test_doPsqlOperatorInstallation(t *testing.T) {
 	t.Run("install psql operator runs ko when kubectl fails", func(t *testing.T) {
 		k8sImpl.kubectlPath = getFilePath(koCommand)
 		expect := "error in kubectl"
-		got := k8sImpl.doPsqlOperatorInstallation()
+		got := k8sImpl.doPsqlOperatorInstallation(context.Background())
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
 		}
@@ -125,7 +202,7 @@ func Test_doPsqlOperatorInstallation(t *testing.T) {
 		k8sImpl.kubectlPath = getFilePath(okCommand)
 		k8sImpl.psqlOperatorRepo = "http://no-repo.com"
 		expect := "error clonning postgres operator"
-		got := k8sImpl.doPsqlOperatorInstallation()
+		got := k8sImpl.doPsqlOperatorInstallation(context.Background())
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
 		}
@@ -136,7 +213,7 @@ func Test_InstallPostgresqlOperator(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
 	t.Run("install postgresql operator runs ok", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "describe" {
 				return "error", errors.New("some error")
 			}
@@ -150,29 +227,29 @@ func Test_InstallPostgresqlOperator(t *testing.T) {
 			return "", nil
 		}
 		var expect error = nil
-		got := k8sImpl.InstallPostgresqlOperator()
+		got := k8sImpl.InstallPostgresqlOperator(context.Background())
 		if got != expect {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
 		}
 	})
 
 	t.Run("install postgresql operator ok if its installed", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "", nil
 		}
 		var expect error = nil
-		got := k8sImpl.InstallPostgresqlOperator()
+		got := k8sImpl.InstallPostgresqlOperator(context.Background())
 		if got != expect {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
 		}
 	})
 
 	t.Run("install postgresql operator runs ko when installation fails", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "error", errors.New("some error")
 		}
 		expect := "error installing PostgreSQL operator"
-		got := k8sImpl.InstallPostgresqlOperator()
+		got := k8sImpl.InstallPostgresqlOperator(context.Background())
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect error %v", got, expect)
 		}
@@ -184,7 +261,7 @@ func Test_waitPsqlOperatorRunning(t *testing.T) {
 
 	t.Run("must run until database is running", func(t *testing.T) {
 		count := 0
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "get" {
 				if params[1] == "pod" {
 					return "pod/postgres-operator-59bb89464c-dx2rs", nil
@@ -199,7 +276,7 @@ func Test_waitPsqlOperatorRunning(t *testing.T) {
 			return "", nil
 		}
 
-		k8sImpl.waitPsqlOperatorRunning()
+		k8sImpl.waitPsqlOperatorRunning(context.Background())
 		expect := 3
 		got := count
 		if got != expect {
@@ -210,7 +287,7 @@ func Test_waitPsqlOperatorRunning(t *testing.T) {
 	t.Run("must run until there is no error and running", func(t *testing.T) {
 		var errInvalid = errors.New("invalid")
 		count := 0
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "get" {
 				if params[1] == "pod" {
 					return "pod/postgres-operator-59bb89464c-dx2rs", nil
@@ -225,7 +302,7 @@ func Test_waitPsqlOperatorRunning(t *testing.T) {
 			return "", nil
 		}
 
-		k8sImpl.waitPsqlOperatorRunning()
+		k8sImpl.waitPsqlOperatorRunning(context.Background())
 		expect := 2
 		got := count
 		if got != expect {
@@ -238,7 +315,7 @@ func Test_isPsqlOperatorRunning(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
 	t.Run("must return true if postgres operator is running", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "get" {
 				if params[1] == "pod" {
 					return "pod/postgres-operator-59bb89464c-dx2rs", nil
@@ -251,7 +328,7 @@ func Test_isPsqlOperatorRunning(t *testing.T) {
 
 		expect := true
 		var expectErr error = nil
-		got, gotErr := k8sImpl.isPsqlOperatorRunning()
+		got, gotErr := k8sImpl.isPsqlOperatorRunning(context.Background())
 
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -262,7 +339,7 @@ func Test_isPsqlOperatorRunning(t *testing.T) {
 	})
 
 	t.Run("must return false if postgres operator does not exist", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "get" {
 				if params[1] == "pod" {
 					return "pod/test-1234", nil
@@ -273,7 +350,7 @@ func Test_isPsqlOperatorRunning(t *testing.T) {
 
 		expect := false
 		var expectErr error = nil
-		got, gotErr := k8sImpl.isPsqlOperatorRunning()
+		got, gotErr := k8sImpl.isPsqlOperatorRunning(context.Background())
 
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -284,7 +361,7 @@ func Test_isPsqlOperatorRunning(t *testing.T) {
 	})
 
 	t.Run("must return false if postgres operator is in progress", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "get" {
 				if params[1] == "pod" {
 					return "pod/postgres-operator-59bb89464c-dx2rs", nil
@@ -297,7 +374,7 @@ func Test_isPsqlOperatorRunning(t *testing.T) {
 
 		expect := false
 		var expectErr error = nil
-		got, gotErr := k8sImpl.isPsqlOperatorRunning()
+		got, gotErr := k8sImpl.isPsqlOperatorRunning(context.Background())
 
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -309,7 +386,7 @@ func Test_isPsqlOperatorRunning(t *testing.T) {
 
 	t.Run("must return false if get pods fails", func(t *testing.T) {
 		var errInvalid = errors.New("invalid")
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "get" {
 				if params[1] == "pod" {
 					return "", errInvalid
@@ -320,7 +397,7 @@ func Test_isPsqlOperatorRunning(t *testing.T) {
 
 		expect := false
 		expectErr := errInvalid
-		got, gotErr := k8sImpl.isPsqlOperatorRunning()
+		got, gotErr := k8sImpl.isPsqlOperatorRunning(context.Background())
 
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -332,7 +409,7 @@ func Test_isPsqlOperatorRunning(t *testing.T) {
 
 	t.Run("must return false if postgres operator status check fails", func(t *testing.T) {
 		var errInvalid = errors.New("invalid")
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "get" {
 				if params[1] == "pod" {
 					return "pod/postgres-operator-59bb89464c-dx2rs", nil
@@ -345,7 +422,7 @@ func Test_isPsqlOperatorRunning(t *testing.T) {
 
 		expect := false
 		expectErr := errInvalid
-		got, gotErr := k8sImpl.isPsqlOperatorRunning()
+		got, gotErr := k8sImpl.isPsqlOperatorRunning(context.Background())
 
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -361,7 +438,7 @@ func Test_CheckKudoInstallation(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
 	t.Run("kudo is installed", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "kudo" && params[1] == "version" {
 				return "", nil
 			}
@@ -369,14 +446,14 @@ func Test_CheckKudoInstallation(t *testing.T) {
 		}
 
 		var expectErr error = nil
-		gotErr := k8sImpl.CheckKudoInstallation()
+		gotErr := k8sImpl.CheckKudoInstallation(context.Background())
 		if gotErr != expectErr {
 			t.Fatalf("Got %v, expect %v", gotErr, expectErr)
 		}
 	})
 
 	t.Run("kudo is not installed", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "kudo" && params[1] == "version" {
 				return "error", errInvalid
 			}
@@ -384,7 +461,7 @@ func Test_CheckKudoInstallation(t *testing.T) {
 		}
 
 		expectErr := "kudo is not installed"
-		gotErr := k8sImpl.CheckKudoInstallation()
+		gotErr := k8sImpl.CheckKudoInstallation(context.Background())
 		if !strings.Contains(gotErr.Error(), expectErr) {
 			t.Fatalf("Got %v, expect %v", gotErr, expectErr)
 		}
@@ -1,10 +1,12 @@
 package k8ssetup
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -19,10 +21,34 @@ type KudoInstances []struct {
 	} `json:"spec"`
 }
 
-func (k k8sSetUpImpl) isKafkaClusterCreated(cluster string) (bool, error) {
+func (k k8sSetUpImpl) getKudoInstances(ctx context.Context) (data KudoInstances, err error) {
+	var output string
+	if output, err = k.cluster.kudo(ctx, "get", "instances", "-o", "json", "--namespace", k.namespace()); err != nil {
+		return nil, fmt.Errorf("error getting kudo instances: %v", err)
+	}
+	if err = json.Unmarshal([]byte(output), &data); err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+	return
+}
+
+func (k k8sSetUpImpl) isKudoInstanceCreated(ctx context.Context, instance string) (bool, error) {
+	data, err := k.getKudoInstances(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range data {
+		if v.Metadata.Name == instance {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (k k8sSetUpImpl) isKafkaClusterCreated(ctx context.Context, cluster string) (bool, error) {
 	var output string
 	var err error
-	if output, err = k.kubectl("kudo", "get", "instances", "-o", "json"); err != nil {
+	if output, err = k.cluster.kudo(ctx, "get", "instances", "-o", "json", "--namespace", k.namespace()); err != nil {
 		return false, fmt.Errorf("error getting kudo instances: %v", err)
 	}
 	var jsonBytes []byte = []byte(output)
@@ -53,71 +79,160 @@ func (k k8sSetUpImpl) isKafkaClusterCreated(cluster string) (bool, error) {
 	return false, errors.New("Error not found kafka and zookeeper")
 }
 
-func (k k8sSetUpImpl) createZookeeperCluster(name string) error {
-	log.Println("Installing zookeper cluster ...")
-	if _, err := k.kubectl("kudo", "install", "zookeeper", "--instance", fmt.Sprintf("\"zookeeper-%s\"", name)); err != nil {
+var (
+	kafkaDerivedParameters     = []string{"BROKER_COUNT", "DISK_SIZE", "ZOOKEEPER_URI"}
+	zookeeperDerivedParameters = []string{"NODE_COUNT", "DISK_SIZE"}
+)
+
+// kudo names the pods of an instance as <instance>-<operator>-<ordinal>
+func zookeeperPodPrefix(name string) string {
+	return fmt.Sprintf("zookeeper-%s-zookeeper", name)
+}
+
+func kafkaPodPrefix(name string) string {
+	return fmt.Sprintf("kafka-%s-kafka", name)
+}
+
+// zookeeperURI returns every zookeeper node through the headless service of the ensemble
+func zookeeperURI(kafka KafkaSpec) string {
+	nodes := make([]string, kafka.Zookeeper.Nodes)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("%s-%d.zookeeper-%s-hs:2181", zookeeperPodPrefix(kafka.Name), i, kafka.Name)
+	}
+	return strings.Join(nodes, ",")
+}
+
+// kudoParameters returns the parameters as kudo -p flags sorted by name
+func kudoParameters(parameters map[string]string) (params []string) {
+	names := make([]string, 0, len(parameters))
+	for k := range parameters {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, v := range names {
+		params = append(params, "-p", v+"="+parameters[v])
+	}
+	return
+}
+
+func zookeeperParameters(kafka KafkaSpec) map[string]string {
+	parameters := map[string]string{"NODE_COUNT": strconv.Itoa(kafka.Zookeeper.Nodes)}
+	if kafka.Zookeeper.StorageSize != "" {
+		parameters["DISK_SIZE"] = kafka.Zookeeper.StorageSize
+	}
+	for k, v := range kafka.Zookeeper.Parameters {
+		parameters[k] = v
+	}
+	return parameters
+}
+
+func kafkaParameters(kafka KafkaSpec) map[string]string {
+	parameters := map[string]string{
+		"BROKER_COUNT":  strconv.Itoa(kafka.Brokers),
+		"ZOOKEEPER_URI": zookeeperURI(kafka),
+	}
+	if kafka.StorageSize != "" {
+		parameters["DISK_SIZE"] = kafka.StorageSize
+	}
+	for k, v := range kafka.Parameters {
+		parameters[k] = v
+	}
+	return parameters
+}
+
+func (k k8sSetUpImpl) createZookeeperCluster(ctx context.Context, name string) error {
+	logger.Infof(ctx, "Installing zookeper cluster ...")
+	params := append([]string{"install", "zookeeper", "--instance", "zookeeper-" + name, "--namespace", k.namespace()}, kudoParameters(zookeeperParameters(k.spec.kafkaCluster(name)))...)
+	if _, err := k.cluster.kudo(ctx, params...); err != nil {
+		return fmt.Errorf("Error creating zookeeper cluster: %v", err)
+	}
+	if err := k.waitZookeeperRunning(ctx, name); err != nil {
 		return fmt.Errorf("Error creating zookeeper cluster: %v", err)
 	}
-	k.waitZookeeperRunning(name)
 	return nil
 }
 
-func (k k8sSetUpImpl) waitZookeeperRunning(name string) {
-	cnt := true
-	for cnt {
-		allReady := true
-		for i := 0; i < 3; i++ {
-			ready, err := k.isPodRunning(fmt.Sprintf("zookeeper-%s-%d", name, i), "default")
-			if err == nil {
-				allReady = allReady && ready
-			}
+func (k k8sSetUpImpl) arePodsRunning(ctx context.Context, prefix string, pods int) (bool, error) {
+	allReady := true
+	for i := 0; i < pods; i++ {
+		ready, err := k.isPodRunning(ctx, fmt.Sprintf("%s-%d", prefix, i), k.namespace())
+		if err != nil {
+			return false, err
 		}
-		cnt = !allReady
+		allReady = allReady && ready
 	}
-	log.Print("Zookeeper operator is running")
+	return allReady, nil
 }
 
-func (k k8sSetUpImpl) createKafkaCluster(name string) error {
-	log.Println("Installing kafka cluster ...")
-	if _, err := k.kubectl("kudo", "install", "kafka", "--instance", fmt.Sprintf("\"kafka-%s\"", name), "-p", "ZOOKEEPER_URI=\"zookeeper-pets-zookeeper-0.zookeeper-pets-hs:2181,zookeeper-pets-zookeeper-1.zookeeper-pets-hs:2181,zookeeper-pets-zookeeper-2.zookeeper-pets-hs:2181\""); err != nil {
+func (k k8sSetUpImpl) waitZookeeperRunning(ctx context.Context, name string) error {
+	if err := k.waitFor(ctx, fmt.Sprintf("zookeeper %q running", name), k.spec.Timeouts.Zookeeper, func(ctx context.Context) (bool, error) {
+		return k.arePodsRunning(ctx, zookeeperPodPrefix(name), k.spec.kafkaCluster(name).Zookeeper.Nodes)
+	}); err != nil {
+		return err
+	}
+	logger.Infof(ctx, "Zookeeper operator is running")
+	return nil
+}
+
+func (k k8sSetUpImpl) createKafkaCluster(ctx context.Context, name string) error {
+	logger.Infof(ctx, "Installing kafka cluster ...")
+	params := append([]string{"install", "kafka", "--instance", "kafka-" + name, "--namespace", k.namespace()}, kudoParameters(kafkaParameters(k.spec.kafkaCluster(name)))...)
+	if _, err := k.cluster.kudo(ctx, params...); err != nil {
+		return fmt.Errorf("Error creating kafka cluster: %v", err)
+	}
+
+	if err := k.waitKafkaClusterCreation(ctx, name); err != nil {
 		return fmt.Errorf("Error creating kafka cluster: %v", err)
 	}
-	
-	k.waitKafkaClusterCreation(name)
 	return nil
 }
 
-func (k k8sSetUpImpl) waitKafkaClusterCreation(name string) {
-	cnt := true
-	for cnt {
-		allReady := true
-		for i := 0; i < 3; i++ {
-			ready, err := k.isPodRunning(fmt.Sprintf("kafka-%s-%d", name, i), "default")
-			if err == nil {
-				allReady = allReady && ready
-			}
-		}
-		cnt = !allReady
+func (k k8sSetUpImpl) waitKafkaClusterCreation(ctx context.Context, name string) error {
+	if err := k.waitFor(ctx, fmt.Sprintf("kafka %q running", name), k.spec.Timeouts.Kafka, func(ctx context.Context) (bool, error) {
+		return k.arePodsRunning(ctx, kafkaPodPrefix(name), k.spec.kafkaCluster(name).Brokers)
+	}); err != nil {
+		return err
 	}
-	log.Print("Kafka operator is running")
+	logger.Infof(ctx, "Kafka operator is running")
+	return nil
 }
 
-func (k *k8sSetUpImpl) KafkaClusterCreation(clusterName string) error {
-	log.Printf("Creating kafka with name %q ...", clusterName)
+// KafkaClusterCreation creates the zookeeper and kafka clusters that do not exist and checks that both are running
+func (k *k8sSetUpImpl) KafkaClusterCreation(ctx context.Context, clusterName string) error {
+	logger.Infof(ctx, "Creating kafka with name %q ...", clusterName)
+	recordOutput(ctx, "zookeeper", "zookeeper-"+clusterName)
+	recordOutput(ctx, "kafka", "kafka-"+clusterName)
 	var err error
-	
-	if created, err := k.isKafkaClusterCreated(clusterName); err == nil && created {
-		return fmt.Errorf("kafka cluster %q already exists", clusterName)
+
+	if created, err := k.isKafkaClusterCreated(ctx, clusterName); err == nil && created {
+		logger.Infof(ctx, "Kafka cluster %q already exists, checking it is running ...", clusterName)
+		if err = k.waitZookeeperRunning(ctx, clusterName); err != nil {
+			return fmt.Errorf("error checking zookeeper cluster %q: %v", clusterName, err)
+		}
+		if err = k.waitKafkaClusterCreation(ctx, clusterName); err != nil {
+			return fmt.Errorf("error checking kafka cluster %q: %v", clusterName, err)
+		}
+		recordResource(ctx, "kudo-instance/zookeeper-"+clusterName, resourcePresent)
+		recordResource(ctx, "kudo-instance/kafka-"+clusterName, resourcePresent)
+		return nil
 	}
 
-	if err = k.createZookeeperCluster(clusterName); err == nil {
-		log.Printf("Zookeeper cluster %q created ...", clusterName)
+	if created, err := k.isKudoInstanceCreated(ctx, "zookeeper-"+clusterName); err == nil && created {
+		logger.Infof(ctx, "Zookeeper cluster %q already exists ...", clusterName)
+		if err = k.waitZookeeperRunning(ctx, clusterName); err != nil {
+			return fmt.Errorf("error checking zookeeper cluster %q: %v", clusterName, err)
+		}
+		recordResource(ctx, "kudo-instance/zookeeper-"+clusterName, resourcePresent)
+	} else if err = k.createZookeeperCluster(ctx, clusterName); err == nil {
+		logger.Infof(ctx, "Zookeeper cluster %q created ...", clusterName)
+		recordResource(ctx, "kudo-instance/zookeeper-"+clusterName, resourceCreated)
 	} else {
 		return fmt.Errorf("error creating zookeeper cluster %q: %v", clusterName, err)
 	}
 
-	if err = k.createKafkaCluster(clusterName); err == nil {
-		log.Printf("Kafka cluster %q created ...", clusterName)
+	if err = k.createKafkaCluster(ctx, clusterName); err == nil {
+		logger.Infof(ctx, "Kafka cluster %q created ...", clusterName)
+		recordResource(ctx, "kudo-instance/kafka-"+clusterName, resourceCreated)
 	} else {
 		return fmt.Errorf("error creating kafka cluster %q: %v", clusterName, err)
 	}
@@ -1,7 +1,9 @@
 package k8ssetup
 
 import (
+	"context"
 	"errors"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -61,13 +63,13 @@ func Test_isKafkaClusterCreated(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
 	t.Run("must return true if kafka and zookeper clusters are created", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return KudoInstancesFound, nil
 		}
 
 		expect := true
 		var expectErr error = nil
-		got, gotErr := k8sImpl.isKafkaClusterCreated("pets")
+		got, gotErr := k8sImpl.isKafkaClusterCreated(context.Background(), "pets")
 
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
@@ -78,13 +80,13 @@ func Test_isKafkaClusterCreated(t *testing.T) {
 	})
 
 	t.Run("must return false if kafka cluster is not created", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return KudoKafkaInstanceNotFound, nil
 		}
 
 		expect := false
 		expectErr := "Error not found kafka and zookeeper"
-		got, gotErr := k8sImpl.isKafkaClusterCreated("pets")
+		got, gotErr := k8sImpl.isKafkaClusterCreated(context.Background(), "pets")
 		if !strings.Contains(gotErr.Error(), expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
@@ -94,13 +96,13 @@ func Test_isKafkaClusterCreated(t *testing.T) {
 	})
 
 	t.Run("must return false if zookeeper cluster is not created", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return KudoZookeeperInstanceNotFound, nil
 		}
 
 		expect := false
 		expectErr := "Error not found kafka and zookeeper"
-		got, gotErr := k8sImpl.isKafkaClusterCreated("pets")
+		got, gotErr := k8sImpl.isKafkaClusterCreated(context.Background(), "pets")
 		if !strings.Contains(gotErr.Error(), expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
@@ -110,13 +112,13 @@ func Test_isKafkaClusterCreated(t *testing.T) {
 	})
 
 	t.Run("must return false if zookeeper nor kafka clusters are created", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "[]", nil
 		}
 
 		expect := false
 		expectErr := "Error not found kafka and zookeeper"
-		got, gotErr := k8sImpl.isKafkaClusterCreated("pets")
+		got, gotErr := k8sImpl.isKafkaClusterCreated(context.Background(), "pets")
 		if !strings.Contains(gotErr.Error(), expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
@@ -126,13 +128,13 @@ func Test_isKafkaClusterCreated(t *testing.T) {
 	})
 
 	t.Run("must return false if returned json is invalid", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "error", nil
 		}
 
 		expect := false
 		expectErr := "Error not found kafka and zookeeper, invalid json"
-		got, gotErr := k8sImpl.isKafkaClusterCreated("pets")
+		got, gotErr := k8sImpl.isKafkaClusterCreated(context.Background(), "pets")
 		if !strings.Contains(gotErr.Error(), expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
@@ -143,7 +145,7 @@ func Test_isKafkaClusterCreated(t *testing.T) {
 
 	t.Run("must return false if getting instances fails", func(t *testing.T) {
 		invalidErr := errors.New("invalid")
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "kudo" && params[1] == "get" && params[2] == "instances" {
 				return "", invalidErr
 			}
@@ -152,7 +154,7 @@ func Test_isKafkaClusterCreated(t *testing.T) {
 
 		expect := false
 		expectErr := "error getting kudo instances"
-		got, gotErr := k8sImpl.isKafkaClusterCreated("pets")
+		got, gotErr := k8sImpl.isKafkaClusterCreated(context.Background(), "pets")
 		if !strings.Contains(gotErr.Error(), expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
@@ -172,22 +174,22 @@ func Test_createZookeeperCluster(t *testing.T) {
 	}
 	var pods = []podStatus{
 		{
-			name:  "pod/zookeeper-pets-0",
+			name:  "pod/zookeeper-pets-zookeeper-0",
 			ready: true,
 		},
 		{
-			name:  "pod/zookeeper-pets-1",
+			name:  "pod/zookeeper-pets-zookeeper-1",
 			ready: true,
 		},
 		{
-			name:  "pod/zookeeper-pets-2",
+			name:  "pod/zookeeper-pets-zookeeper-2",
 			ready: true,
 		},
 	}
 
 	t.Run("must return no error if zookeeper cluster is created", func(t *testing.T) {
 
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "kudo" {
 				return "", nil
 			}
@@ -206,25 +208,92 @@ func Test_createZookeeperCluster(t *testing.T) {
 		}
 
 		var expectErr error = nil
-		gotErr := k8sImpl.createZookeeperCluster("pets")
+		gotErr := k8sImpl.createZookeeperCluster(context.Background(), "pets")
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
 	})
 
 	t.Run("must return error if zookeeper installation fails", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "error", invalidErr
 		}
 
 		expectErr := "Error creating zookeeper cluster"
-		gotErr := k8sImpl.createZookeeperCluster("pets")
+		gotErr := k8sImpl.createZookeeperCluster(context.Background(), "pets")
 		if !strings.Contains(gotErr.Error(), expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
 	})
 }
 
+func Test_kafkaTopology(t *testing.T) {
+	t.Run("must derive the zookeeper uri from the nodes", func(t *testing.T) {
+		kafka := KafkaSpec{Name: "orders", Zookeeper: ZookeeperSpec{Nodes: 2}}
+		expect := "zookeeper-orders-zookeeper-0.zookeeper-orders-hs:2181,zookeeper-orders-zookeeper-1.zookeeper-orders-hs:2181"
+		if got := zookeeperURI(kafka); got != expect {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must install a single node zookeeper and five brokers", func(t *testing.T) {
+		k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+		k8sImpl.spec.Kafka = []KafkaSpec{{
+			Name:        "orders",
+			Brokers:     5,
+			StorageSize: "20Gi",
+			Parameters:  map[string]string{"MIN_INSYNC_REPLICAS": "2"},
+			Zookeeper:   ZookeeperSpec{Nodes: 1},
+		}}
+		var installed [][]string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "kudo" {
+				installed = append(installed, params)
+				return "", nil
+			}
+			if params[0] == "get" && params[1] == "pod" {
+				return "pod/zookeeper-orders-zookeeper-0\npod/kafka-orders-kafka-0\npod/kafka-orders-kafka-1\n" +
+					"pod/kafka-orders-kafka-2\npod/kafka-orders-kafka-3\npod/kafka-orders-kafka-4\n", nil
+			}
+			return "'true'", nil
+		}
+
+		if gotErr := k8sImpl.createZookeeperCluster(context.Background(), "orders"); gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if gotErr := k8sImpl.createKafkaCluster(context.Background(), "orders"); gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		expect := [][]string{
+			{"kudo", "install", "zookeeper", "--instance", "zookeeper-orders", "--namespace", "default", "-p", "NODE_COUNT=1"},
+			{"kudo", "install", "kafka", "--instance", "kafka-orders", "--namespace", "default", "-p", "BROKER_COUNT=5", "-p", "DISK_SIZE=20Gi",
+				"-p", "MIN_INSYNC_REPLICAS=2", "-p", "ZOOKEEPER_URI=zookeeper-orders-zookeeper-0.zookeeper-orders-hs:2181"},
+		}
+		if !reflect.DeepEqual(installed, expect) {
+			t.Fatalf("Got %v, expect %v", installed, expect)
+		}
+	})
+
+	t.Run("must wait for every broker of the cluster", func(t *testing.T) {
+		k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+		k8sImpl.spec.Kafka = []KafkaSpec{{Name: "orders", Brokers: 5, Zookeeper: ZookeeperSpec{Nodes: 1}}}
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "get" && params[1] == "pod" {
+				return "pod/kafka-orders-kafka-0\npod/kafka-orders-kafka-1\npod/kafka-orders-kafka-2\n", nil
+			}
+			return "'true'", nil
+		}
+
+		got, gotErr := k8sImpl.arePodsRunning(context.Background(), kafkaPodPrefix("orders"), k8sImpl.spec.kafkaCluster("orders").Brokers)
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got {
+			t.Fatalf("Got %v, expect %v", got, false)
+		}
+	})
+}
+
 func Test_waitZookeeperRunning(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
 
@@ -235,20 +304,20 @@ func Test_waitZookeeperRunning(t *testing.T) {
 
 	var pods = []podStatus{
 		{
-			name:  "pod/zookeeper-pets-0",
+			name:  "pod/zookeeper-pets-zookeeper-0",
 			ready: false,
 		},
 		{
-			name:  "pod/zookeeper-pets-1",
+			name:  "pod/zookeeper-pets-zookeeper-1",
 			ready: false,
 		},
 		{
-			name:  "pod/zookeeper-pets-2",
+			name:  "pod/zookeeper-pets-zookeeper-2",
 			ready: false,
 		},
 	}
 
-	k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 		if params[0] == "get" {
 			if params[1] == "pod" {
 				var result = ""
@@ -274,7 +343,7 @@ func Test_waitZookeeperRunning(t *testing.T) {
 		return "", nil
 	}
 
-	k8sImpl.waitZookeeperRunning("pets")
+	k8sImpl.waitZookeeperRunning(context.Background(), "pets")
 	for _, v := range pods {
 		if !v.ready {
 			t.Fatalf("Got %v, expect %v", false, true)
@@ -292,21 +361,21 @@ func Test_createKafkaCluster(t *testing.T) {
 	}
 	var pods = []podStatus{
 		{
-			name:  "pod/kafka-pets-0",
+			name:  "pod/kafka-pets-kafka-0",
 			ready: true,
 		},
 		{
-			name:  "pod/kafka-pets-1",
+			name:  "pod/kafka-pets-kafka-1",
 			ready: true,
 		},
 		{
-			name:  "pod/kafka-pets-2",
+			name:  "pod/kafka-pets-kafka-2",
 			ready: true,
 		},
 	}
 
 	t.Run("must return no error if kafka cluster is created", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "kudo" {
 				return "", nil
 			}
@@ -325,19 +394,19 @@ func Test_createKafkaCluster(t *testing.T) {
 		}
 
 		var expectErr error = nil
-		gotErr := k8sImpl.createKafkaCluster("pets")
+		gotErr := k8sImpl.createKafkaCluster(context.Background(), "pets")
 		if gotErr != expectErr {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
 	})
 
 	t.Run("must return error if kafka installation fails", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			return "error", invalidErr
 		}
 
 		expectErr := "Error creating kafka cluster"
-		gotErr := k8sImpl.createKafkaCluster("pets")
+		gotErr := k8sImpl.createKafkaCluster(context.Background(), "pets")
 		if !strings.Contains(gotErr.Error(), expectErr) {
 			t.Fatalf("Got error %v, expect error %v", gotErr, expectErr)
 		}
@@ -354,20 +423,20 @@ func Test_waitKafkaClusterCreation(t *testing.T) {
 
 	var pods = []podStatus{
 		{
-			name:  "pod/kafka-pets-0",
+			name:  "pod/kafka-pets-kafka-0",
 			ready: false,
 		},
 		{
-			name:  "pod/kafka-pets-1",
+			name:  "pod/kafka-pets-kafka-1",
 			ready: false,
 		},
 		{
-			name:  "pod/kafka-pets-2",
+			name:  "pod/kafka-pets-kafka-2",
 			ready: false,
 		},
 	}
 
-	k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 		if params[0] == "get" {
 			if params[1] == "pod" {
 				var result = ""
@@ -393,7 +462,7 @@ func Test_waitKafkaClusterCreation(t *testing.T) {
 		return "", nil
 	}
 
-	k8sImpl.waitKafkaClusterCreation("pets")
+	k8sImpl.waitKafkaClusterCreation(context.Background(), "pets")
 	for _, v := range pods {
 		if !v.ready {
 			t.Fatalf("Got %v, expect %v", false, true)
@@ -403,7 +472,7 @@ func Test_waitKafkaClusterCreation(t *testing.T) {
 
 func Test_KafkaClusterCreation(t *testing.T) {
 	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
-	invalidErr := errors.New("invalid") 
+	invalidErr := errors.New("invalid")
 	type podStatus struct {
 		name  string
 		ready bool
@@ -411,33 +480,33 @@ func Test_KafkaClusterCreation(t *testing.T) {
 
 	var pods = []podStatus{
 		{
-			name:  "pod/zookeeper-pets-0",
+			name:  "pod/zookeeper-pets-zookeeper-0",
 			ready: false,
 		},
 		{
-			name:  "pod/zookeeper-pets-1",
+			name:  "pod/zookeeper-pets-zookeeper-1",
 			ready: false,
 		},
 		{
-			name:  "pod/zookeeper-pets-2",
+			name:  "pod/zookeeper-pets-zookeeper-2",
 			ready: false,
 		},
 		{
-			name:  "pod/kafka-pets-0",
+			name:  "pod/kafka-pets-kafka-0",
 			ready: false,
 		},
 		{
-			name:  "pod/kafka-pets-1",
+			name:  "pod/kafka-pets-kafka-1",
 			ready: false,
 		},
 		{
-			name:  "pod/kafka-pets-2",
+			name:  "pod/kafka-pets-kafka-2",
 			ready: false,
 		},
 	}
 
 	t.Run("we should create the kafka cluster", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "kudo" && params[2] == "instances" && params[3] == "-o" && params[4] == "json" {
 				return KudoKafkaInstanceNotFound, nil
 			}
@@ -459,19 +528,19 @@ func Test_KafkaClusterCreation(t *testing.T) {
 		}
 
 		var expect error = nil
-		got := k8sImpl.KafkaClusterCreation("pets")
+		got := k8sImpl.KafkaClusterCreation(context.Background(), "pets")
 		if got != expect {
 			t.Fatalf("Got error %v, expect  error %v", got, expect)
 		}
 	})
 
-	t.Run("we should return an error when kafka and zookeeper already exist", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+	t.Run("we should only check kafka and zookeeper are running when they already exist", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "kudo" && params[2] == "instances" && params[3] == "-o" && params[4] == "json" {
 				return KudoInstancesFound, nil
 			}
 			if params[0] == "kudo" && params[1] == "install" {
-				return "", nil
+				t.Fatalf("Unexpected install %v", params)
 			}
 
 			if params[0] == "get" {
@@ -487,15 +556,48 @@ func Test_KafkaClusterCreation(t *testing.T) {
 			return "", nil
 		}
 
-		expect := "already exists"
-		got := k8sImpl.KafkaClusterCreation("pets")
-		if !strings.Contains(got.Error(), expect) {
+		var expect error = nil
+		got := k8sImpl.KafkaClusterCreation(context.Background(), "pets")
+		if got != expect {
 			t.Fatalf("Got error %v, expect  error %v", got, expect)
 		}
 	})
 
+	t.Run("we should only create kafka when zookeeper already exists", func(t *testing.T) {
+		var installed []string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "kudo" && params[2] == "instances" && params[3] == "-o" && params[4] == "json" {
+				return strings.Replace(KudoKafkaInstanceNotFound, "zookeeper-instance", "zookeeper-pets", 1), nil
+			}
+			if params[0] == "kudo" && params[1] == "install" {
+				installed = append(installed, params[2])
+				return "", nil
+			}
+
+			if params[0] == "get" {
+				if params[1] == "pod" {
+					var result = ""
+					for _, v := range pods {
+						result = result + v.name + "\n"
+					}
+					return result, nil
+				}
+				return "'true'", nil
+			}
+			return "", nil
+		}
+
+		got := k8sImpl.KafkaClusterCreation(context.Background(), "pets")
+		if got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		if expect := []string{"kafka"}; !reflect.DeepEqual(installed, expect) {
+			t.Fatalf("Got installed %v, expect %v", installed, expect)
+		}
+	})
+
 	t.Run("we should return an error when creating zookeeper cluster", func(t *testing.T) {
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "kudo" && params[2] == "instances" && params[3] == "-o" && params[4] == "json" {
 				return KudoKafkaInstanceNotFound, nil
 			}
@@ -507,7 +609,7 @@ func Test_KafkaClusterCreation(t *testing.T) {
 		}
 
 		expect := "error creating zookeeper cluster"
-		got := k8sImpl.KafkaClusterCreation("pets")
+		got := k8sImpl.KafkaClusterCreation(context.Background(), "pets")
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect  error %v", got, expect)
 		}
@@ -515,7 +617,7 @@ func Test_KafkaClusterCreation(t *testing.T) {
 
 	t.Run("we should return an error when creating kafka cluster", func(t *testing.T) {
 		// TODO - pending
-		k8sImpl.executeCommand = func(cmdName string, params ...string) (string, error) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
 			if params[0] == "kudo" && params[2] == "instances" && params[3] == "-o" && params[4] == "json" {
 				return KudoKafkaInstanceNotFound, nil
 			}
@@ -541,7 +643,7 @@ func Test_KafkaClusterCreation(t *testing.T) {
 		}
 
 		expect := "error creating kafka cluster"
-		got := k8sImpl.KafkaClusterCreation("pets")
+		got := k8sImpl.KafkaClusterCreation(context.Background(), "pets")
 		if !strings.Contains(got.Error(), expect) {
 			t.Fatalf("Got error %v, expect  error %v", got, expect)
 		}
@@ -0,0 +1,266 @@
+package k8ssetup
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	kubeconfigVar      = "KUBECONFIG"
+	serviceHostVar     = "KUBERNETES_SERVICE_HOST"
+	servicePortVar     = "KUBERNETES_SERVICE_PORT"
+	serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// kubeconfig is the part of a kubeconfig file that we need to reach the API server
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Username              string `yaml:"username"`
+			Password              string `yaml:"password"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// restConfig is how we reach and authenticate against the API server
+type restConfig struct {
+	context   string
+	server    string
+	token     string
+	username  string
+	password  string
+	tlsConfig *tls.Config
+}
+
+// defaultKubeconfigPath returns the first file in KUBECONFIG or ~/.kube/config
+func defaultKubeconfigPath() string {
+	if paths := filepath.SplitList(os.Getenv(kubeconfigVar)); len(paths) != 0 && paths[0] != "" {
+		return paths[0]
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".kube", "config")
+	}
+	return ""
+}
+
+// loadRestConfig reads the kubeconfig file, when there is none and we are inside a pod the service account is used
+func loadRestConfig(path, context string) (*restConfig, error) {
+	if path == "" {
+		path = defaultKubeconfigPath()
+		if _, err := os.Stat(path); err != nil && os.Getenv(serviceHostVar) != "" {
+			return inClusterConfig()
+		}
+	}
+	return loadKubeconfig(path, context)
+}
+
+// pinContext writes a copy of the kubeconfig whose current context is name, next to it so its relative paths
+// still work, the caller removes the copy
+func pinContext(path, name string) (string, error) {
+	if path == "" {
+		path = defaultKubeconfigPath()
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading kubeconfig %q: %v", path, err)
+	}
+	config := yaml.MapSlice{}
+	if err = yaml.Unmarshal(content, &config); err != nil {
+		return "", fmt.Errorf("error parsing kubeconfig %q: %v", path, err)
+	}
+	pinned := false
+	for i, v := range config {
+		if v.Key == "current-context" {
+			config[i].Value, pinned = name, true
+		}
+	}
+	if !pinned {
+		config = append(config, yaml.MapItem{Key: "current-context", Value: name})
+	}
+	if content, err = yaml.Marshal(config); err != nil {
+		return "", fmt.Errorf("error writing kubeconfig of context %q: %v", name, err)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return "", fmt.Errorf("error writing kubeconfig of context %q: %v", name, err)
+	}
+	if _, err = file.Write(content); err == nil {
+		err = file.Close()
+	} else {
+		_ = file.Close()
+	}
+	if err != nil {
+		removeFile(file.Name())
+		return "", fmt.Errorf("error writing kubeconfig of context %q: %v", name, err)
+	}
+	return file.Name(), nil
+}
+
+func loadKubeconfig(path, context string) (*restConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading kubeconfig %q: %v", path, err)
+	}
+	config := kubeconfig{}
+	if err = yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("error parsing kubeconfig %q: %v", path, err)
+	}
+	if context == "" {
+		context = config.CurrentContext
+	}
+	dir := filepath.Dir(path)
+
+	for _, c := range config.Contexts {
+		if c.Name != context {
+			continue
+		}
+		rest := &restConfig{context: context, tlsConfig: &tls.Config{}}
+		found := false
+		for _, v := range config.Clusters {
+			if v.Name == c.Context.Cluster {
+				found = true
+				rest.server = strings.TrimSuffix(v.Cluster.Server, "/")
+				rest.tlsConfig.InsecureSkipVerify = v.Cluster.InsecureSkipTLSVerify
+				ca, err := readData(v.Cluster.CertificateAuthorityData, v.Cluster.CertificateAuthority, dir)
+				if err != nil {
+					return nil, fmt.Errorf("error reading certificate authority of cluster %q: %v", v.Name, err)
+				}
+				if ca != nil {
+					if rest.tlsConfig.RootCAs, err = certPool(ca); err != nil {
+						return nil, fmt.Errorf("error reading certificate authority of cluster %q: %v", v.Name, err)
+					}
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("cluster %q of context %q not found in kubeconfig %q", c.Context.Cluster, context, path)
+		}
+		for _, v := range config.Users {
+			if v.Name == c.Context.User {
+				if err = rest.setUser(v.User.Token, v.User.TokenFile, v.User.Username, v.User.Password, dir); err != nil {
+					return nil, fmt.Errorf("error reading user %q: %v", v.Name, err)
+				}
+				cert, err := readData(v.User.ClientCertificateData, v.User.ClientCertificate, dir)
+				if err != nil {
+					return nil, fmt.Errorf("error reading client certificate of user %q: %v", v.Name, err)
+				}
+				key, err := readData(v.User.ClientKeyData, v.User.ClientKey, dir)
+				if err != nil {
+					return nil, fmt.Errorf("error reading client key of user %q: %v", v.Name, err)
+				}
+				if cert != nil && key != nil {
+					pair, err := tls.X509KeyPair(cert, key)
+					if err != nil {
+						return nil, fmt.Errorf("invalid client certificate of user %q: %v", v.Name, err)
+					}
+					rest.tlsConfig.Certificates = []tls.Certificate{pair}
+				}
+			}
+		}
+		return rest, nil
+	}
+	if context == "" {
+		return nil, fmt.Errorf("no current context in kubeconfig %q", path)
+	}
+	return nil, fmt.Errorf("context %q not found in kubeconfig %q", context, path)
+}
+
+// inClusterConfig uses the service account that kubernetes mounts in every pod
+func inClusterConfig() (*restConfig, error) {
+	host, port := os.Getenv(serviceHostVar), os.Getenv(servicePortVar)
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster, variables %s and %s must be defined", serviceHostVar, servicePortVar)
+	}
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountPath, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account token: %v", err)
+	}
+	rest := &restConfig{
+		context:   "in-cluster",
+		server:    "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		tlsConfig: &tls.Config{},
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountPath, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account certificate authority: %v", err)
+	}
+	if rest.tlsConfig.RootCAs, err = certPool(ca); err != nil {
+		return nil, fmt.Errorf("error reading service account certificate authority: %v", err)
+	}
+	return rest, nil
+}
+
+func (r *restConfig) setUser(token, tokenFile, username, password, dir string) error {
+	r.token, r.username, r.password = token, username, password
+	if r.token == "" && tokenFile != "" {
+		content, err := ioutil.ReadFile(resolvePath(tokenFile, dir))
+		if err != nil {
+			return err
+		}
+		r.token = strings.TrimSpace(string(content))
+	}
+	return nil
+}
+
+// readData returns the base64 data or else the content of the file, relative paths are from the kubeconfig dir
+func readData(data, fileName, dir string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if fileName != "" {
+		return ioutil.ReadFile(resolvePath(fileName, dir))
+	}
+	return nil, nil
+}
+
+func resolvePath(fileName, dir string) string {
+	if filepath.IsAbs(fileName) {
+		return fileName
+	}
+	return filepath.Join(dir, fileName)
+}
+
+func certPool(pem []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no valid certificates found")
+	}
+	return pool, nil
+}
@@ -0,0 +1,187 @@
+package k8ssetup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443/
+    insecure-skip-tls-verify: true
+- name: prod
+  cluster:
+    server: https://prod.example.com
+users:
+- name: dev
+  user:
+    tokenFile: token
+- name: prod
+  user:
+    username: admin
+    password: secret
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev
+- name: prod
+  context:
+    cluster: prod
+    user: prod
+- name: broken
+  context:
+    cluster: missing
+    user: dev
+`
+
+func writeTestKubeconfig(t *testing.T) string {
+	dir := tempDir(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("dev-token\n"), 0600); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	fileName := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(fileName, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	return fileName
+}
+
+func Test_loadKubeconfig(t *testing.T) {
+	fileName := writeTestKubeconfig(t)
+
+	t.Run("must use the current context", func(t *testing.T) {
+		got, gotErr := loadKubeconfig(fileName, "")
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got.context != "dev" || got.server != "https://dev.example.com:6443" || got.token != "dev-token" {
+			t.Fatalf("Got %+v, expect dev context with token from file", got)
+		}
+		if !got.tlsConfig.InsecureSkipVerify {
+			t.Fatalf("Got %v, expect %v", got.tlsConfig.InsecureSkipVerify, true)
+		}
+	})
+
+	t.Run("must use the given context", func(t *testing.T) {
+		got, gotErr := loadKubeconfig(fileName, "prod")
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got.server != "https://prod.example.com" || got.username != "admin" || got.password != "secret" {
+			t.Fatalf("Got %+v, expect prod context with basic auth", got)
+		}
+	})
+
+	t.Run("must return error when context does not exist", func(t *testing.T) {
+		expect := "context \"staging\" not found"
+		_, got := loadKubeconfig(fileName, "staging")
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must return error when cluster does not exist", func(t *testing.T) {
+		expect := "cluster \"missing\" of context \"broken\" not found"
+		_, got := loadKubeconfig(fileName, "broken")
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must return error when file does not exist", func(t *testing.T) {
+		expect := "error reading kubeconfig"
+		_, got := loadKubeconfig(filepath.Join(tempDir(t), "config"), "")
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
+
+func Test_pinContext(t *testing.T) {
+	t.Run("must pin the context in a copy of the kubeconfig", func(t *testing.T) {
+		fileName := writeTestKubeconfig(t)
+		got, err := pinContext(fileName, "prod")
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		defer removeFile(got)
+		if filepath.Dir(got) != filepath.Dir(fileName) {
+			t.Fatalf("Got %s, expect a copy next to %s", got, fileName)
+		}
+		config, err := loadKubeconfig(got, "")
+		if err != nil || config.context != "prod" || config.server != "https://prod.example.com" {
+			t.Fatalf("Got %+v and error %v, expect the prod context", config, err)
+		}
+	})
+
+	t.Run("must add the current context when the kubeconfig has none", func(t *testing.T) {
+		fileName := filepath.Join(tempDir(t), "config")
+		content := strings.Replace(testKubeconfig, "current-context: dev\n", "", 1)
+		if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		got, err := pinContext(fileName, "prod")
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		defer removeFile(got)
+		if config, err := loadKubeconfig(got, ""); err != nil || config.context != "prod" {
+			t.Fatalf("Got %+v and error %v, expect the prod context", config, err)
+		}
+	})
+
+	t.Run("must return error when the kubeconfig does not exist", func(t *testing.T) {
+		if _, got := pinContext(filepath.Join(tempDir(t), "missing"), "prod"); got == nil {
+			t.Fatalf("Got nil, expect error")
+		}
+	})
+}
+
+func Test_inClusterConfig(t *testing.T) {
+	oldServiceAccountPath, oldHostVar, oldPortVar := serviceAccountPath, serviceHostVar, servicePortVar
+	defer func() {
+		serviceAccountPath, serviceHostVar, servicePortVar = oldServiceAccountPath, oldHostVar, oldPortVar
+	}()
+	serviceHostVar, servicePortVar = "TEST_SERVICE_HOST", "TEST_SERVICE_PORT"
+
+	t.Run("must return error outside a cluster", func(t *testing.T) {
+		_ = os.Unsetenv(serviceHostVar)
+		expect := "not running in a cluster"
+		_, got := inClusterConfig()
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must use the service account", func(t *testing.T) {
+		fake := newFakeAPIServer(t)
+		serviceAccountPath = tempDir(t)
+		ca := fake.server.Certificate().Raw
+		if err := ioutil.WriteFile(filepath.Join(serviceAccountPath, "token"), []byte(fakeToken), 0600); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(serviceAccountPath, "ca.crt"), pemCertificate(ca), 0600); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		_ = os.Setenv(serviceHostVar, "10.0.0.1")
+		_ = os.Setenv(servicePortVar, "443")
+		defer os.Unsetenv(serviceHostVar)
+		defer os.Unsetenv(servicePortVar)
+
+		got, gotErr := inClusterConfig()
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got.server != "https://10.0.0.1:443" || got.token != fakeToken || got.tlsConfig.RootCAs == nil {
+			t.Fatalf("Got %+v, expect service account config", got)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log event
+type Level int
+
+const (
+	// DebugLevel events are the output of every command
+	DebugLevel Level = iota
+	// InfoLevel events are the progress of the steps
+	InfoLevel
+	// WarnLevel events are problems that do not stop a step
+	WarnLevel
+	// ErrorLevel events are the errors that stop a run
+	ErrorLevel
+)
+
+const (
+	// ConsoleFormat writes an event per line for humans
+	ConsoleFormat = "console"
+	// JSONFormat writes an event per line as a JSON object
+	JSONFormat = "json"
+
+	// StepField is the step of the run that logs an event
+	StepField = "step"
+	// ComponentField is the database or kafka cluster of the step
+	ComponentField = "component"
+	commandField   = "command"
+	durationField  = "duration"
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of a name like debug or info
+func ParseLevel(name string) (Level, error) {
+	for i, v := range levelNames {
+		if strings.EqualFold(name, v) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unsupported log level %q, expect %s", name, strings.Join(levelNames, ", "))
+}
+
+// Logger writes leveled events, the fields of the context like the step and the component go with every event
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+	now    func() time.Time
+}
+
+type logFieldsKey struct{}
+
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// logger is where the package writes its events
+var logger, _ = NewLogger(os.Stderr, InfoLevel, ConsoleFormat)
+
+// NewLogger returns a logger that writes the events from level in the console or the json format
+func NewLogger(out io.Writer, level Level, format string) (*Logger, error) {
+	if format != ConsoleFormat && format != JSONFormat {
+		return nil, fmt.Errorf("unsupported log format %q, expect %q or %q", format, ConsoleFormat, JSONFormat)
+	}
+	return &Logger{out: out, level: level, format: format, now: time.Now}, nil
+}
+
+// SetLogger changes the logger of the package
+func SetLogger(l *Logger) {
+	logger = l
+}
+
+// WithLogField returns a context whose events have the field, like the step or the component
+func WithLogField(ctx context.Context, key string, value interface{}) context.Context {
+	fields, _ := ctx.Value(logFieldsKey{}).([]logField)
+	fields = append(fields[:len(fields):len(fields)], logField{key: key, value: value})
+	return context.WithValue(ctx, logFieldsKey{}, fields)
+}
+
+// Enabled returns true when the events of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Debugf writes a debug event
+func (l *Logger) Debugf(ctx context.Context, format string, args ...interface{}) {
+	l.logf(ctx, DebugLevel, format, args...)
+}
+
+// Infof writes an info event
+func (l *Logger) Infof(ctx context.Context, format string, args ...interface{}) {
+	l.logf(ctx, InfoLevel, format, args...)
+}
+
+// Warnf writes a warn event
+func (l *Logger) Warnf(ctx context.Context, format string, args ...interface{}) {
+	l.logf(ctx, WarnLevel, format, args...)
+}
+
+// Errorf writes an error event
+func (l *Logger) Errorf(ctx context.Context, format string, args ...interface{}) {
+	l.logf(ctx, ErrorLevel, format, args...)
+}
+
+// progress is where long operations like a clone write their progress, only for humans at debug level
+func (l *Logger) progress() io.Writer {
+	if l.format != ConsoleFormat || !l.Enabled(DebugLevel) {
+		return nil
+	}
+	return l.out
+}
+
+func (l *Logger) logf(ctx context.Context, level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	var fields []logField
+	if ctx != nil {
+		fields, _ = ctx.Value(logFieldsKey{}).([]logField)
+	}
+	message := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+
+	var line bytes.Buffer
+	if l.format == JSONFormat {
+		l.writeJSON(&line, level, message, fields)
+	} else {
+		l.writeConsole(&line, level, message, fields)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(line.Bytes())
+}
+
+// writeConsole writes time, level, message and the fields as key=value
+func (l *Logger) writeConsole(line *bytes.Buffer, level Level, message string, fields []logField) {
+	fmt.Fprintf(line, "%s %-5s %s", l.now().Format("15:04:05"), strings.ToUpper(level.String()), message)
+	for _, v := range fields {
+		value := fmt.Sprint(v.value)
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(line, " %s=%s", v.key, value)
+	}
+	line.WriteByte('\n')
+}
+
+// writeJSON writes time, level and msg first and then the fields sorted, a field set twice keeps its last value
+func (l *Logger) writeJSON(line *bytes.Buffer, level Level, message string, fields []logField) {
+	values := map[string]interface{}{}
+	for _, v := range fields {
+		if d, ok := v.value.(time.Duration); ok {
+			values[v.key] = d.String()
+		} else {
+			values[v.key] = v.value
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(line, `{"time":%q,"level":%q,"msg":%s`, l.now().UTC().Format(time.RFC3339Nano), level, jsonValue(message))
+	for _, key := range keys {
+		fmt.Fprintf(line, ",%s:%s", jsonValue(key), jsonValue(values[key]))
+	}
+	line.WriteString("}\n")
+}
+
+func jsonValue(value interface{}) []byte {
+	content, err := json.Marshal(value)
+	if err != nil {
+		content, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return content
+}
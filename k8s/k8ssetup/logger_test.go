@@ -0,0 +1,143 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger(t *testing.T, level Level, format string) (*Logger, *bytes.Buffer) {
+	var out bytes.Buffer
+	l, err := NewLogger(&out, level, format)
+	if err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	l.now = func() time.Time { return time.Date(2020, 5, 1, 10, 30, 0, 0, time.UTC) }
+	return l, &out
+}
+
+// useTestLogger makes the package log in a buffer until the test ends
+func useTestLogger(t *testing.T, level Level, format string) *bytes.Buffer {
+	previous := logger
+	l, out := newTestLogger(t, level, format)
+	SetLogger(l)
+	t.Cleanup(func() { SetLogger(previous) })
+	return out
+}
+
+func Test_Logger(t *testing.T) {
+	ctx := WithLogField(WithLogField(context.Background(), StepField, "database"), ComponentField, "pets db.yml")
+
+	t.Run("must write the console format with the fields", func(t *testing.T) {
+		l, out := newTestLogger(t, InfoLevel, ConsoleFormat)
+		l.Infof(ctx, "Creating database %q ...", "pets")
+
+		expect := "10:30:00 INFO  Creating database \"pets\" ... step=database component=\"pets db.yml\"\n"
+		if got := out.String(); got != expect {
+			t.Fatalf("Got %q, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must write the json format with the fields", func(t *testing.T) {
+		l, out := newTestLogger(t, DebugLevel, JSONFormat)
+		l.Debugf(WithLogField(WithLogField(ctx, commandField, "kubectl get pod"), durationField, 1500*time.Millisecond), "pod/a\n")
+
+		expect := `{"time":"2020-05-01T10:30:00Z","level":"debug","msg":"pod/a","command":"kubectl get pod","component":"pets db.yml","duration":"1.5s","step":"database"}` + "\n"
+		if got := out.String(); got != expect {
+			t.Fatalf("Got %q, expect %q", got, expect)
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal(out.Bytes(), &event); err != nil {
+			t.Fatalf("Got error %v, expect a json line", err)
+		}
+	})
+
+	t.Run("must not write the events under the level", func(t *testing.T) {
+		l, out := newTestLogger(t, WarnLevel, ConsoleFormat)
+		l.Debugf(ctx, "debug")
+		l.Infof(ctx, "info")
+		l.Warnf(ctx, "warn")
+		l.Errorf(ctx, "error")
+
+		if got := out.String(); strings.Contains(got, "debug") || strings.Contains(got, "info") ||
+			!strings.Contains(got, "WARN  warn") || !strings.Contains(got, "ERROR error") {
+			t.Fatalf("Got %q, expect only warn and error", got)
+		}
+	})
+
+	t.Run("must not share the fields of a parent context", func(t *testing.T) {
+		parent := WithLogField(context.Background(), StepField, "kafka-cluster")
+		first := WithLogField(parent, ComponentField, "pets")
+		second := WithLogField(parent, ComponentField, "orders")
+		l, out := newTestLogger(t, InfoLevel, ConsoleFormat)
+		l.Infof(first, "first")
+		l.Infof(second, "second")
+
+		if got := out.String(); !strings.Contains(got, "first step=kafka-cluster component=pets\n") ||
+			!strings.Contains(got, "second step=kafka-cluster component=orders\n") {
+			t.Fatalf("Got %q, expect every event with its component", got)
+		}
+	})
+
+	t.Run("must return error on an unknown format", func(t *testing.T) {
+		expect := "unsupported log format \"xml\", expect \"console\" or \"json\""
+		if _, got := NewLogger(&bytes.Buffer{}, InfoLevel, "xml"); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
+
+func Test_ParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "INFO", "warn", "error"} {
+		t.Run(name, func(t *testing.T) {
+			got, gotErr := ParseLevel(name)
+			if gotErr != nil {
+				t.Fatalf("Got error %v, expect nil", gotErr)
+			}
+			if got.String() != strings.ToLower(name) {
+				t.Fatalf("Got %v, expect %v", got, name)
+			}
+		})
+	}
+
+	t.Run("must return error on an unknown level", func(t *testing.T) {
+		expect := "unsupported log level \"verbose\", expect debug, info, warn, error"
+		if _, got := ParseLevel("verbose"); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
+
+func Test_executeCommandLogs(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	ctx := WithLogField(context.Background(), StepField, "initialize")
+
+	t.Run("must log the output of the command at debug level", func(t *testing.T) {
+		out := useTestLogger(t, DebugLevel, JSONFormat)
+		if _, err := k8sImpl.executeCommand(ctx, getFilePath(okCommand), "param-1"); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+
+		var event map[string]interface{}
+		if err := json.Unmarshal(out.Bytes(), &event); err != nil {
+			t.Fatalf("Got error %v, expect a json line in %q", err, out.String())
+		}
+		if event["level"] != "debug" || event["msg"] != "ok params: param-1" || event["step"] != "initialize" ||
+			event["command"] != okCommand+" param-1" || event["duration"] == nil {
+			t.Fatalf("Got %v, expect the command event", event)
+		}
+	})
+
+	t.Run("must not log the output of the command at info level", func(t *testing.T) {
+		out := useTestLogger(t, InfoLevel, ConsoleFormat)
+		if _, err := k8sImpl.executeCommand(ctx, getFilePath(okCommand), "param-1"); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if got := out.String(); got != "" {
+			t.Fatalf("Got %q, expect nothing logged", got)
+		}
+	})
+}
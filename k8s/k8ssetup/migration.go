@@ -0,0 +1,119 @@
+package k8ssetup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const (
+	// schemaVersionLabel is the label of a database job with the schema version of its migrations
+	schemaVersionLabel = "schema-version"
+	// clusterNameLabel is the label of a database job with its database cluster
+	clusterNameLabel = "cluster-name"
+	// schemaVersionOutput is the output of the database step with the schema version of its cluster
+	schemaVersionOutput = "schemaVersion"
+)
+
+// migrationRegex is the name of a migration, its version then its description like 0002_add_pets_weight.sql
+var migrationRegex = regexp.MustCompile(`^([0-9]+)_([a-z0-9_]+)\.sql$`)
+
+// migration is a versioned SQL file of a migrations dir
+type migration struct {
+	version int
+	name    string
+}
+
+// loadMigrations returns the migrations of the dir in the order of their version, none without dir, the files that
+// are not SQL files are ignored
+func loadMigrations(dir string) ([]migration, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations dir %q: %v", dir, err)
+	}
+
+	var migrations []migration
+	names := map[int]string{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".sql" {
+			continue
+		}
+		match := migrationRegex.FindStringSubmatch(file.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %q is not named <version>_<description>.sql", file.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("migration %q has an invalid version %q", file.Name(), match[1])
+		}
+		if other, ok := names[version]; ok {
+			return nil, fmt.Errorf("migrations %q and %q have the same version %d", other, file.Name(), version)
+		}
+		names[version] = file.Name()
+		migrations = append(migrations, migration{version: version, name: file.Name()})
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migration in dir %q", dir)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// schemaVersion returns the version of the last migration, zero without migrations
+func schemaVersion(migrations []migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+// pendingMigrations returns the migrations after the applied version
+func pendingMigrations(migrations []migration, applied int) (pending []migration) {
+	for _, v := range migrations {
+		if v.version > applied {
+			pending = append(pending, v)
+		}
+	}
+	return
+}
+
+// databaseJobsSelector selects the jobs of a database cluster, their manifest labels them with its name
+func databaseJobsSelector(cluster string) string {
+	return jobGroupSelector + "," + clusterNameLabel + "=" + cluster
+}
+
+// appliedSchemaVersion returns the highest schema version of the jobs of the database cluster that succeeded,
+// completed is false when none succeeded, a job without schema version label applied none
+func (k k8sSetUpImpl) appliedSchemaVersion(ctx context.Context, cluster string) (version int, completed bool, err error) {
+	jobs, err := k.cluster.listResources(ctx, "job", databaseJobsSelector(cluster), k.namespace())
+	if err != nil {
+		return 0, false, err
+	}
+	for _, job := range jobs {
+		succeeded, err := k.cluster.getField(ctx, job, k.namespace(), ".status.succeeded")
+		if err != nil {
+			return 0, false, err
+		}
+		if succeeded == "" || succeeded == "0" {
+			continue
+		}
+		completed = true
+		label, err := k.cluster.getField(ctx, job, k.namespace(), ".metadata.labels."+schemaVersionLabel)
+		if err != nil {
+			return 0, false, err
+		}
+		if applied, err := strconv.Atoi(label); err == nil && applied > version {
+			version = applied
+		}
+	}
+	return version, completed, nil
+}
@@ -0,0 +1,159 @@
+package k8ssetup
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeMigrations writes empty migrations in a temporary dir and returns it
+func writeMigrations(t *testing.T, names ...string) string {
+	dir := tempDir(t)
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;\n"), 0644); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+	}
+	return dir
+}
+
+func Test_loadMigrations(t *testing.T) {
+	type TestCase struct {
+		name   string
+		files  []string
+		expect []migration
+		err    string
+	}
+
+	cases := []TestCase{
+		{
+			name:  "must load the migrations in the order of their version",
+			files: []string{"10_add_pets_owner.sql", "0002_add_pets_weight.sql", "0001_create_pets_schema.sql", "README.md"},
+			expect: []migration{
+				{version: 1, name: "0001_create_pets_schema.sql"},
+				{version: 2, name: "0002_add_pets_weight.sql"},
+				{version: 10, name: "10_add_pets_owner.sql"},
+			},
+		},
+		{
+			name:  "must return an error on a migration without version",
+			files: []string{"create_pets_schema.sql"},
+			err:   `migration "create_pets_schema.sql" is not named <version>_<description>.sql`,
+		},
+		{
+			name:  "must return an error on the version zero",
+			files: []string{"0000_create_pets_schema.sql"},
+			err:   `migration "0000_create_pets_schema.sql" has an invalid version "0000"`,
+		},
+		{
+			name:  "must return an error on a duplicated version",
+			files: []string{"0002_add_pets_weight.sql", "2_add_pets_owner.sql"},
+			err:   `migrations "0002_add_pets_weight.sql" and "2_add_pets_owner.sql" have the same version 2`,
+		},
+		{
+			name:  "must return an error without migrations",
+			files: []string{"README.md"},
+			err:   "no migration in dir",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadMigrations(writeMigrations(t, tt.files...))
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("Got error %v, expect error %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error %v, expect nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Fatalf("Got %v, expect %v", got, tt.expect)
+			}
+		})
+	}
+
+	t.Run("must load nothing without dir", func(t *testing.T) {
+		if got, err := loadMigrations(""); got != nil || err != nil {
+			t.Fatalf("Got %v and error %v, expect nothing", got, err)
+		}
+	})
+
+	t.Run("must return an error when the dir does not exist", func(t *testing.T) {
+		if _, err := loadMigrations(filepath.Join(tempDir(t), "missing")); err == nil || !strings.HasPrefix(err.Error(), "error reading migrations dir") {
+			t.Fatalf("Got error %v, expect error reading migrations dir", err)
+		}
+	})
+}
+
+func Test_pendingMigrations(t *testing.T) {
+	migrations := []migration{{version: 1, name: "0001_a.sql"}, {version: 2, name: "0002_b.sql"}, {version: 5, name: "0005_c.sql"}}
+
+	if got, expect := schemaVersion(migrations), 5; got != expect {
+		t.Fatalf("Got %v, expect %v", got, expect)
+	}
+	if got, expect := pendingMigrations(migrations, 1), migrations[1:]; !reflect.DeepEqual(got, expect) {
+		t.Fatalf("Got %v, expect %v", got, expect)
+	}
+	if got := pendingMigrations(migrations, 5); got != nil {
+		t.Fatalf("Got %v, expect nil", got)
+	}
+}
+
+func Test_appliedSchemaVersion(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	// the succeeded pods, the schema version and the cluster labels of the jobs
+	jobs := map[string][3]string{
+		"job.batch/cluster-run-x1":     {"1", "2", "cluster"},
+		"job.batch/cluster-run-x2":     {"1", "", "cluster"},
+		"job.batch/cluster-run-x3":     {"", "4", "cluster"},
+		"job.batch/other-run-x1":       {"1", "7", "other"},
+		"job.batch/cluster-run-old":    {"1", "<no value>", "cluster"},
+		"job.batch/cluster-two-run-x1": {"1", "9", "cluster-two"},
+	}
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		if params[1] == "job" {
+			var names []string
+			for name, job := range jobs {
+				if params[len(params)-1] == databaseJobsSelector(job[2]) {
+					names = append(names, name)
+				}
+			}
+			return strings.Join(names, "\n"), nil
+		}
+		job := jobs[params[1]]
+		if strings.Contains(params[3], schemaVersionLabel) {
+			return "'" + job[1] + "'", nil
+		}
+		return "'" + job[0] + "'", nil
+	}
+
+	t.Run("must return the highest version of the succeeded jobs of the cluster", func(t *testing.T) {
+		version, completed, err := k8sImpl.appliedSchemaVersion(context.Background(), "cluster")
+		if err != nil || !completed || version != 2 {
+			t.Fatalf("Got %v, %v and error %v, expect 2, true and nil", version, completed, err)
+		}
+	})
+
+	t.Run("must not be completed without succeeded job", func(t *testing.T) {
+		version, completed, err := k8sImpl.appliedSchemaVersion(context.Background(), "missing")
+		if err != nil || completed || version != 0 {
+			t.Fatalf("Got %v, %v and error %v, expect 0, false and nil", version, completed, err)
+		}
+	})
+
+	t.Run("must return an error when the jobs could not be listed", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			return "", errors.New("error kubectl get")
+		}
+		if _, _, err := k8sImpl.appliedSchemaVersion(context.Background(), "cluster"); err == nil {
+			t.Fatalf("Got nil, expect an error")
+		}
+	})
+}
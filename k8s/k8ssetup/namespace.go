@@ -0,0 +1,40 @@
+package k8ssetup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// manifestNamespaceRegex matches the namespaces set in a manifest, the ones of the objects and the ones of references like role subjects
+var manifestNamespaceRegex = regexp.MustCompile(`(?m)^(\s*-?\s*namespace:\s*)["']?default["']?\s*$`)
+
+func (k k8sSetUpImpl) namespace() string {
+	return k.spec.Namespace
+}
+
+// NamespaceCreation creates the namespace of the environment when it does not exist
+func (k k8sSetUpImpl) NamespaceCreation(ctx context.Context) error {
+	namespace := k.namespace()
+	recordOutput(ctx, "namespace", namespace)
+	logger.Infof(ctx, "Checking namespace %q ...", namespace)
+	if exists, err := k.cluster.resourceExists(ctx, "namespace", namespace, namespace); err == nil && exists {
+		logger.Infof(ctx, "Namespace %q already exists ...", namespace)
+		recordResource(ctx, "namespace/"+namespace, resourcePresent)
+		return nil
+	}
+	logger.Infof(ctx, "Creating namespace %q ...", namespace)
+	if err := k.cluster.createNamespace(ctx, namespace); err != nil {
+		return fmt.Errorf("error creating namespace %q: %v", namespace, err)
+	}
+	recordResource(ctx, "namespace/"+namespace, resourceCreated)
+	return nil
+}
+
+// setManifestNamespace moves the objects of a manifest written for the default namespace to ours
+func (k k8sSetUpImpl) setManifestNamespace(content []byte) []byte {
+	if k.namespace() == defaultNamespace {
+		return content
+	}
+	return manifestNamespaceRegex.ReplaceAll(content, []byte("${1}"+k.namespace()))
+}
@@ -0,0 +1,123 @@
+package k8ssetup
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_NamespaceCreation(t *testing.T) {
+	spec := DefaultSpec()
+	spec.Namespace = "pets-staging"
+	k8sImpl := NewK8sSetUpWithSpec(spec, Options{}).(*k8sSetUpImpl)
+
+	t.Run("must not create the namespace when it exists", func(t *testing.T) {
+		var commands []string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			commands = append(commands, strings.Join(params, " "))
+			return "Name: pets-staging", nil
+		}
+
+		if gotErr := k8sImpl.NamespaceCreation(context.Background()); gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		expect := []string{"describe namespace/pets-staging -n pets-staging"}
+		if !reflect.DeepEqual(commands, expect) {
+			t.Fatalf("Got %v, expect %v", commands, expect)
+		}
+	})
+
+	t.Run("must create the namespace when it does not exist", func(t *testing.T) {
+		var commands []string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			commands = append(commands, strings.Join(params, " "))
+			if params[0] == "describe" {
+				return "", errors.New("not found")
+			}
+			return "namespace/pets-staging created", nil
+		}
+
+		if gotErr := k8sImpl.NamespaceCreation(context.Background()); gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		expect := []string{"describe namespace/pets-staging -n pets-staging", "create namespace pets-staging"}
+		if !reflect.DeepEqual(commands, expect) {
+			t.Fatalf("Got %v, expect %v", commands, expect)
+		}
+	})
+
+	t.Run("must return error when the namespace could not be created", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			return "", errors.New("forbidden")
+		}
+
+		expect := "error creating namespace \"pets-staging\": forbidden"
+		if got := k8sImpl.NamespaceCreation(context.Background()); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %v", got, expect)
+		}
+	})
+}
+
+func Test_namespaceInEveryStep(t *testing.T) {
+	spec := DefaultSpec()
+	spec.Namespace = "pets-staging"
+	k8sImpl := NewK8sSetUpWithSpec(spec, Options{}).(*k8sSetUpImpl)
+
+	var commands []string
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		commands = append(commands, strings.Join(params, " "))
+		if params[0] == "kudo" {
+			return "[]", nil
+		}
+		return "", nil
+	}
+
+	_, _ = k8sImpl.isPsqlOperatorRunning(context.Background())
+	_, _ = k8sImpl.isDatabaseCreated(context.Background(), "cluster")
+	_, _ = k8sImpl.isKudoInstanceCreated(context.Background(), "kafka-pets")
+	_, _ = k8sImpl.isPodRunning(context.Background(), "kafka-pets-kafka-0", k8sImpl.namespace())
+	expect := []string{
+		"get pod -o name -n pets-staging",
+		"describe postgresql/cluster -n pets-staging",
+		"kudo get instances -o json --namespace pets-staging",
+		"get pod -o name -n pets-staging",
+	}
+	if !reflect.DeepEqual(commands, expect) {
+		t.Fatalf("Got %v, expect %v", commands, expect)
+	}
+}
+
+func Test_setManifestNamespace(t *testing.T) {
+	const manifest = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: postgres-operator
+  namespace: default
+---
+kind: ClusterRoleBinding
+subjects:
+- kind: ServiceAccount
+  name: postgres-operator
+  namespace: "default"
+`
+
+	t.Run("must move the objects to the namespace", func(t *testing.T) {
+		spec := DefaultSpec()
+		spec.Namespace = "pets-staging"
+		k8sImpl := NewK8sSetUpWithSpec(spec, Options{}).(*k8sSetUpImpl)
+
+		content := k8sImpl.setManifestNamespace([]byte(manifest))
+		if got := strings.Count(string(content), "namespace: pets-staging"); got != 2 {
+			t.Fatalf("Got %d namespaces replaced in %s, expect %d", got, content, 2)
+		}
+	})
+
+	t.Run("must not change the manifest in the default namespace", func(t *testing.T) {
+		k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+		if got := string(k8sImpl.setManifestNamespace([]byte(manifest))); got != manifest {
+			t.Fatalf("Got %q, expect %q", got, manifest)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package k8ssetup
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	gitOperatorSource       = "git"
+	embeddedOperatorSource  = "embedded"
+	directoryOperatorSource = "directory"
+	embeddedOperatorDir     = "operator-manifests"
+)
+
+//go:generate go run operator_manifests_gen.go -repository https://github.com/zalando/postgres-operator.git -out operator-manifests
+
+// embeddedOperatorManifestsFS is where the embedded source reads, it could be changed in tests
+var embeddedOperatorManifestsFS fs.FS = embeddedOperatorManifests
+
+var (
+	// defaultOperatorCacheDir is where the operator repositories are cloned when the spec does not say
+	defaultOperatorCacheDir = operatorCacheDir()
+	cacheNameRegex          = regexp.MustCompile("[^A-Za-z0-9._-]+")
+	operatorFetchRefSpecs   = []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"}
+)
+
+func operatorCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "pets-go-infra")
+	}
+	return filepath.Join(os.TempDir(), "pets-go-infra")
+}
+
+// operatorCachePath returns the directory of the repository in the cache, every repository has its own
+func (k k8sSetUpImpl) operatorCachePath() string {
+	name := strings.Trim(cacheNameRegex.ReplaceAllString(k.psqlOperatorRepo, "_"), "_.")
+	return filepath.Join(k.spec.Operator.CacheDir, name)
+}
+
+// openPsqlOperatorCache returns the cached repository, it is cloned when it is not in the cache
+func (k k8sSetUpImpl) openPsqlOperatorCache(ctx context.Context, dir string) (*git.Repository, error) {
+	repo, err := git.PlainOpen(dir)
+	if err == nil {
+		logger.Infof(ctx, "Using postgres operator cache %s ...", dir)
+		return repo, nil
+	}
+	if err != git.ErrRepositoryNotExists {
+		return nil, fmt.Errorf("error opening postgres operator cache %q: %v", dir, err)
+	}
+
+	logger.Infof(ctx, "Cloning postgres operator into %s ...", dir)
+	if repo, err = git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+		URL:        k.psqlOperatorRepo,
+		Progress:   logger.progress(),
+		NoCheckout: true,
+	}); err != nil {
+		removeDir(dir)
+		return nil, fmt.Errorf("error clonning postgres operator: %v", err)
+	}
+	return repo, nil
+}
+
+// resolvePsqlOperatorVersion returns the commit of the version, what is not in the cache is fetched
+func (k k8sSetUpImpl) resolvePsqlOperatorVersion(ctx context.Context, repo *git.Repository) (*plumbing.Hash, error) {
+	version := k.spec.Operator.Version
+	if hash, err := repo.ResolveRevision(plumbing.Revision(version)); err == nil {
+		return hash, nil
+	}
+
+	logger.Infof(ctx, "Postgres operator version %q not in the cache, fetching ...", version)
+	if err := repo.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: operatorFetchRefSpecs,
+		Progress: logger.progress(),
+		Force:    true,
+	}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, fmt.Errorf("error fetching postgres operator: %v", err)
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(version))
+	if err != nil {
+		return nil, fmt.Errorf("postgres operator version %q not found: %v", version, err)
+	}
+	return hash, nil
+}
+
+// checkoutPsqlOperator returns the cached repository checked out at the version of the spec
+func (k k8sSetUpImpl) checkoutPsqlOperator(ctx context.Context) (string, error) {
+	dir := k.operatorCachePath()
+	repo, err := k.openPsqlOperatorCache(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	hash, err := k.resolvePsqlOperatorVersion(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("error opening postgres operator worktree: %v", err)
+	}
+	if err = worktree.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true}); err != nil {
+		return "", fmt.Errorf("error checking out postgres operator %q: %v", k.spec.Operator.Version, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("error reading postgres operator checkout: %v", err)
+	}
+	if head.Hash() != *hash {
+		return "", fmt.Errorf("postgres operator checkout is %s, expect %s", head.Hash(), hash)
+	}
+	logger.Infof(ctx, "Zalando postgresSQL operator %s checked out at %s ...", k.spec.Operator.Version, hash)
+	return dir, nil
+}
+
+// psqlOperatorFiles returns where the operator manifests are, a checkout of the repository, a directory or the binary
+func (k k8sSetUpImpl) psqlOperatorFiles(ctx context.Context) (fsys fs.FS, err error) {
+	switch k.spec.Operator.Source {
+	case embeddedOperatorSource:
+		logger.Infof(ctx, "Using the postgres operator manifests embedded in the binary ...")
+		fsys, err = fs.Sub(embeddedOperatorManifestsFS, embeddedOperatorDir)
+	case directoryOperatorSource:
+		logger.Infof(ctx, "Using the postgres operator manifests in %s ...", k.spec.Operator.Directory)
+		fsys = os.DirFS(k.spec.Operator.Directory)
+	default:
+		var dir string
+		if dir, err = k.checkoutPsqlOperator(ctx); err != nil {
+			return nil, err
+		}
+		fsys, err = fs.Sub(os.DirFS(dir), "manifests")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening postgres operator manifests: %v", err)
+	}
+
+	var missing []string
+	for _, v := range psqlOperatorManifests {
+		if _, err = fs.Stat(fsys, v); err != nil {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) != 0 {
+		err = fmt.Errorf("postgres operator manifests not found in the %s source: %s", k.spec.Operator.Source, strings.Join(missing, ", "))
+		if k.spec.Operator.Source == embeddedOperatorSource {
+			err = fmt.Errorf("%v, %s", err, embeddedOperatorHint)
+		}
+		return nil, err
+	}
+	return fsys, nil
+}
+
+// splitPsqlOperatorManifest returns a temp file with the objects of an operator manifest in our namespace, empty when
+// there are none, and its cluster scoped objects that every namespace shares
+func (k k8sSetUpImpl) splitPsqlOperatorManifest(fsys fs.FS, manifest string) (namespaced string, clusterScoped []manifestObject, err error) {
+	fileName, err := k.psqlOperatorManifest(fsys, manifest)
+	if err != nil {
+		return "", nil, err
+	}
+	defer removeFile(fileName)
+	return splitManifest(fileName)
+}
+
+// psqlOperatorManifest copies a manifest of the operator to a temp file in our namespace, the source is never changed
+func (k k8sSetUpImpl) psqlOperatorManifest(fsys fs.FS, manifest string) (string, error) {
+	content, err := fs.ReadFile(fsys, manifest)
+	if err != nil {
+		return "", fmt.Errorf("error reading operator manifest %q: %v", manifest, err)
+	}
+	newFile, err := ioutil.TempFile("", "*-"+manifest)
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file for %q", manifest)
+	}
+	defer newFile.Close()
+	if _, err = newFile.Write(k.setManifestNamespace(content)); err != nil {
+		removeFile(newFile.Name())
+		return "", fmt.Errorf("error writting in temp file %q", newFile.Name())
+	}
+	return newFile.Name(), nil
+}
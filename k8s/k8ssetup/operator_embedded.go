@@ -0,0 +1,16 @@
+//go:build embedded_operator
+// +build embedded_operator
+
+package k8ssetup
+
+import "embed"
+
+// embeddedOperatorHint tells how to get the manifests of the embedded source when they are missing
+const embeddedOperatorHint = "run go generate ./... before building to embed them"
+
+// embeddedOperatorManifests are the manifests of the pinned operator version, go generate downloads them and a
+// build with the embedded_operator tag fails without them
+//
+//go:embed operator-manifests/configmap.yaml operator-manifests/operator-service-account-rbac.yaml
+//go:embed operator-manifests/postgres-operator.yaml operator-manifests/api-service.yaml
+var embeddedOperatorManifests embed.FS
@@ -0,0 +1,26 @@
+//go:build !embedded_operator
+// +build !embedded_operator
+
+package k8ssetup
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// embeddedOperatorHint tells how to get the manifests of the embedded source when they are missing
+const embeddedOperatorHint = "copy them to the " + embeddedOperatorDir + " dir next to the binary, or run go generate ./... " +
+	"and build with -tags embedded_operator to embed them"
+
+// embeddedOperatorManifests are the manifests in the operator-manifests dir next to the binary when the build does not
+// embed them, a release ships them with the binary so the operator is still installed without network access
+var embeddedOperatorManifests = os.DirFS(executableDir())
+
+// executableDir returns the dir of the binary, the working dir when it is not known
+func executableDir() string {
+	executable, err := os.Executable()
+	if err != nil {
+		return "."
+	}
+	return filepath.Dir(executable)
+}
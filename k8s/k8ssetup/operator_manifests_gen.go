@@ -0,0 +1,126 @@
+//go:build ignore
+// +build ignore
+
+// operator_manifests_gen downloads the manifests of the postgres operator version of the package to embed them in
+// the binary, the version and the manifests are the zalandoPsqlOperatorVersion and psqlOperatorManifests of the
+// package so they could not differ from the ones the set up uses
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	packageName = "k8ssetup"
+	versionName = "zalandoPsqlOperatorVersion"
+	listName    = "psqlOperatorManifests"
+)
+
+func main() {
+	repository := flag.String("repository", "https://github.com/zalando/postgres-operator.git", "operator repository")
+	out := flag.String("out", "operator-manifests", "directory of the manifests")
+	flag.Parse()
+
+	version, manifests, err := packageManifests(".")
+	if err == nil {
+		err = generate(*repository, version, manifests, *out)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// packageManifests reads the operator version and its manifests from the sources of the package in dir
+func packageManifests(dir string) (version string, manifests []string, err error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return "", nil, fmt.Errorf("error parsing package %q: %v", dir, err)
+	}
+	pkg, ok := pkgs[packageName]
+	if !ok {
+		return "", nil, fmt.Errorf("package %s not found in %q", packageName, dir)
+	}
+
+	for _, file := range pkg.Files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			spec, ok := node.(*ast.ValueSpec)
+			if !ok {
+				return true
+			}
+			for i, name := range spec.Names {
+				if i >= len(spec.Values) {
+					break
+				}
+				switch name.Name {
+				case versionName:
+					version = stringValue(spec.Values[i])
+				case listName:
+					if list, ok := spec.Values[i].(*ast.CompositeLit); ok {
+						for _, v := range list.Elts {
+							manifests = append(manifests, stringValue(v))
+						}
+					}
+				}
+			}
+			return false
+		})
+	}
+	if version == "" || len(manifests) == 0 {
+		return "", nil, fmt.Errorf("%s or %s not found in package %s", versionName, listName, packageName)
+	}
+	return version, manifests, nil
+}
+
+func stringValue(expr ast.Expr) string {
+	if lit, ok := expr.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+		value, _ := strconv.Unquote(lit.Value)
+		return value
+	}
+	return ""
+}
+
+func generate(repository, version string, manifests []string, out string) error {
+	dir, err := ioutil.TempDir("", "postgres-operator")
+	if err != nil {
+		return fmt.Errorf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	log.Printf("Cloning postgres operator %s %s ...", repository, version)
+	if _, err = git.PlainClone(dir, false, &git.CloneOptions{
+		URL:           repository,
+		ReferenceName: plumbing.NewTagReferenceName(version),
+		SingleBranch:  true,
+		Depth:         1,
+	}); err != nil {
+		return fmt.Errorf("error clonning postgres operator: %v", err)
+	}
+
+	for _, v := range manifests {
+		content, err := ioutil.ReadFile(filepath.Join(dir, "manifests", v))
+		if err != nil {
+			return fmt.Errorf("error reading file %q: %v", v, err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(out, v), content, 0644); err != nil {
+			return fmt.Errorf("error writting file %q: %v", v, err)
+		}
+		log.Printf("Written %s", filepath.Join(out, v))
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package k8ssetup
+
+import (
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// commitOperatorChange commits a new version of the configmap in the repo and returns its hash
+func commitOperatorChange(t *testing.T, dir, content string) string {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatalf("error opening repo: %v", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "manifests", psqlOperatorManifests[0]), []byte(content), 0644); err != nil {
+		t.Fatalf("error writing configmap: %v", err)
+	}
+	wt, _ := repo.Worktree()
+	_, _ = wt.Add(path.Join("manifests", psqlOperatorManifests[0]))
+	hash, err := wt.Commit(content, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@test.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("error committing: %v", err)
+	}
+	return hash.String()
+}
+
+func newOperatorSetUp(t *testing.T, repo string) *k8sSetUpImpl {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	k8sImpl.spec.Operator.CacheDir = tempDir(t)
+	k8sImpl.psqlOperatorRepo = repo
+	return k8sImpl
+}
+
+func readConfigMap(t *testing.T, dir string) string {
+	return readFile(t, filepath.Join(dir, "manifests", psqlOperatorManifests[0]))
+}
+
+func Test_checkoutPsqlOperator(t *testing.T) {
+	t.Run("must check out the pinned tag and not the latest commit", func(t *testing.T) {
+		repo := newLocalPsqlOperatorRepo(t)
+		commitOperatorChange(t, repo, "kind: unreleased")
+		k8sImpl := newOperatorSetUp(t, repo)
+
+		dir, gotErr := k8sImpl.checkoutPsqlOperator(context.Background())
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got, expect := readConfigMap(t, dir), "kind: "+psqlOperatorManifests[0]; got != expect {
+			t.Fatalf("Got %q, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must reuse the cache without the repository", func(t *testing.T) {
+		repo := newLocalPsqlOperatorRepo(t)
+		k8sImpl := newOperatorSetUp(t, repo)
+		first, err := k8sImpl.checkoutPsqlOperator(context.Background())
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if err = os.RemoveAll(repo); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+
+		second, gotErr := k8sImpl.checkoutPsqlOperator(context.Background())
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if first != second || !strings.HasPrefix(second, k8sImpl.spec.Operator.CacheDir) {
+			t.Fatalf("Got %q, expect %q in the cache", second, first)
+		}
+	})
+
+	t.Run("must fetch a commit that is not in the cache", func(t *testing.T) {
+		repo := newLocalPsqlOperatorRepo(t)
+		k8sImpl := newOperatorSetUp(t, repo)
+		if _, err := k8sImpl.checkoutPsqlOperator(context.Background()); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		k8sImpl.spec.Operator.Version = commitOperatorChange(t, repo, "kind: fixed")
+
+		dir, gotErr := k8sImpl.checkoutPsqlOperator(context.Background())
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got, expect := readConfigMap(t, dir), "kind: fixed"; got != expect {
+			t.Fatalf("Got %q, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must restore the files changed in the cache", func(t *testing.T) {
+		k8sImpl := newOperatorSetUp(t, newLocalPsqlOperatorRepo(t))
+		dir, err := k8sImpl.checkoutPsqlOperator(context.Background())
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		_ = ioutil.WriteFile(filepath.Join(dir, "manifests", psqlOperatorManifests[0]), []byte("kind: changed"), 0644)
+
+		if _, gotErr := k8sImpl.checkoutPsqlOperator(context.Background()); gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got, expect := readConfigMap(t, dir), "kind: "+psqlOperatorManifests[0]; got != expect {
+			t.Fatalf("Got %q, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must return error when the version does not exist", func(t *testing.T) {
+		k8sImpl := newOperatorSetUp(t, newLocalPsqlOperatorRepo(t))
+		k8sImpl.spec.Operator.Version = "v0.0.1"
+
+		expect := "postgres operator version \"v0.0.1\" not found"
+		if _, got := k8sImpl.checkoutPsqlOperator(context.Background()); got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
+
+func Test_psqlOperatorFiles(t *testing.T) {
+	t.Run("must use the manifests of the repository with the git source", func(t *testing.T) {
+		k8sImpl := newOperatorSetUp(t, newLocalPsqlOperatorRepo(t))
+
+		fsys, gotErr := k8sImpl.psqlOperatorFiles(context.Background())
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got, _ := fs.ReadFile(fsys, psqlOperatorManifests[0]); string(got) != "kind: "+psqlOperatorManifests[0] {
+			t.Fatalf("Got %q, expect %q", got, "kind: "+psqlOperatorManifests[0])
+		}
+	})
+
+	t.Run("must use the manifests of the directory source without the repository", func(t *testing.T) {
+		k8sImpl := newOperatorSetUp(t, "file:///not-found")
+		k8sImpl.spec.Operator.Source = directoryOperatorSource
+		k8sImpl.spec.Operator.Directory = filepath.Join(newLocalPsqlOperatorRepo(t), "manifests")
+
+		fsys, gotErr := k8sImpl.psqlOperatorFiles(context.Background())
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got, _ := fs.ReadFile(fsys, psqlOperatorManifests[1]); string(got) != "kind: "+psqlOperatorManifests[1] {
+			t.Fatalf("Got %q, expect %q", got, "kind: "+psqlOperatorManifests[1])
+		}
+		if _, err := os.Stat(k8sImpl.operatorCachePath()); !os.IsNotExist(err) {
+			t.Fatalf("Got %v, expect the repository not cloned", err)
+		}
+	})
+
+	t.Run("must use the manifests of the binary with the embedded source", func(t *testing.T) {
+		embedded := embeddedOperatorManifestsFS
+		defer func() { embeddedOperatorManifestsFS = embedded }()
+		mapFS := fstest.MapFS{}
+		for _, v := range psqlOperatorManifests {
+			mapFS[path.Join(embeddedOperatorDir, v)] = &fstest.MapFile{Data: []byte("kind: embedded")}
+		}
+		embeddedOperatorManifestsFS = mapFS
+		k8sImpl := newOperatorSetUp(t, "file:///not-found")
+		k8sImpl.spec.Operator.Source = embeddedOperatorSource
+
+		fsys, gotErr := k8sImpl.psqlOperatorFiles(context.Background())
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got, _ := fs.ReadFile(fsys, psqlOperatorManifests[2]); string(got) != "kind: embedded" {
+			t.Fatalf("Got %q, expect %q", got, "kind: embedded")
+		}
+	})
+
+	t.Run("must return error when the manifests are not embedded", func(t *testing.T) {
+		embedded := embeddedOperatorManifestsFS
+		defer func() { embeddedOperatorManifestsFS = embedded }()
+		embeddedOperatorManifestsFS = fstest.MapFS{path.Join(embeddedOperatorDir, "README.md"): &fstest.MapFile{}}
+		k8sImpl := newOperatorSetUp(t, "file:///not-found")
+		k8sImpl.spec.Operator.Source = embeddedOperatorSource
+
+		expect := "postgres operator manifests not found in the embedded source: " + strings.Join(psqlOperatorManifests, ", ") +
+			", " + embeddedOperatorHint
+		if _, got := k8sImpl.psqlOperatorFiles(context.Background()); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must return error when a manifest is not in the directory", func(t *testing.T) {
+		k8sImpl := newOperatorSetUp(t, "file:///not-found")
+		k8sImpl.spec.Operator.Source = directoryOperatorSource
+		k8sImpl.spec.Operator.Directory = tempDir(t)
+
+		expect := "postgres operator manifests not found in the directory source"
+		if _, got := k8sImpl.psqlOperatorFiles(context.Background()); got == nil || !strings.HasPrefix(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
+
+func Test_psqlOperatorManifest(t *testing.T) {
+	spec := DefaultSpec()
+	spec.Namespace = "pets-staging"
+	k8sImpl := NewK8sSetUpWithSpec(spec, Options{}).(*k8sSetUpImpl)
+	dir := tempDir(t)
+	original := "kind: ServiceAccount\nmetadata:\n  namespace: default\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "rbac.yaml"), []byte(original), 0644); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+
+	fileName, gotErr := k8sImpl.psqlOperatorManifest(os.DirFS(dir), "rbac.yaml")
+	if gotErr != nil {
+		t.Fatalf("Got error %v, expect nil", gotErr)
+	}
+	defer removeFile(fileName)
+	if got, _ := ioutil.ReadFile(fileName); !strings.Contains(string(got), "namespace: pets-staging") {
+		t.Fatalf("Got %q, expect the manifest in namespace %q", got, "pets-staging")
+	}
+	if got := readFile(t, filepath.Join(dir, "rbac.yaml")); got != original {
+		t.Fatalf("Got %q, expect the original manifest %q", got, original)
+	}
+}
+
+func readFile(t *testing.T, fileName string) string {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	return string(content)
+}
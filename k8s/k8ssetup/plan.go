@@ -0,0 +1,137 @@
+package k8ssetup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	mutatingVerbs = map[string]bool{
+		"create":    true,
+		"apply":     true,
+		"delete":    true,
+		"replace":   true,
+		"patch":     true,
+		"edit":      true,
+		"label":     true,
+		"annotate":  true,
+		"scale":     true,
+		"rollout":   true,
+		"set":       true,
+		"expose":    true,
+		"autoscale": true,
+		"build":     true,
+		"bud":       true,
+		"push":      true,
+		"tag":       true,
+		"rm":        true,
+		"rmi":       true,
+		"run":       true,
+	}
+	mutatingExecFlags = map[string]bool{
+		"--create": true,
+		"--alter":  true,
+		"--delete": true,
+	}
+	mutatingKudoVerbs = map[string]bool{
+		"init":      true,
+		"install":   true,
+		"uninstall": true,
+		"upgrade":   true,
+		"update":    true,
+	}
+)
+
+// Plan records the mutating commands of a run instead of executing them
+type Plan struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+// NewPlan returns an empty plan
+func NewPlan() *Plan {
+	return &Plan{}
+}
+
+// Steps returns the recorded commands in order
+func (p *Plan) Steps() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.steps...)
+}
+
+// Print writes the recorded commands as an ordered list
+func (p *Plan) Print(w io.Writer) (err error) {
+	steps := p.Steps()
+	if _, err = fmt.Fprintf(w, "Plan: %d step(s)\n", len(steps)); err != nil {
+		return
+	}
+	for i, v := range steps {
+		if _, err = fmt.Fprintf(w, "%4d. %s\n", i+1, v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *Plan) record(step string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.steps = append(p.steps, step)
+}
+
+func isMutatingCommand(params []string) bool {
+	if len(params) == 0 {
+		return false
+	}
+	if params[0] == "--config" && len(params) > 2 {
+		// docker global flags go before the command
+		params = params[2:]
+	}
+	if params[0] == "kudo" {
+		return len(params) > 1 && mutatingKudoVerbs[params[1]]
+	}
+	if params[0] == "exec" {
+		// the commands that we run in pods, like kafka-topics.sh, change things with flags
+		for _, v := range params[1:] {
+			if mutatingExecFlags[v] {
+				return true
+			}
+		}
+		return false
+	}
+	// kubectl-kudo is run without the kudo param
+	return mutatingVerbs[params[0]] || mutatingKudoVerbs[params[0]]
+}
+
+func formatCommand(cmdName string, params ...string) string {
+	parts := []string{filepath.Base(cmdName)}
+	for _, v := range params {
+		if v == "" || strings.ContainsAny(v, " \t\"'") {
+			v = fmt.Sprintf("%q", v)
+		}
+		parts = append(parts, v)
+	}
+	return strings.Join(parts, " ")
+}
+
+// planExecuteCommand runs the read only commands and records the mutating ones in the plan
+func planExecuteCommand(plan *Plan, execute func(ctx context.Context, cmdName string, params ...string) (string, error)) func(ctx context.Context, cmdName string, params ...string) (string, error) {
+	return func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		if !isMutatingCommand(params) {
+			return execute(ctx, cmdName, params...)
+		}
+		step := formatCommand(cmdName, params...)
+		logger.Infof(ctx, "Plan: %s", step)
+		plan.record(step)
+		return "", nil
+	}
+}
+
+func (k k8sSetUpImpl) planMode() bool {
+	return k.plan != nil
+}
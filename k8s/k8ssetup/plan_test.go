@@ -0,0 +1,157 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_isMutatingCommand(t *testing.T) {
+	type TestCase struct {
+		params []string
+		expect bool
+	}
+
+	cases := []TestCase{
+		{params: []string{"get", "pod"}, expect: false},
+		{params: []string{"describe", "service/postgres-operator"}, expect: false},
+		{params: []string{"create", "-f", "pets-db.yml"}, expect: true},
+		{params: []string{"delete", "job", "-l", "job-group=petstore-jobs"}, expect: true},
+		{params: []string{"kudo", "version"}, expect: false},
+		{params: []string{"kudo", "get", "instances"}, expect: false},
+		{params: []string{"kudo", "install", "zookeeper"}, expect: true},
+		{params: []string{"kudo", "uninstall", "--instance", "kafka-pets"}, expect: true},
+		{params: []string{"install", "zookeeper"}, expect: true},
+		{params: []string{"version"}, expect: false},
+		{params: []string{"exec", "pod/kafka-pets-kafka-0", "--", "kafka-topics.sh", "--list"}, expect: false},
+		{params: []string{"exec", "pod/kafka-pets-kafka-0", "--", "kafka-topics.sh", "--create"}, expect: true},
+		{params: []string{"build", "..", "-f", "Dockerfile"}, expect: true},
+		{params: []string{"push", "localhost/job"}, expect: true},
+		{params: []string{"--config", "/tmp/registry-auth", "push", "localhost/job"}, expect: true},
+		{params: []string{"bud", "-f", "Dockerfile", "-t", "localhost/job", ".."}, expect: true},
+		{params: []string{}, expect: false},
+	}
+
+	for _, tt := range cases {
+		t.Run(strings.Join(tt.params, " "), func(t *testing.T) {
+			got := isMutatingCommand(tt.params)
+			if got != tt.expect {
+				t.Fatalf("Got %v, expect %v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func Test_formatCommand(t *testing.T) {
+	expect := `kubectl kudo install kafka -p "ZOOKEEPER_URI=\"a,b\""`
+	got := formatCommand("/usr/bin/kubectl", "kudo", "install", "kafka", "-p", `ZOOKEEPER_URI="a,b"`)
+	if got != expect {
+		t.Fatalf("Got %q, expect %q", got, expect)
+	}
+}
+
+func Test_planExecuteCommand(t *testing.T) {
+	plan := NewPlan()
+	var executed []string
+	execute := planExecuteCommand(plan, func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		executed = append(executed, strings.Join(params, " "))
+		return "output", nil
+	})
+
+	t.Run("must execute read only commands", func(t *testing.T) {
+		got, gotErr := execute(context.Background(), "kubectl", "get", "pod")
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got != "output" {
+			t.Fatalf("Got %q, expect %q", got, "output")
+		}
+	})
+
+	t.Run("must record mutating commands", func(t *testing.T) {
+		got, gotErr := execute(context.Background(), "docker", "push", "localhost/job")
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got != "" {
+			t.Fatalf("Got %q, expect empty output", got)
+		}
+	})
+
+	if expect := []string{"get pod"}; !reflect.DeepEqual(executed, expect) {
+		t.Fatalf("Got executed %v, expect %v", executed, expect)
+	}
+	if expect := []string{"docker push localhost/job"}; !reflect.DeepEqual(plan.Steps(), expect) {
+		t.Fatalf("Got plan %v, expect %v", plan.Steps(), expect)
+	}
+}
+
+func Test_PlanPrint(t *testing.T) {
+	plan := NewPlan()
+	plan.record("kubectl create -f pets-db.yml")
+	plan.record("docker push localhost/job")
+
+	expect := "Plan: 2 step(s)\n   1. kubectl create -f pets-db.yml\n   2. docker push localhost/job\n"
+	var got bytes.Buffer
+	if err := plan.Print(&got); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	if got.String() != expect {
+		t.Fatalf("Got %q, expect %q", got.String(), expect)
+	}
+}
+
+func Test_DatabaseCreationPlan(t *testing.T) {
+	// setup
+	wd, _ := os.Getwd()
+	os.Chdir("_test")
+	plan := NewPlan()
+	k8sImpl := NewK8sSetUpWithSpec(DefaultSpec(), Options{Plan: plan}).(*k8sSetUpImpl)
+	k8sImpl.kubectlPath = "kubectl"
+	k8sImpl.builder = newImageBuilder(k8sImpl, dockerBuilder, "docker")
+	k8sImpl.dockerRegistry = "http://localhost:5000"
+	k8sImpl.executeCommand = planExecuteCommand(plan, func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		if isMutatingCommand(params) {
+			t.Fatalf("Unexpected mutating command %v", params)
+		}
+		if params[0] == "describe" {
+			return "error", errors.New("not found")
+		}
+		if params[0] == "get" && params[1] == "job" {
+			return "", nil
+		}
+		return "map[PostgresClusterStatus:Creating]", nil
+	})
+
+	got := k8sImpl.DatabaseCreation(context.Background(), "psql-cluster.yml")
+	if got != nil {
+		t.Fatalf("Got error %v, expect nil", got)
+	}
+
+	steps := plan.Steps()
+	if len(steps) != 4 {
+		t.Fatalf("Got %d steps %v, expect 4", len(steps), steps)
+	}
+	// manifests are created from a temp file with their variables replaced
+	if !strings.HasSuffix(steps[0], "-psql-cluster.yml -n default") {
+		t.Fatalf("Got step 1 %q, expect the rendered %q", steps[0], "psql-cluster.yml")
+	}
+	expect := []string{
+		"kubectl create -f ",
+		"docker build .. -f Dockerfile-cluster-job -t localhost:5000/cluster-job",
+		"docker push localhost:5000/cluster-job",
+		"kubectl create -f ",
+	}
+	for i, v := range expect {
+		if !strings.HasPrefix(steps[i], v) {
+			t.Fatalf("Got step %d %q, expect %q", i+1, steps[i], v)
+		}
+	}
+
+	//tear down
+	os.Chdir(wd)
+}
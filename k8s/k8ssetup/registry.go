@@ -0,0 +1,379 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	dockerConfigVar            = "DOCKER_CONFIG"
+	defaultRegistryPasswordVar = "DOCKER_REGISTRY_PASSWORD"
+	credentialHelperPrefix     = "docker-credential-"
+)
+
+var (
+	// challengeParamRegex matches the params of a WWW-Authenticate header like realm="https://auth/token"
+	challengeParamRegex = regexp.MustCompile(`([A-Za-z_]+)="([^"]*)"`)
+	digestRegex         = regexp.MustCompile("^sha256:[a-f0-9]{64}$")
+	// manifestMediaTypes are the manifests that we accept, the digest is the one of the manifest the builder pushed
+	manifestMediaTypes = []string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+	}
+)
+
+// registryCredentials are the user and password of the registry, fromSpec is true when they are not in the docker config
+type registryCredentials struct {
+	username string
+	password string
+	fromSpec bool
+}
+
+// registryClient calls the docker registry API, the 401 challenges of the registry are answered with
+// basic credentials or with a bearer token of its token service
+type registryClient struct {
+	url         string
+	client      *http.Client
+	credentials *registryCredentials
+
+	mu            sync.Mutex
+	authorization string
+}
+
+// dockerConfigFile is the part of ~/.docker/config.json that we need
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// newRegistryClient returns a client of the registry at url with the TLS settings and the credentials of the spec
+func newRegistryClient(ctx context.Context, registryURL string, spec RegistrySpec) (*registryClient, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: spec.Insecure}
+	if spec.CACert != "" {
+		pem, err := ioutil.ReadFile(spec.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("error reading registry CA %q: %v", spec.CACert, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in registry CA %q", spec.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	credentials, err := findRegistryCredentials(ctx, registryHost(registryURL), spec)
+	if err != nil {
+		return nil, err
+	}
+	return &registryClient{
+		url:         strings.TrimRight(registryURL, "/"),
+		client:      &http.Client{Transport: transport},
+		credentials: credentials,
+	}, nil
+}
+
+// registryHost returns the host of a registry url, like the keys of the docker config
+func registryHost(registryURL string) string {
+	host := trimScheme(registryURL)
+	if i := strings.Index(host, "/"); i != -1 {
+		host = host[:i]
+	}
+	return host
+}
+
+// findRegistryCredentials returns the credentials of the spec or the ones of the docker config, nil when there are none
+func findRegistryCredentials(ctx context.Context, host string, spec RegistrySpec) (*registryCredentials, error) {
+	if spec.Username != "" {
+		password, ok := os.LookupEnv(spec.PasswordEnv)
+		if !ok {
+			return nil, fmt.Errorf("error getting registry password, variable %s does not exist", spec.PasswordEnv)
+		}
+		return &registryCredentials{username: spec.Username, password: password, fromSpec: true}, nil
+	}
+
+	fileName := spec.DockerConfig
+	if fileName == "" {
+		fileName = defaultDockerConfig()
+	}
+	content, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) && spec.DockerConfig == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading docker config %q: %v", fileName, err)
+	}
+	var config dockerConfigFile
+	if err = json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("error parsing docker config %q: %v", fileName, err)
+	}
+
+	if helper := config.CredHelpers[host]; helper != "" {
+		return credentialHelper(ctx, helper, host)
+	}
+	for key, auth := range config.Auths {
+		if registryHost(key) != host {
+			continue
+		}
+		if auth.Auth == "" {
+			return &registryCredentials{username: auth.Username, password: auth.Password}, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth of %s in docker config %q: %v", host, fileName, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid auth of %s in docker config %q", host, fileName)
+		}
+		return &registryCredentials{username: parts[0], password: parts[1]}, nil
+	}
+	if config.CredsStore != "" {
+		return credentialHelper(ctx, config.CredsStore, host)
+	}
+	return nil, nil
+}
+
+func defaultDockerConfig() string {
+	if dir := os.Getenv(dockerConfigVar); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// credentialHelper asks a docker credential helper like docker-credential-pass for the credentials of host,
+// a helper without credentials of the host is not an error
+func credentialHelper(ctx context.Context, helper, host string) (*registryCredentials, error) {
+	cmd := exec.CommandContext(ctx, credentialHelperPrefix+helper, "get")
+	cmd.Stdin = strings.NewReader(host)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stdout.String()+stderr.String(), "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting credentials of %s from %s%s: %v %s", host, credentialHelperPrefix, helper, err, stderr.String())
+	}
+	var output struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("invalid credentials of %s from %s%s: %v", host, credentialHelperPrefix, helper, err)
+	}
+	return &registryCredentials{username: output.Username, password: output.Secret}, nil
+}
+
+// do sends a request to the registry, when it answers 401 it is authenticated as the challenge asks and sent again
+func (c *registryClient) do(ctx context.Context, method, path string, header http.Header) (*http.Response, error) {
+	c.mu.Lock()
+	authorization := c.authorization
+	c.mu.Unlock()
+
+	resp, err := c.send(ctx, method, c.url+path, header, authorization)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	//noinspection GoUnhandledErrorResult
+	resp.Body.Close()
+
+	if authorization, err = c.authenticate(ctx, challenge); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.authorization = authorization
+	c.mu.Unlock()
+	return c.send(ctx, method, c.url+path, header, authorization)
+}
+
+func (c *registryClient) send(ctx context.Context, method, url string, header http.Header, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.client.Do(req)
+}
+
+// authenticate returns the Authorization header that answers a Basic or Bearer challenge
+func (c *registryClient) authenticate(ctx context.Context, challenge string) (string, error) {
+	scheme := strings.ToLower(strings.SplitN(strings.TrimSpace(challenge), " ", 2)[0])
+	switch scheme {
+	case "basic":
+		if c.credentials == nil {
+			return "", fmt.Errorf("registry %s needs credentials", c.url)
+		}
+		return "Basic " + basicAuth(c.credentials), nil
+	case "bearer":
+		params := map[string]string{}
+		for _, v := range challengeParamRegex.FindAllStringSubmatch(challenge, -1) {
+			params[strings.ToLower(v[1])] = v[2]
+		}
+		token, err := c.fetchToken(ctx, params)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("unsupported registry challenge %q", challenge)
+	}
+}
+
+// fetchToken gets a token of the token service of the registry, with our credentials or anonymously
+func (c *registryClient) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid registry token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	for _, name := range []string{"service", "scope"} {
+		if params[name] != "" {
+			query.Set(name, params[name])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	authorization := ""
+	if c.credentials != nil {
+		authorization = "Basic " + basicAuth(c.credentials)
+	}
+	resp, err := c.send(ctx, http.MethodGet, realm.String(), nil, authorization)
+	if err != nil {
+		return "", fmt.Errorf("error getting registry token: %v", err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error getting registry token, status is %d", resp.StatusCode)
+	}
+	var output struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&output); err != nil {
+		return "", fmt.Errorf("invalid registry token: %v", err)
+	}
+	if output.Token == "" {
+		output.Token = output.AccessToken
+	}
+	if output.Token == "" {
+		return "", fmt.Errorf("no registry token returned by %s", realm.Host)
+	}
+	return output.Token, nil
+}
+
+func basicAuth(credentials *registryCredentials) string {
+	return base64.StdEncoding.EncodeToString([]byte(credentials.username + ":" + credentials.password))
+}
+
+// ping checks that the registry answers its API with our credentials
+func (c *registryClient) ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, dockerRegistryPath, nil)
+	if err != nil {
+		return err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status is %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// manifestDigest returns the digest of the manifest of repository:reference, when the registry does not send
+// it in the Docker-Content-Digest header it is the sha256 of the manifest
+func (c *registryClient) manifestDigest(ctx context.Context, repository, reference string) (string, error) {
+	path := "/v2/" + repository + "/manifests/" + reference
+	header := http.Header{"Accept": {strings.Join(manifestMediaTypes, ", ")}}
+	resp, err := c.do(ctx, http.MethodHead, path, header)
+	if err != nil {
+		return "", err
+	}
+	//noinspection GoUnhandledErrorResult
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error getting manifest of %s:%s, status is %d", repository, reference, resp.StatusCode)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		if resp, err = c.do(ctx, http.MethodGet, path, header); err != nil {
+			return "", err
+		}
+		//noinspection GoUnhandledErrorResult
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("error getting manifest of %s:%s, status is %d", repository, reference, resp.StatusCode)
+		}
+		content, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("error reading manifest of %s:%s: %v", repository, reference, err)
+		}
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	}
+	if !digestRegex.MatchString(digest) {
+		return "", fmt.Errorf("unsupported digest %q of %s:%s", digest, repository, reference)
+	}
+	return digest, nil
+}
+
+// repository returns the repository of an image of the registry, the image without the registry host
+func (c *registryClient) repository(image string) string {
+	return strings.TrimPrefix(image, registryHost(c.url)+"/")
+}
+
+// pushConfigDir writes the credentials of the spec in a temp docker config for the image builder, the
+// credentials of the docker config are already used by the builders so it returns an empty dir for them
+func (c *registryClient) pushConfigDir() (string, error) {
+	if c == nil || c.credentials == nil || !c.credentials.fromSpec {
+		return "", nil
+	}
+	dir, err := ioutil.TempDir("", "registry-auth")
+	if err != nil {
+		return "", fmt.Errorf("error creating registry auth dir: %v", err)
+	}
+	content, _ := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			registryHost(c.url): map[string]string{"auth": basicAuth(c.credentials)},
+		},
+	})
+	if err = ioutil.WriteFile(filepath.Join(dir, "config.json"), content, 0600); err != nil {
+		removeDir(dir)
+		return "", fmt.Errorf("error writting registry auth: %v", err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,410 @@
+package k8ssetup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testRegistryUser     = "pets"
+	testRegistryPassword = "s3cr3t"
+	testRegistryToken    = "token-1"
+	testImageDigest      = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+)
+
+func testBasicAuth() string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(testRegistryUser+":"+testRegistryPassword))
+}
+
+// newBasicRegistry returns a registry stand-in that asks for basic credentials
+func newBasicRegistry() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != testBasicAuth() {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+// newTokenRegistry returns a registry stand-in that asks for a token of its token service, tokens counts the tokens given
+func newTokenRegistry(tokens *int) (registry *httptest.Server, tokenService *httptest.Server) {
+	tokenService = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != testBasicAuth() || r.URL.Query().Get("service") != "registry.test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		*tokens++
+		_, _ = fmt.Fprintf(w, `{"token": %q}`, testRegistryToken)
+	}))
+	registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testRegistryToken {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.test",scope="registry:catalog:*"`, tokenService.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	return
+}
+
+// newDigestRegistry returns a client of a registry stand-in that has every manifest with testImageDigest
+func newDigestRegistry(t *testing.T) *registryClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/manifests/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", testImageDigest)
+	}))
+	t.Cleanup(server.Close)
+	return &registryClient{url: server.URL, client: server.Client()}
+}
+
+func setRegistryPassword(t *testing.T, password string) {
+	os.Setenv(defaultRegistryPasswordVar, password)
+	t.Cleanup(func() { os.Unsetenv(defaultRegistryPasswordVar) })
+}
+
+func writeDockerConfig(t *testing.T, content string) string {
+	fileName := filepath.Join(tempDir(t), "config.json")
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	return fileName
+}
+
+func Test_registryClientPing(t *testing.T) {
+	dockerRegistryPath = "/v2/"
+
+	t.Run("must ping an anonymous registry", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v2/" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		client, err := newRegistryClient(context.Background(), server.URL, RegistrySpec{PasswordEnv: defaultRegistryPasswordVar})
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if got := client.ping(context.Background()); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+	})
+
+	t.Run("must answer a basic challenge with the credentials of the spec", func(t *testing.T) {
+		server := newBasicRegistry()
+		defer server.Close()
+		setRegistryPassword(t, testRegistryPassword)
+
+		client, err := newRegistryClient(context.Background(), server.URL, RegistrySpec{Username: testRegistryUser, PasswordEnv: defaultRegistryPasswordVar})
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if got := client.ping(context.Background()); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+	})
+
+	t.Run("must return error on a basic challenge without credentials", func(t *testing.T) {
+		server := newBasicRegistry()
+		defer server.Close()
+
+		client, err := newRegistryClient(context.Background(), server.URL, RegistrySpec{})
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		client.credentials = nil
+		expect := fmt.Sprintf("registry %s needs credentials", server.URL)
+		if got := client.ping(context.Background()); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must return error on a basic challenge with wrong credentials", func(t *testing.T) {
+		server := newBasicRegistry()
+		defer server.Close()
+		setRegistryPassword(t, "wrong")
+
+		client, _ := newRegistryClient(context.Background(), server.URL, RegistrySpec{Username: testRegistryUser, PasswordEnv: defaultRegistryPasswordVar})
+		expect := "status is 401"
+		if got := client.ping(context.Background()); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must answer a bearer challenge with a token of the token service", func(t *testing.T) {
+		tokens := 0
+		server, tokenService := newTokenRegistry(&tokens)
+		defer server.Close()
+		defer tokenService.Close()
+		config := writeDockerConfig(t, fmt.Sprintf(`{"auths": {"%s": {"auth": "%s"}}}`,
+			registryHost(server.URL), strings.TrimPrefix(testBasicAuth(), "Basic ")))
+
+		client, err := newRegistryClient(context.Background(), server.URL, RegistrySpec{DockerConfig: config})
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		for i := 0; i < 2; i++ {
+			if got := client.ping(context.Background()); got != nil {
+				t.Fatalf("Got error %v, expect nil", got)
+			}
+		}
+		if tokens != 1 {
+			t.Fatalf("Got %d tokens, expect the token reused", tokens)
+		}
+	})
+
+	t.Run("must return error when the token service rejects the credentials", func(t *testing.T) {
+		tokens := 0
+		server, tokenService := newTokenRegistry(&tokens)
+		defer server.Close()
+		defer tokenService.Close()
+
+		client, err := newRegistryClient(context.Background(), server.URL, RegistrySpec{})
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		client.credentials = nil
+		expect := "error getting registry token, status is 401"
+		if got := client.ping(context.Background()); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must verify the registry certificate with the CA of the spec", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+		caCert := filepath.Join(tempDir(t), "ca.pem")
+		certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		if err := ioutil.WriteFile(caCert, certificate, 0644); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+
+		client, _ := newRegistryClient(context.Background(), server.URL, RegistrySpec{})
+		if got := client.ping(context.Background()); got == nil || !strings.Contains(got.Error(), "certificate") {
+			t.Fatalf("Got error %v, expect a certificate error", got)
+		}
+		client, err := newRegistryClient(context.Background(), server.URL, RegistrySpec{CACert: caCert})
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if got := client.ping(context.Background()); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+	})
+
+	t.Run("must not verify the registry certificate when insecure", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		client, _ := newRegistryClient(context.Background(), server.URL, RegistrySpec{Insecure: true})
+		if got := client.ping(context.Background()); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+	})
+
+	t.Run("must return error when the CA has no certificates", func(t *testing.T) {
+		caCert := filepath.Join(tempDir(t), "ca.pem")
+		_ = ioutil.WriteFile(caCert, []byte("not a certificate"), 0644)
+
+		expect := fmt.Sprintf("no certificates found in registry CA %q", caCert)
+		if _, got := newRegistryClient(context.Background(), "https://localhost", RegistrySpec{CACert: caCert}); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
+
+func Test_findRegistryCredentials(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass:word"))
+
+	t.Run("must use the credentials of the spec", func(t *testing.T) {
+		setRegistryPassword(t, testRegistryPassword)
+		expect := registryCredentials{username: testRegistryUser, password: testRegistryPassword, fromSpec: true}
+		got, gotErr := findRegistryCredentials(context.Background(), "localhost:5000", RegistrySpec{Username: testRegistryUser, PasswordEnv: defaultRegistryPasswordVar})
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if *got != expect {
+			t.Fatalf("Got %+v, expect %+v", *got, expect)
+		}
+	})
+
+	t.Run("must return error when the password variable does not exist", func(t *testing.T) {
+		expect := "error getting registry password, variable TEST_NOT_EXISTING_PASSWORD does not exist"
+		_, got := findRegistryCredentials(context.Background(), "localhost:5000", RegistrySpec{Username: testRegistryUser, PasswordEnv: "TEST_NOT_EXISTING_PASSWORD"})
+		if got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must use the auth of the docker config", func(t *testing.T) {
+		config := writeDockerConfig(t, `{"auths": {"https://registry.example.com/v1/": {"auth": "`+auth+`"}, "localhost:5000": {"auth": "other"}}}`)
+		expect := registryCredentials{username: "user", password: "pass:word"}
+		got, gotErr := findRegistryCredentials(context.Background(), "registry.example.com", RegistrySpec{DockerConfig: config})
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got == nil || *got != expect {
+			t.Fatalf("Got %+v, expect %+v", got, expect)
+		}
+	})
+
+	t.Run("must use the credential helper of the docker config", func(t *testing.T) {
+		dir := tempDir(t)
+		helper := filepath.Join(dir, credentialHelperPrefix+"test")
+		script := "#!/bin/sh\nread host\necho \"{\\\"Username\\\": \\\"helper\\\", \\\"Secret\\\": \\\"$host\\\"}\"\n"
+		if err := ioutil.WriteFile(helper, []byte(script), 0755); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		path := os.Getenv("PATH")
+		os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+		defer os.Setenv("PATH", path)
+		config := writeDockerConfig(t, `{"credHelpers": {"registry.example.com": "test"}}`)
+
+		expect := registryCredentials{username: "helper", password: "registry.example.com"}
+		got, gotErr := findRegistryCredentials(context.Background(), "registry.example.com", RegistrySpec{DockerConfig: config})
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got == nil || *got != expect {
+			t.Fatalf("Got %+v, expect %+v", got, expect)
+		}
+	})
+
+	t.Run("must return no credentials when the registry is not in the docker config", func(t *testing.T) {
+		config := writeDockerConfig(t, `{"auths": {"localhost:5000": {"auth": "`+auth+`"}}}`)
+		got, gotErr := findRegistryCredentials(context.Background(), "registry.example.com", RegistrySpec{DockerConfig: config})
+		if gotErr != nil || got != nil {
+			t.Fatalf("Got %v and error %v, expect nil", got, gotErr)
+		}
+	})
+
+	t.Run("must return error when the docker config of the spec does not exist", func(t *testing.T) {
+		expect := "error reading docker config \"not-existing.json\""
+		_, got := findRegistryCredentials(context.Background(), "registry.example.com", RegistrySpec{DockerConfig: "not-existing.json"})
+		if got == nil || !strings.HasPrefix(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
+
+func Test_pushConfigDir(t *testing.T) {
+	t.Run("must write the credentials of the spec", func(t *testing.T) {
+		client := &registryClient{url: "https://registry.example.com", credentials: &registryCredentials{username: testRegistryUser, password: testRegistryPassword, fromSpec: true}}
+		dir, gotErr := client.pushConfigDir()
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		defer removeDir(dir)
+		expect := fmt.Sprintf(`{"auths":{"registry.example.com":{"auth":%q}}}`, strings.TrimPrefix(testBasicAuth(), "Basic "))
+		if got := readFile(t, filepath.Join(dir, "config.json")); got != expect {
+			t.Fatalf("Got %q, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must not write the credentials of the docker config", func(t *testing.T) {
+		client := &registryClient{url: "https://registry.example.com", credentials: &registryCredentials{username: testRegistryUser}}
+		if dir, gotErr := client.pushConfigDir(); dir != "" || gotErr != nil {
+			t.Fatalf("Got %q and error %v, expect no dir", dir, gotErr)
+		}
+	})
+}
+
+func Test_manifestDigest(t *testing.T) {
+	manifest := `{"schemaVersion": 2}`
+	type TestCase struct {
+		name    string
+		handler http.HandlerFunc
+		expect  string
+		err     string
+	}
+
+	cases := []TestCase{
+		{
+			name: "must return the digest of the registry",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead || r.URL.Path != "/v2/pets/cluster-job/manifests/latest" ||
+					!strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Docker-Content-Digest", testImageDigest)
+			},
+			expect: testImageDigest,
+		},
+		{
+			name: "must return the digest of the manifest when the registry does not send it",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(manifest))
+			},
+			expect: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(manifest))),
+		},
+		{
+			name: "must return error when the image does not exist",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			err: "error getting manifest of pets/cluster-job:latest, status is 404",
+		},
+		{
+			name: "must return error when the digest is not sha256",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Docker-Content-Digest", "md5:abc")
+			},
+			err: "unsupported digest \"md5:abc\" of pets/cluster-job:latest",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			client := &registryClient{url: server.URL, client: server.Client()}
+
+			got, gotErr := client.manifestDigest(context.Background(), client.repository(registryHost(server.URL)+"/pets/cluster-job"), "latest")
+			if tt.err != "" {
+				if gotErr == nil || gotErr.Error() != tt.err {
+					t.Fatalf("Got error %v, expect %q", gotErr, tt.err)
+				}
+				return
+			}
+			if gotErr != nil {
+				t.Fatalf("Got error %v, expect nil", gotErr)
+			}
+			if got != tt.expect {
+				t.Fatalf("Got %q, expect %q", got, tt.expect)
+			}
+		})
+	}
+
+	t.Run("must get the digest with a token of the token service", func(t *testing.T) {
+		tokens := 0
+		server, tokenService := newTokenRegistry(&tokens)
+		defer server.Close()
+		defer tokenService.Close()
+		client := &registryClient{url: server.URL, client: server.Client(),
+			credentials: &registryCredentials{username: testRegistryUser, password: testRegistryPassword}}
+
+		got, gotErr := client.manifestDigest(context.Background(), "pets/cluster-job", "latest")
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if expect := fmt.Sprintf("sha256:%x", sha256.Sum256(nil)); got != expect {
+			t.Fatalf("Got %q, expect %q", got, expect)
+		}
+	})
+}
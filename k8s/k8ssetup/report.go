@@ -0,0 +1,343 @@
+package k8ssetup
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// StepStatus is how a step of a run ended
+type StepStatus string
+
+const (
+	// StatusCreated is a step that created, updated or deleted something in the cluster
+	StatusCreated StepStatus = "created"
+	// StatusAlreadyPresent is a step that found everything as the spec wants it
+	StatusAlreadyPresent StepStatus = "already-present"
+	// StatusSkipped is a step that the spec or the options do not need, or that a failed step it needs stopped
+	StatusSkipped StepStatus = "skipped"
+	// StatusFailed is a step that returned an error
+	StatusFailed StepStatus = "failed"
+)
+
+// actions of a step on a resource
+const (
+	resourceCreated = "created"
+	resourceUpdated = "updated"
+	resourceDeleted = "deleted"
+	resourcePresent = "present"
+)
+
+// outputs of the initialize step that name the cluster of the run
+const (
+	contextOutput = "context"
+	serverOutput  = "server"
+)
+
+const (
+	// TableReport writes the report as a table for humans
+	TableReport = "table"
+	// JSONReport writes the report as a JSON object
+	JSONReport = "json"
+	// JUnitReport writes the report as JUnit XML, a test case per step
+	JUnitReport = "junit"
+)
+
+// Resource is something of the cluster that a step touched
+type Resource struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+func (r Resource) String() string {
+	return fmt.Sprintf("%s (%s)", r.Name, r.Action)
+}
+
+// StepResult is what a step of a run did
+type StepResult struct {
+	Name      string        `json:"name"`
+	Component string        `json:"component,omitempty"`
+	Status    StepStatus    `json:"status"`
+	Duration  time.Duration `json:"-"`
+	Resources []Resource    `json:"resources,omitempty"`
+	// Outputs are what later steps or runs need, like the cluster, the job or the image digest
+	Outputs map[string]string `json:"outputs,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// MarshalJSON writes the duration in seconds
+func (s StepResult) MarshalJSON() ([]byte, error) {
+	type stepResult StepResult
+	return json.Marshal(struct {
+		stepResult
+		Duration float64 `json:"duration"`
+	}{stepResult(s), s.Duration.Seconds()})
+}
+
+func (s StepResult) title() string {
+	if s.Component == "" {
+		return s.Name
+	}
+	return s.Name + " " + s.Component
+}
+
+// Report has the result of every step of a run in order
+type Report struct {
+	mu    sync.Mutex
+	steps []StepResult
+	now   func() time.Time
+}
+
+type stepRecordKey struct{}
+
+// stepRecord is where the resources and the outputs of a running step are recorded
+type stepRecord struct {
+	mu        sync.Mutex
+	resources []Resource
+	outputs   map[string]string
+}
+
+// NewReport returns an empty report
+func NewReport() *Report {
+	return &Report{now: time.Now}
+}
+
+// Steps returns the results in the order the steps ended
+func (r *Report) Steps() []StepResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]StepResult(nil), r.steps...)
+}
+
+// Failed returns true when a step failed
+func (r *Report) Failed() bool {
+	for _, v := range r.Steps() {
+		if v.Status == StatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs a step and adds its result, the step records the resources it touches and its outputs in its context
+func (r *Report) Run(ctx context.Context, name, component string, step func(ctx context.Context) error) (StepResult, error) {
+	record := &stepRecord{}
+	start := r.now()
+	err := step(context.WithValue(ctx, stepRecordKey{}, record))
+
+	result := StepResult{Name: name, Component: component, Status: StatusAlreadyPresent, Duration: r.now().Sub(start)}
+	record.mu.Lock()
+	result.Resources = append(result.Resources, record.resources...)
+	result.Outputs = record.outputs
+	record.mu.Unlock()
+	for _, v := range result.Resources {
+		if v.Action != resourcePresent {
+			result.Status = StatusCreated
+		}
+	}
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+	r.add(result)
+	return result, err
+}
+
+// Skip adds a step that the run does not need
+func (r *Report) Skip(name, component string) {
+	r.add(StepResult{Name: name, Component: component, Status: StatusSkipped})
+}
+
+func (r *Report) add(result StepResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.steps = append(r.steps, result)
+}
+
+// recordResource adds a resource to the step of the context, nothing is recorded out of a report
+func recordResource(ctx context.Context, name, action string) {
+	record, ok := ctx.Value(stepRecordKey{}).(*stepRecord)
+	if !ok {
+		return
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	record.resources = append(record.resources, Resource{Name: name, Action: action})
+}
+
+// recordOutput sets an output of the step of the context, nothing is recorded out of a report
+func recordOutput(ctx context.Context, key, value string) {
+	record, ok := ctx.Value(stepRecordKey{}).(*stepRecord)
+	if !ok {
+		return
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	if record.outputs == nil {
+		record.outputs = map[string]string{}
+	}
+	record.outputs[key] = value
+}
+
+// cluster returns the kube context and the server that the run recorded, empty before the run uses a cluster
+func (r *Report) cluster() (name, server string) {
+	for _, v := range r.Steps() {
+		if name = v.Outputs[contextOutput]; name != "" {
+			return name, v.Outputs[serverOutput]
+		}
+	}
+	return "", ""
+}
+
+// Write writes the report in the table, json or junit format
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case TableReport:
+		return r.WriteTable(w)
+	case JSONReport:
+		return r.WriteJSON(w)
+	case JUnitReport:
+		return r.WriteJUnit(w)
+	}
+	return fmt.Errorf("unsupported report format %q, expect %q, %q or %q", format, TableReport, JSONReport, JUnitReport)
+}
+
+// WriteTable writes the cluster of the run and a row per step with its status, duration, resources and error
+func (r *Report) WriteTable(w io.Writer) error {
+	if name, server := r.cluster(); name != "" {
+		if _, err := fmt.Fprintf(w, "Cluster: %q at %s\n", name, server); err != nil {
+			return err
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tCOMPONENT\tSTATUS\tDURATION\tRESOURCES\tERROR")
+	for _, v := range r.Steps() {
+		resources := make([]string, len(v.Resources))
+		for i, resource := range v.Resources {
+			resources[i] = resource.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Name, orDash(v.Component), v.Status,
+			v.Duration.Round(time.Millisecond), orDash(strings.Join(resources, ", ")), orDash(v.Error))
+	}
+	return tw.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+type reportCluster struct {
+	Context string `json:"context"`
+	Server  string `json:"server"`
+}
+
+// WriteJSON writes the cluster, the steps and whether the run failed
+func (r *Report) WriteJSON(w io.Writer) error {
+	steps := r.Steps()
+	if steps == nil {
+		steps = []StepResult{}
+	}
+	var cluster *reportCluster
+	if name, server := r.cluster(); name != "" {
+		cluster = &reportCluster{Context: name, Server: server}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Cluster *reportCluster `json:"cluster,omitempty"`
+		Failed  bool           `json:"failed"`
+		Steps   []StepResult   `json:"steps"`
+	}{cluster, r.Failed(), steps})
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Cases      []junitTestCase  `xml:"testcase"`
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes a test suite with a test case per step, so a CI shows the set up as test results, the
+// cluster of the run is in the properties of the suite
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suite := junitTestSuite{Name: "pets-infrastructure"}
+	if name, server := r.cluster(); name != "" {
+		suite.Properties = &junitProperties{Properties: []junitProperty{{contextOutput, name}, {serverOutput, server}}}
+	}
+	var total time.Duration
+	for _, v := range r.Steps() {
+		testCase := junitTestCase{Name: v.title(), ClassName: "pets-infrastructure." + v.Name, Time: junitSeconds(v.Duration)}
+		switch v.Status {
+		case StatusFailed:
+			suite.Failures++
+			testCase.Failure = &junitFailure{Message: v.Error, Text: v.Error}
+		case StatusSkipped:
+			suite.Skipped++
+			testCase.Skipped = &struct{}{}
+		}
+		lines := []string{"status: " + string(v.Status)}
+		for _, resource := range v.Resources {
+			lines = append(lines, resource.String())
+		}
+		testCase.SystemOut = strings.Join(lines, "\n")
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+		total += v.Duration
+	}
+	suite.Time = junitSeconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,201 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestReport returns a report whose steps last 500ms
+func newTestReport() *Report {
+	report := NewReport()
+	var now time.Time
+	report.now = func() time.Time {
+		now = now.Add(500 * time.Millisecond)
+		return now
+	}
+	return report
+}
+
+func newFilledReport() *Report {
+	report := newTestReport()
+	_, _ = report.Run(context.Background(), "namespace", "pets", func(ctx context.Context) error {
+		recordResource(ctx, "namespace/pets", resourcePresent)
+		return nil
+	})
+	_, _ = report.Run(context.Background(), "database", "pets-db.yml", func(ctx context.Context) error {
+		recordResource(ctx, "postgresql/pets-db", resourceCreated)
+		recordResource(ctx, "job/pets-db-job-x1", resourceCreated)
+		return nil
+	})
+	report.Skip("kudo", "")
+	_, _ = report.Run(context.Background(), "kafka-topics", "pets", func(ctx context.Context) error {
+		return errors.New("topic \"a\" has 3 partitions, expect 1")
+	})
+	return report
+}
+
+func Test_ReportRun(t *testing.T) {
+	t.Run("must set the status from the resources of the step", func(t *testing.T) {
+		report := newFilledReport()
+		var got []StepStatus
+		for _, v := range report.Steps() {
+			got = append(got, v.Status)
+		}
+		expect := []StepStatus{StatusAlreadyPresent, StatusCreated, StatusSkipped, StatusFailed}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+		if !report.Failed() {
+			t.Fatalf("Got not failed, expect failed")
+		}
+	})
+
+	t.Run("must return the error of the step", func(t *testing.T) {
+		expect := errors.New("forbidden")
+		if _, got := newTestReport().Run(context.Background(), "namespace", "", func(ctx context.Context) error {
+			return expect
+		}); got != expect {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must record the resources of the set up steps", func(t *testing.T) {
+		k8sImpl := NewK8sSetUpWithSpec(DefaultSpec(), Options{}).(*k8sSetUpImpl)
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "describe" {
+				return "", errors.New("not found")
+			}
+			return "namespace/default created", nil
+		}
+		report := newTestReport()
+		if _, err := report.Run(context.Background(), "namespace", "default", k8sImpl.NamespaceCreation); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+
+		expect := []Resource{{Name: "namespace/default", Action: resourceCreated}}
+		if got := report.Steps()[0]; got.Status != StatusCreated || !reflect.DeepEqual(got.Resources, expect) {
+			t.Fatalf("Got %+v, expect created with %v", got, expect)
+		}
+	})
+
+	t.Run("must record the outputs of the step", func(t *testing.T) {
+		got, _ := newTestReport().Run(context.Background(), "database", "pets-db.yml", func(ctx context.Context) error {
+			recordOutput(ctx, "cluster", "pets-db")
+			recordOutput(ctx, "job", "pets-db-job-x1")
+			return nil
+		})
+		if expect := map[string]string{"cluster": "pets-db", "job": "pets-db-job-x1"}; !reflect.DeepEqual(got.Outputs, expect) {
+			t.Fatalf("Got %v, expect %v", got.Outputs, expect)
+		}
+	})
+
+	t.Run("must not record out of a report", func(t *testing.T) {
+		recordResource(context.Background(), "namespace/pets", resourceCreated)
+		recordOutput(context.Background(), "namespace", "pets")
+	})
+}
+
+func Test_ReportWrite(t *testing.T) {
+	t.Run("must write a table", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := newFilledReport().Write(&out, TableReport); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		expect := strings.Join([]string{
+			"STEP          COMPONENT    STATUS           DURATION  RESOURCES                                                   ERROR",
+			"namespace     pets         already-present  500ms     namespace/pets (present)                                    -",
+			"database      pets-db.yml  created          500ms     postgresql/pets-db (created), job/pets-db-job-x1 (created)  -",
+			"kudo          -            skipped          0s        -                                                           -",
+			"kafka-topics  pets         failed           500ms     -                                                           topic \"a\" has 3 partitions, expect 1",
+			"",
+		}, "\n")
+		if got := out.String(); got != expect {
+			t.Fatalf("Got\n%s\nexpect\n%s", got, expect)
+		}
+	})
+
+	t.Run("must write json", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := newFilledReport().Write(&out, JSONReport); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		var got struct {
+			Failed bool `json:"failed"`
+			Steps  []struct {
+				Name      string     `json:"name"`
+				Status    string     `json:"status"`
+				Duration  float64    `json:"duration"`
+				Resources []Resource `json:"resources"`
+				Error     string     `json:"error"`
+			} `json:"steps"`
+		}
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatalf("Got error %v, expect json", err)
+		}
+		if !got.Failed || len(got.Steps) != 4 || got.Steps[1].Status != "created" || got.Steps[1].Duration != 0.5 ||
+			len(got.Steps[1].Resources) != 2 || got.Steps[3].Error == "" {
+			t.Fatalf("Got %+v, expect the four steps", got)
+		}
+	})
+
+	t.Run("must write junit xml", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := newFilledReport().Write(&out, JUnitReport); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		var got junitTestSuites
+		if err := xml.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatalf("Got error %v, expect xml", err)
+		}
+		suite := got.Suites[0]
+		if suite.Tests != 4 || suite.Failures != 1 || suite.Skipped != 1 || suite.Time != "1.500" {
+			t.Fatalf("Got %+v, expect 4 tests, a failure and a skipped", suite)
+		}
+		if suite.Cases[1].Name != "database pets-db.yml" || suite.Cases[2].Skipped == nil ||
+			suite.Cases[3].Failure == nil || suite.Cases[3].Failure.Message != "topic \"a\" has 3 partitions, expect 1" {
+			t.Fatalf("Got %+v, expect the cases of the steps", suite.Cases)
+		}
+	})
+
+	t.Run("must write the cluster of the run", func(t *testing.T) {
+		report := newTestReport()
+		_, _ = report.Run(context.Background(), "initialize", "", func(ctx context.Context) error {
+			recordOutput(ctx, contextOutput, "dev")
+			recordOutput(ctx, serverOutput, "https://dev.example.com:6443")
+			return nil
+		})
+
+		var table, jsonOut, junit bytes.Buffer
+		for format, out := range map[string]*bytes.Buffer{TableReport: &table, JSONReport: &jsonOut, JUnitReport: &junit} {
+			if err := report.Write(out, format); err != nil {
+				t.Fatalf("Got error %v, expect nil", err)
+			}
+		}
+		if expect := "Cluster: \"dev\" at https://dev.example.com:6443\nSTEP"; !strings.HasPrefix(table.String(), expect) {
+			t.Fatalf("Got\n%s\nexpect the cluster before the steps", table.String())
+		}
+		if expect := `"cluster": {
+    "context": "dev",
+    "server": "https://dev.example.com:6443"
+  }`; !strings.Contains(jsonOut.String(), expect) {
+			t.Fatalf("Got %s, expect %s", jsonOut.String(), expect)
+		}
+		if expect := `<property name="context" value="dev"></property>`; !strings.Contains(junit.String(), expect) {
+			t.Fatalf("Got %s, expect %s", junit.String(), expect)
+		}
+	})
+
+	t.Run("must return error on an unknown format", func(t *testing.T) {
+		expect := "unsupported report format \"html\", expect \"table\", \"json\" or \"junit\""
+		if got := NewReport().Write(&bytes.Buffer{}, "html"); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
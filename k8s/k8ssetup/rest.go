@@ -0,0 +1,612 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	fieldManager = "pets-go-infra"
+)
+
+// apiResource is where a kind lives in the API server
+type apiResource struct {
+	kind       string
+	group      string
+	version    string
+	resource   string
+	namespaced bool
+}
+
+var (
+	apiResources = []apiResource{
+		{kind: "pod", version: "v1", resource: "pods", namespaced: true},
+		{kind: "service", version: "v1", resource: "services", namespaced: true},
+		{kind: "secret", version: "v1", resource: "secrets", namespaced: true},
+		{kind: "configmap", version: "v1", resource: "configmaps", namespaced: true},
+		{kind: "serviceaccount", version: "v1", resource: "serviceaccounts", namespaced: true},
+		{kind: "persistentvolumeclaim", version: "v1", resource: "persistentvolumeclaims", namespaced: true},
+		{kind: "namespace", version: "v1", resource: "namespaces"},
+		{kind: "node", version: "v1", resource: "nodes"},
+		{kind: "deployment", group: "apps", version: "v1", resource: "deployments", namespaced: true},
+		{kind: "statefulset", group: "apps", version: "v1", resource: "statefulsets", namespaced: true},
+		{kind: "job", group: "batch", version: "v1", resource: "jobs", namespaced: true},
+		{kind: "role", group: "rbac.authorization.k8s.io", version: "v1", resource: "roles", namespaced: true},
+		{kind: "rolebinding", group: "rbac.authorization.k8s.io", version: "v1", resource: "rolebindings", namespaced: true},
+		{kind: "clusterrole", group: "rbac.authorization.k8s.io", version: "v1", resource: "clusterroles"},
+		{kind: "clusterrolebinding", group: "rbac.authorization.k8s.io", version: "v1", resource: "clusterrolebindings"},
+		{kind: "storageclass", group: "storage.k8s.io", version: "v1", resource: "storageclasses"},
+		{kind: "customresourcedefinition", group: "apiextensions.k8s.io", version: "v1", resource: "customresourcedefinitions"},
+		{kind: "postgresql", group: "acid.zalan.do", version: "v1", resource: "postgresqls", namespaced: true},
+		{kind: "operatorconfiguration", group: "acid.zalan.do", version: "v1", resource: "operatorconfigurations", namespaced: true},
+	}
+	kindAliases = map[string]string{
+		"po":  "pod",
+		"svc": "service",
+		"cm":  "configmap",
+		"sa":  "serviceaccount",
+		"pvc": "persistentvolumeclaim",
+		"ns":  "namespace",
+		"pg":  "postgresql",
+	}
+)
+
+// findAPIResource returns the resource of a kind, kinds could be like kubectl ones as "pvc" or "job.batch"
+func findAPIResource(kind string) (apiResource, error) {
+	kind = strings.ToLower(kind)
+	if i := strings.Index(kind, "."); i != -1 {
+		kind = kind[:i]
+	}
+	if alias, ok := kindAliases[kind]; ok {
+		kind = alias
+	}
+	for _, v := range apiResources {
+		if v.kind == kind || v.resource == kind {
+			return v, nil
+		}
+	}
+	return apiResource{}, fmt.Errorf("unknown resource kind %q", kind)
+}
+
+func (r apiResource) path(namespace, name string) string {
+	path := "/api/" + r.version
+	if r.group != "" {
+		path = "/apis/" + r.group + "/" + r.version
+	}
+	if r.namespaced {
+		path += "/namespaces/" + namespace
+	}
+	path += "/" + r.resource
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+func (r apiResource) ref(name string) string {
+	if r.group == "" {
+		return r.kind + "/" + name
+	}
+	return r.kind + "." + r.group + "/" + name
+}
+
+// apiError is a failed request to the API server
+type apiError struct {
+	method  string
+	path    string
+	code    int
+	message string
+}
+
+func (e apiError) Error() string {
+	return fmt.Sprintf("error in %s %s, status is %d: %s", e.method, e.path, e.code, e.message)
+}
+
+func isNotFound(err error) bool {
+	var apiErr apiError
+	return errors.As(err, &apiErr) && apiErr.code == http.StatusNotFound
+}
+
+// restClient talks with the API server, kudo has no API so it runs the kubectl-kudo plugin, the specs with kafka
+// topics or kudo are not supported without kubectl
+type restClient struct {
+	config      *restConfig
+	client      *http.Client
+	plan        *Plan
+	kudoCommand func(ctx context.Context, params ...string) (string, error)
+}
+
+func newRestClient(config *restConfig, plan *Plan, kudoCommand func(ctx context.Context, params ...string) (string, error)) *restClient {
+	return &restClient{
+		config:      config,
+		client:      &http.Client{Transport: &http.Transport{TLSClientConfig: config.tlsConfig, Proxy: http.ProxyFromEnvironment}},
+		plan:        plan,
+		kudoCommand: kudoCommand,
+	}
+}
+
+// do sends a json request, in plan mode the mutating requests are recorded instead of sent
+func (c restClient) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	return c.send(ctx, method, path, "application/json", body, result)
+}
+
+func (c restClient) send(ctx context.Context, method, path, contentType string, body interface{}, result interface{}) error {
+	if c.plan != nil && method != http.MethodGet {
+		step := fmt.Sprintf("%s %s", method, path)
+		logger.Infof(ctx, "Plan: %s", step)
+		c.plan.record(step)
+		return nil
+	}
+
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding request to %s: %v", path, err)
+		}
+		reader = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.config.server+path, reader)
+	if err != nil {
+		return fmt.Errorf("error creating request to %s: %v", path, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.config.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.token)
+	} else if c.config.username != "" {
+		req.SetBasicAuth(c.config.username, c.config.password)
+	}
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error in %s %s: %v", method, path, err)
+	}
+	logCtx := WithLogField(ctx, commandField, method+" "+path)
+	logger.Debugf(WithLogField(logCtx, durationField, time.Since(start).Round(time.Millisecond)), "%s", resp.Status)
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response of %s %s: %v", method, path, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		status := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(content, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(content))
+		}
+		return apiError{method: method, path: path, code: resp.StatusCode, message: status.Message}
+	}
+	if text, ok := result.(*string); ok {
+		*text = string(content)
+	} else if result != nil {
+		if err = json.Unmarshal(content, result); err != nil {
+			return fmt.Errorf("invalid json in %s %s: %v", method, path, err)
+		}
+	}
+	return nil
+}
+
+func (c restClient) listResources(ctx context.Context, kind, selector, namespace string) (names []string, err error) {
+	var resource apiResource
+	if resource, err = findAPIResource(kind); err != nil {
+		return nil, err
+	}
+	path := resource.path(namespace, "")
+	if selector != "" {
+		path += "?labelSelector=" + url.QueryEscape(selector)
+	}
+	list := struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}{}
+	if err = c.do(ctx, http.MethodGet, path, nil, &list); err != nil {
+		return nil, err
+	}
+	for _, v := range list.Items {
+		names = append(names, resource.ref(v.Metadata.Name))
+	}
+	return
+}
+
+func (c restClient) resourceExists(ctx context.Context, kind, name, namespace string) (bool, error) {
+	resource, err := findAPIResource(kind)
+	if err != nil {
+		return false, err
+	}
+	if err = c.do(ctx, http.MethodGet, resource.path(namespace, name), nil, nil); err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (c restClient) resolveRef(ref string) (apiResource, string, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return apiResource{}, "", fmt.Errorf("invalid resource %q, expect kind/name", ref)
+	}
+	resource, err := findAPIResource(parts[0])
+	return resource, parts[1], err
+}
+
+// getField returns the value in a path like ".status.containerStatuses[0].ready" or
+// ".status.conditions[?(@.type=="Failed")].reason", empty when it does not exist
+func (c restClient) getField(ctx context.Context, ref, namespace, path string) (string, error) {
+	resource, name, err := c.resolveRef(ref)
+	if err != nil {
+		return "", err
+	}
+	var object interface{}
+	if err = c.do(ctx, http.MethodGet, resource.path(namespace, name), nil, &object); err != nil {
+		return "", err
+	}
+	return fieldValue(object, path)
+}
+
+// filterRegex is the only kind of jsonpath filter that we support, an equality like [?(@.type=="Failed")]
+var filterRegex = regexp.MustCompile(`^\[\?\(@\.([A-Za-z0-9_]+)=="([^"]*)"\)\]$`)
+
+// splitPath splits a path in its fields, keeping the dots of the filters
+func splitPath(path string) (parts []string) {
+	depth, start := 0, 0
+	for i, r := range path {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.':
+			if depth == 0 {
+				if i > start {
+					parts = append(parts, path[start:i])
+				}
+				start = i + 1
+			}
+		}
+	}
+	if start < len(path) {
+		parts = append(parts, path[start:])
+	}
+	return
+}
+
+func (c restClient) getObject(ctx context.Context, ref, namespace string) (object map[string]interface{}, err error) {
+	var resource apiResource
+	var name string
+	if resource, name, err = c.resolveRef(ref); err != nil {
+		return nil, err
+	}
+	err = c.do(ctx, http.MethodGet, resource.path(namespace, name), nil, &object)
+	return
+}
+
+func fieldValue(object interface{}, path string) (string, error) {
+	value := object
+	for _, part := range splitPath(path) {
+		selector := ""
+		if i := strings.Index(part, "["); i != -1 && strings.HasSuffix(part, "]") {
+			part, selector = part[:i], part[i:]
+		}
+		if part != "" {
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				return "", nil
+			}
+			value = fields[part]
+		}
+		if selector == "" {
+			continue
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return "", nil
+		}
+		if match := filterRegex.FindStringSubmatch(selector); match != nil {
+			value = nil
+			for _, item := range items {
+				if fields, ok := item.(map[string]interface{}); ok && fmt.Sprint(fields[match[1]]) == match[2] {
+					value = item
+					break
+				}
+			}
+			continue
+		}
+		index, err := strconv.Atoi(selector[1 : len(selector)-1])
+		if err != nil {
+			return "", fmt.Errorf("invalid path %q: %v", path, err)
+		}
+		if index < 0 || index >= len(items) {
+			return "", nil
+		}
+		value = items[index]
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, float64:
+		return fmt.Sprint(v), nil
+	default:
+		content, err := json.Marshal(v)
+		return string(content), err
+	}
+}
+
+// manifestObject is a document of a manifest file
+type manifestObject struct {
+	APIVersion string                 `yaml:"apiVersion" json:"apiVersion"`
+	Kind       string                 `yaml:"kind" json:"kind"`
+	Metadata   map[string]interface{} `yaml:"metadata" json:"metadata"`
+	Fields     map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+func (o manifestObject) metadata(field string) string {
+	value, _ := o.Metadata[field].(string)
+	return value
+}
+
+// resource returns where the object lives, the group and version are the ones of the manifest
+func (o manifestObject) resource() (apiResource, error) {
+	resource, err := findAPIResource(o.Kind)
+	if err != nil {
+		return resource, err
+	}
+	if i := strings.LastIndex(o.APIVersion, "/"); i != -1 {
+		resource.group, resource.version = o.APIVersion[:i], o.APIVersion[i+1:]
+	} else if o.APIVersion != "" {
+		resource.group, resource.version = "", o.APIVersion
+	}
+	return resource, nil
+}
+
+// namespace returns where the object goes, like kubectl it fails when the manifest says another namespace
+func (o manifestObject) namespace(namespace string) (string, error) {
+	if value := o.metadata("namespace"); value != "" && value != namespace {
+		return "", fmt.Errorf("the namespace of %s %q is %q, expect %q", o.Kind, o.metadata("name"), value, namespace)
+	}
+	return namespace, nil
+}
+
+// path returns where the object lives, the API server sets the namespace of the path in the object
+func (o manifestObject) path(namespace string, withName bool) (string, error) {
+	resource, err := o.resource()
+	if err != nil {
+		return "", err
+	}
+	if resource.namespaced {
+		if namespace, err = o.namespace(namespace); err != nil {
+			return "", err
+		}
+	}
+	name := ""
+	if withName {
+		name = o.metadata("name")
+	}
+	return resource.path(namespace, name), nil
+}
+
+func (o manifestObject) body() map[string]interface{} {
+	body := map[string]interface{}{}
+	for k, v := range o.Fields {
+		body[k] = v
+	}
+	body["apiVersion"] = o.APIVersion
+	body["kind"] = o.Kind
+	body["metadata"] = o.Metadata
+	return body
+}
+
+func readManifest(fileName string) (objects []manifestObject, err error) {
+	var content []byte
+	if content, err = ioutil.ReadFile(fileName); err != nil {
+		return nil, fmt.Errorf("error reading manifest %q: %v", fileName, err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		object := manifestObject{}
+		if err = decoder.Decode(&object); err == io.EOF {
+			return objects, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error parsing manifest %q: %v", fileName, err)
+		}
+		if object.Kind != "" {
+			objects = append(objects, object)
+		}
+	}
+}
+
+func (c restClient) createNamespace(ctx context.Context, name string) error {
+	body := map[string]interface{}{"apiVersion": "v1", "kind": "Namespace", "metadata": map[string]string{"name": name}}
+	return c.do(ctx, http.MethodPost, "/api/v1/namespaces", body, nil)
+}
+
+func (c restClient) create(ctx context.Context, fileName, namespace string) (refs []string, err error) {
+	var objects []manifestObject
+	if objects, err = readManifest(fileName); err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		resource, err := object.resource()
+		if err != nil {
+			return refs, err
+		}
+		path, err := object.path(namespace, false)
+		if err != nil {
+			return refs, err
+		}
+		created := manifestObject{}
+		if err = c.do(ctx, http.MethodPost, path, object.body(), &created); err != nil {
+			return refs, err
+		}
+		name := created.metadata("name")
+		if name == "" {
+			name = object.metadata("name")
+		}
+		refs = append(refs, resource.ref(name))
+	}
+	return
+}
+
+// apply updates the objects of the manifest with a server side apply, json is valid yaml
+func (c restClient) apply(ctx context.Context, fileName, namespace string) error {
+	objects, err := readManifest(fileName)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		path, err := object.path(namespace, true)
+		if err != nil {
+			return err
+		}
+		path += "?fieldManager=" + fieldManager + "&force=true"
+		if err = c.send(ctx, http.MethodPatch, path, "application/apply-patch+yaml", object.body(), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var backgroundDeletion = map[string]string{"kind": "DeleteOptions", "apiVersion": "v1", "propagationPolicy": "Background"}
+
+func (c restClient) deleteFile(ctx context.Context, fileName, namespace string) error {
+	objects, err := readManifest(fileName)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		path, err := object.path(namespace, true)
+		if err != nil {
+			return err
+		}
+		if err = c.do(ctx, http.MethodDelete, path, backgroundDeletion, nil); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c restClient) deleteResource(ctx context.Context, ref, namespace string) error {
+	resource, name, err := c.resolveRef(ref)
+	if err != nil {
+		return err
+	}
+	if err = c.do(ctx, http.MethodDelete, resource.path(namespace, name), backgroundDeletion, nil); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (c restClient) deleteSelected(ctx context.Context, kind, selector, namespace string) error {
+	resource, err := findAPIResource(kind)
+	if err != nil {
+		return err
+	}
+	path := resource.path(namespace, "") + "?labelSelector=" + url.QueryEscape(selector)
+	if err = c.do(ctx, http.MethodDelete, path, backgroundDeletion, nil); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (c restClient) logs(ctx context.Context, podRef, namespace string) (output string, err error) {
+	var resource apiResource
+	var name string
+	if resource, name, err = c.resolveRef(podRef); err != nil {
+		return "", err
+	}
+	err = c.do(ctx, http.MethodGet, resource.path(namespace, name)+"/log", nil, &output)
+	return
+}
+
+// exec needs a streaming connection with the pod that we do not implement, so the specs with kafka topics need
+// the kubectl backend
+func (c restClient) exec(ctx context.Context, podRef, namespace string, command ...string) (string, error) {
+	return "", fmt.Errorf("exec in %s is not supported by the %s backend, use the %s backend", podRef, restBackend, kubectlBackend)
+}
+
+func (c restClient) kudo(ctx context.Context, params ...string) (string, error) {
+	return c.kudoCommand(ctx, params...)
+}
+
+func (c restClient) currentContext(ctx context.Context) (string, string, error) {
+	return c.config.context, c.config.server, nil
+}
+
+func (c restClient) serverVersion(ctx context.Context) (string, error) {
+	version := struct {
+		GitVersion string `json:"gitVersion"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/version", nil, &version); err != nil {
+		return "", err
+	}
+	return version.GitVersion, nil
+}
+
+// canI creates a SelfSubjectAccessReview, it changes nothing so it is sent in plan mode too. Resources are like
+// kubectl ones as "jobs.batch" or "pods/log"
+func (c restClient) canI(ctx context.Context, verb, resource, namespace string) (bool, error) {
+	attributes := map[string]interface{}{"verb": verb, "namespace": namespace}
+	if i := strings.Index(resource, "/"); i != -1 {
+		resource, attributes["subresource"] = resource[:i], resource[i+1:]
+	}
+	if i := strings.Index(resource, "."); i != -1 {
+		resource, attributes["group"] = resource[:i], resource[i+1:]
+	}
+	attributes["resource"] = resource
+
+	review := struct {
+		Status struct {
+			Allowed bool `json:"allowed"`
+		} `json:"status"`
+	}{}
+	reader := c
+	reader.plan = nil
+	if err := reader.do(ctx, http.MethodPost, "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews", map[string]interface{}{
+		"apiVersion": "authorization.k8s.io/v1",
+		"kind":       "SelfSubjectAccessReview",
+		"spec":       map[string]interface{}{"resourceAttributes": attributes},
+	}, &review); err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+func (c restClient) listObjects(ctx context.Context, kind, namespace string) ([]map[string]interface{}, error) {
+	resource, err := findAPIResource(kind)
+	if err != nil {
+		return nil, err
+	}
+	list := struct {
+		Items []map[string]interface{} `json:"items"`
+	}{}
+	if err = c.do(ctx, http.MethodGet, resource.path(namespace, ""), nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
@@ -0,0 +1,499 @@
+package k8ssetup
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const fakeToken = "test-token"
+
+// fakeAPIServer keeps the objects by path in memory like an API server would do
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	objects map[string]map[string]interface{}
+	// denied are the verb and resource of the access reviews that are not allowed, like "delete pods"
+	denied map[string]bool
+	server *httptest.Server
+}
+
+func newFakeAPIServer(t *testing.T) *fakeAPIServer {
+	fake := &fakeAPIServer{objects: map[string]map[string]interface{}{}, denied: map[string]bool{}}
+	fake.server = httptest.NewTLSServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (f *fakeAPIServer) put(path string, object map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[path] = object
+}
+
+func (f *fakeAPIServer) paths() (paths []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k := range f.objects {
+		paths = append(paths, k)
+	}
+	sort.Strings(paths)
+	return
+}
+
+func writeStatus(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"kind": "Status", "code": code, "message": message})
+}
+
+func matchesSelector(object map[string]interface{}, selector string) bool {
+	if selector == "" {
+		return true
+	}
+	metadata, _ := object["metadata"].(map[string]interface{})
+	labels, _ := metadata["labels"].(map[string]interface{})
+	parts := strings.SplitN(selector, "=", 2)
+	return len(parts) == 2 && labels[parts[0]] == parts[1]
+}
+
+func (f *fakeAPIServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+fakeToken {
+		writeStatus(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path, selector := r.URL.Path, r.URL.Query().Get("labelSelector")
+
+	switch r.Method {
+	case http.MethodGet:
+		if object, ok := f.objects[path]; ok {
+			_ = json.NewEncoder(w).Encode(object)
+			return
+		}
+		var items []map[string]interface{}
+		for k, v := range f.objects {
+			if strings.HasPrefix(k, path+"/") && !strings.Contains(k[len(path)+1:], "/") && matchesSelector(v, selector) {
+				items = append(items, v)
+			}
+		}
+		if items == nil && !strings.HasSuffix(path, "s") {
+			writeStatus(w, http.StatusNotFound, fmt.Sprintf("%s not found", path))
+			return
+		}
+		sort.Slice(items, func(i, j int) bool {
+			return fmt.Sprint(items[i]["metadata"]) < fmt.Sprint(items[j]["metadata"])
+		})
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	case http.MethodPost:
+		object := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&object); err != nil {
+			writeStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if strings.HasSuffix(path, "/selfsubjectaccessreviews") {
+			spec, _ := object["spec"].(map[string]interface{})
+			attributes, _ := spec["resourceAttributes"].(map[string]interface{})
+			allowed := !f.denied[fmt.Sprintf("%v %v", attributes["verb"], attributes["resource"])]
+			object["status"] = map[string]interface{}{"allowed": allowed}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(object)
+			return
+		}
+		metadata, _ := object["metadata"].(map[string]interface{})
+		name, _ := metadata["name"].(string)
+		if generateName, _ := metadata["generateName"].(string); name == "" && generateName != "" {
+			name = generateName + "x1"
+			metadata["name"] = name
+		}
+		if _, ok := f.objects[path+"/"+name]; ok {
+			writeStatus(w, http.StatusConflict, fmt.Sprintf("%s already exists", name))
+			return
+		}
+		f.objects[path+"/"+name] = object
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(object)
+	case http.MethodPatch:
+		if r.Header.Get("Content-Type") != "application/apply-patch+yaml" || r.URL.Query().Get("fieldManager") == "" {
+			writeStatus(w, http.StatusUnsupportedMediaType, "expect a server side apply")
+			return
+		}
+		object := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&object); err != nil {
+			writeStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.objects[path] = object
+		_ = json.NewEncoder(w).Encode(object)
+	case http.MethodDelete:
+		if _, ok := f.objects[path]; ok {
+			delete(f.objects, path)
+			writeStatus(w, http.StatusOK, "deleted")
+			return
+		}
+		if selector == "" {
+			writeStatus(w, http.StatusNotFound, fmt.Sprintf("%s not found", path))
+			return
+		}
+		for k, v := range f.objects {
+			if strings.HasPrefix(k, path+"/") && matchesSelector(v, selector) {
+				delete(f.objects, k)
+			}
+		}
+		writeStatus(w, http.StatusOK, "deleted")
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, r.Method)
+	}
+}
+
+func pemCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writeKubeconfig writes a kubeconfig that trusts the fake API server certificate
+func (f *fakeAPIServer) writeKubeconfig(t *testing.T, token string) string {
+	ca := pemCertificate(f.server.Certificate().Raw)
+	content := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+users:
+- name: test
+  user:
+    token: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+`, f.server.URL, base64.StdEncoding.EncodeToString(ca), token)
+	fileName := filepath.Join(tempDir(t), "config")
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	return fileName
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "pets-go-infra-test")
+	if err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	t.Cleanup(func() { removeDir(dir) })
+	return dir
+}
+
+func (f *fakeAPIServer) newClient(t *testing.T, plan *Plan) *restClient {
+	config, err := loadKubeconfig(f.writeKubeconfig(t, fakeToken), "")
+	if err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	return newRestClient(config, plan, func(ctx context.Context, params ...string) (string, error) {
+		return strings.Join(params, " "), nil
+	})
+}
+
+func pod(name string, ready bool) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":     "Pod",
+		"metadata": map[string]interface{}{"name": name, "labels": map[string]interface{}{"app": "test"}},
+		"status":   map[string]interface{}{"containerStatuses": []interface{}{map[string]interface{}{"ready": ready}}},
+	}
+}
+
+func Test_restClientListResources(t *testing.T) {
+	fake := newFakeAPIServer(t)
+	fake.put("/api/v1/namespaces/default/pods/test-0", pod("test-0", true))
+	fake.put("/api/v1/namespaces/default/pods/test-1", pod("test-1", false))
+	fake.put("/api/v1/namespaces/other/pods/other-0", pod("other-0", false))
+	client := fake.newClient(t, nil)
+
+	t.Run("must return the resource names like kubectl", func(t *testing.T) {
+		expect := []string{"pod/test-0", "pod/test-1"}
+		got, gotErr := client.listResources(context.Background(), "pod", "app=test", "default")
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must return error on unknown kinds", func(t *testing.T) {
+		expect := "unknown resource kind \"unicorn\""
+		_, got := client.listResources(context.Background(), "unicorn", "", "default")
+		if got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must return error when it is not authorized", func(t *testing.T) {
+		config, err := loadKubeconfig(fake.writeKubeconfig(t, "invalid"), "")
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		expect := "status is 401: Unauthorized"
+		_, got := newRestClient(config, nil, nil).listResources(context.Background(), "pod", "", "default")
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
+
+func Test_restClientGetField(t *testing.T) {
+	fake := newFakeAPIServer(t)
+	fake.put("/api/v1/namespaces/default/pods/test-0", pod("test-0", true))
+	fake.put("/apis/acid.zalan.do/v1/namespaces/default/postgresqls/cluster", map[string]interface{}{
+		"status": map[string]interface{}{"PostgresClusterStatus": "Running"},
+	})
+	fake.put("/apis/batch/v1/namespaces/default/jobs/cluster-run-x1", map[string]interface{}{
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "FailureTarget", "status": "True", "reason": "BackoffLimitExceeded"},
+			map[string]interface{}{"type": "Failed", "status": "True", "reason": "BackoffLimitExceeded"},
+		}},
+	})
+	client := fake.newClient(t, nil)
+
+	type TestCase struct {
+		name   string
+		ref    string
+		path   string
+		expect string
+	}
+
+	cases := []TestCase{
+		{name: "bool in a list", ref: "pod/test-0", path: ".status.containerStatuses[0].ready", expect: "true"},
+		{name: "missing field", ref: "pod/test-0", path: ".status.containerStatuses[0].state.waiting.reason", expect: ""},
+		{name: "index out of range", ref: "pod/test-0", path: ".status.containerStatuses[1].ready", expect: ""},
+		{name: "custom resource", ref: "postgresql.acid.zalan.do/cluster", path: ".status.PostgresClusterStatus", expect: "Running"},
+		{name: "object", ref: "postgresql/cluster", path: ".status", expect: `{"PostgresClusterStatus":"Running"}`},
+		{name: "filter", ref: "job.batch/cluster-run-x1", path: `.status.conditions[?(@.type=="Failed")].status`, expect: "True"},
+		{name: "filter without match", ref: "job.batch/cluster-run-x1", path: `.status.conditions[?(@.type=="Complete")].status`, expect: ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotErr := client.getField(context.Background(), tt.ref, "default", tt.path)
+			if gotErr != nil {
+				t.Fatalf("Got error %v, expect nil", gotErr)
+			}
+			if got != tt.expect {
+				t.Fatalf("Got %q, expect %q", got, tt.expect)
+			}
+		})
+	}
+
+	t.Run("must return error when resource does not exist", func(t *testing.T) {
+		_, got := client.getField(context.Background(), "pod/test-1", "default", ".status")
+		if !isNotFound(got) {
+			t.Fatalf("Got error %v, expect not found", got)
+		}
+	})
+}
+
+func Test_restClientResourceExists(t *testing.T) {
+	fake := newFakeAPIServer(t)
+	fake.put("/api/v1/namespaces/default/services/postgres-operator", map[string]interface{}{})
+	client := fake.newClient(t, nil)
+
+	if got, err := client.resourceExists(context.Background(), "service", "postgres-operator", "default"); err != nil || !got {
+		t.Fatalf("Got %v and error %v, expect %v", got, err, true)
+	}
+	if got, err := client.resourceExists(context.Background(), "postgresql", "cluster", "default"); err != nil || got {
+		t.Fatalf("Got %v and error %v, expect %v", got, err, false)
+	}
+}
+
+func Test_restClientCreateNamespace(t *testing.T) {
+	fake := newFakeAPIServer(t)
+	client := fake.newClient(t, nil)
+	ctx := context.Background()
+
+	if err := client.createNamespace(ctx, "pets-staging"); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	if got, err := client.resourceExists(ctx, "namespace", "pets-staging", "pets-staging"); err != nil || !got {
+		t.Fatalf("Got %v and error %v, expect %v", got, err, true)
+	}
+}
+
+func Test_restClientCreateAndDelete(t *testing.T) {
+	fake := newFakeAPIServer(t)
+	client := fake.newClient(t, nil)
+	ctx := context.Background()
+
+	t.Run("must create every document of the manifest", func(t *testing.T) {
+		expect := []string{"postgresql.acid.zalan.do/cluster", "job.batch/cluster-run-x1"}
+		got, gotErr := client.create(ctx, getFilePath("rest-manifest.yml"), "pets")
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+		expectPaths := []string{
+			"/apis/acid.zalan.do/v1/namespaces/pets/postgresqls/cluster",
+			"/apis/batch/v1/namespaces/pets/jobs/cluster-run-x1",
+		}
+		if paths := fake.paths(); !reflect.DeepEqual(paths, expectPaths) {
+			t.Fatalf("Got %v, expect %v", paths, expectPaths)
+		}
+	})
+
+	t.Run("must fail when the resource already exists", func(t *testing.T) {
+		expect := "status is 409: cluster already exists"
+		_, got := client.create(ctx, getFilePath("rest-manifest.yml"), "pets")
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must fail when the manifest is for another namespace", func(t *testing.T) {
+		expect := "the namespace of Job \"\" is \"pets\", expect \"staging\""
+		_, got := client.create(ctx, getFilePath("rest-manifest.yml"), "staging")
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+		if err := client.deleteResource(ctx, "postgresql/cluster", "staging"); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+	})
+
+	t.Run("must delete by selector", func(t *testing.T) {
+		if err := client.deleteSelected(ctx, "job", jobGroupSelector, "pets"); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		expectPaths := []string{"/apis/acid.zalan.do/v1/namespaces/pets/postgresqls/cluster"}
+		if paths := fake.paths(); !reflect.DeepEqual(paths, expectPaths) {
+			t.Fatalf("Got %v, expect %v", paths, expectPaths)
+		}
+	})
+
+	t.Run("must delete the manifest ignoring what does not exist", func(t *testing.T) {
+		if err := client.deleteFile(ctx, getFilePath("rest-manifest.yml"), "pets"); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if paths := fake.paths(); len(paths) != 0 {
+			t.Fatalf("Got %v, expect no resources", paths)
+		}
+	})
+
+	t.Run("must ignore deleting a resource that does not exist", func(t *testing.T) {
+		if err := client.deleteResource(ctx, "persistentvolumeclaim/pgdata-cluster-0", "default"); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+	})
+}
+
+func Test_restClientPlan(t *testing.T) {
+	fake := newFakeAPIServer(t)
+	plan := NewPlan()
+	client := fake.newClient(t, plan)
+
+	if _, err := client.create(context.Background(), getFilePath("rest-manifest.yml"), "pets"); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	if paths := fake.paths(); len(paths) != 0 {
+		t.Fatalf("Got %v, expect no resources", paths)
+	}
+	expect := []string{
+		"POST /apis/acid.zalan.do/v1/namespaces/pets/postgresqls",
+		"POST /apis/batch/v1/namespaces/pets/jobs",
+	}
+	if !reflect.DeepEqual(plan.Steps(), expect) {
+		t.Fatalf("Got plan %v, expect %v", plan.Steps(), expect)
+	}
+}
+
+func Test_restClientDoctor(t *testing.T) {
+	fake := newFakeAPIServer(t)
+	fake.put("/version", map[string]interface{}{"gitVersion": "v1.21.1"})
+	fake.put("/api/v1/nodes/a", map[string]interface{}{"metadata": map[string]interface{}{"name": "a"}})
+	fake.denied["delete persistentvolumeclaims"] = true
+	plan := NewPlan()
+	client := fake.newClient(t, plan)
+
+	t.Run("must return the server version", func(t *testing.T) {
+		got, err := client.serverVersion(context.Background())
+		if err != nil || got != "v1.21.1" {
+			t.Fatalf("Got %q and error %v, expect v1.21.1", got, err)
+		}
+	})
+
+	t.Run("must review the access even in plan mode", func(t *testing.T) {
+		for verb, expect := range map[string]bool{"create": true, "delete": false} {
+			got, err := client.canI(context.Background(), verb, "persistentvolumeclaims", "pets")
+			if err != nil || got != expect {
+				t.Fatalf("Got %v and error %v for %s, expect %v", got, err, verb, expect)
+			}
+		}
+		if steps := plan.Steps(); len(steps) != 0 {
+			t.Fatalf("Got plan %v, expect no steps", steps)
+		}
+	})
+
+	t.Run("must list the objects of a kind", func(t *testing.T) {
+		got, err := client.listObjects(context.Background(), "node", "")
+		if err != nil || len(got) != 1 {
+			t.Fatalf("Got %v and error %v, expect one node", got, err)
+		}
+	})
+}
+
+func Test_InitializeRestBackend(t *testing.T) {
+	fake := newFakeAPIServer(t)
+	fake.put("/api/v1/namespaces/default/services/postgres-operator", map[string]interface{}{})
+
+	spec := DefaultSpec()
+	spec.Cluster = ClusterSpec{Backend: restBackend, Kubeconfig: fake.writeKubeconfig(t, fakeToken)}
+	k8sImpl := NewK8sSetUpWithSpec(spec, Options{}).(*k8sSetUpImpl)
+
+	oldPathVar := pathVar
+	pathVar = "PATH_TEST_REST"
+	defer func() { pathVar = oldPathVar }()
+	_ = os.Setenv(pathVar, tempDir(t))
+
+	if err := k8sImpl.initializeRestClient(context.Background()); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	if !k8sImpl.isPostgreSQLOperatorInstalled(context.Background()) {
+		t.Fatalf("Got %v, expect %v", false, true)
+	}
+	expect := "not \"kubectl-kudo\" path found"
+	if got := k8sImpl.CheckKudoInstallation(context.Background()); got == nil || !strings.Contains(got.Error(), expect) {
+		t.Fatalf("Got error %v, expect %q", got, expect)
+	}
+}
+
+func Test_restClientApply(t *testing.T) {
+	fake := newFakeAPIServer(t)
+	client := fake.newClient(t, nil)
+	ctx := context.Background()
+
+	if err := client.apply(ctx, getFilePath("psql-cluster.yml"), "default"); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+	got, gotErr := client.getObject(ctx, "postgresql/cluster", "default")
+	if gotErr != nil {
+		t.Fatalf("Got error %v, expect nil", gotErr)
+	}
+	expect := map[string]interface{}{"pets": "petdba"}
+	if spec, _ := got["spec"].(map[string]interface{}); !reflect.DeepEqual(spec["databases"], expect) {
+		t.Fatalf("Got %v, expect databases %v", got, expect)
+	}
+}
@@ -0,0 +1,537 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// SpecVersion is the only infrastructure spec version that we support
+	SpecVersion = "v1"
+)
+
+var (
+	clusterNameRegex = regexp.MustCompile("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$")
+	topicNameRegex   = regexp.MustCompile("^[a-zA-Z0-9._-]{1,249}$")
+	storageSizeRegex = regexp.MustCompile("^[0-9]+(\\.[0-9]+)?(Ki|Mi|Gi|Ti|Pi|Ei|k|M|G|T|P|E)?$")
+	defaultTimeouts  = TimeoutsSpec{
+		Command:   Duration(10 * time.Minute),
+		Operator:  Duration(10 * time.Minute),
+		Database:  Duration(20 * time.Minute),
+		Zookeeper: Duration(15 * time.Minute),
+		Kafka:     Duration(15 * time.Minute),
+		Job:       Duration(10 * time.Minute),
+		Deletion:  Duration(10 * time.Minute),
+	}
+	defaultNamespace      = "default"
+	defaultKafkaBrokers   = 3
+	defaultZookeeperNodes = 3
+	defaultBackoff        = BackoffSpec{
+		Initial: Duration(time.Second),
+		Max:     Duration(30 * time.Second),
+		Factor:  2,
+	}
+)
+
+// Duration is a time.Duration read from strings like "1m30s"
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// UnmarshalYAML reads a duration from a yaml string
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	var value string
+	if err = unmarshal(&value); err == nil {
+		err = d.parse(value)
+	}
+	return
+}
+
+// UnmarshalJSON reads a duration from a json string
+func (d *Duration) UnmarshalJSON(data []byte) (err error) {
+	var value string
+	if err = json.Unmarshal(data, &value); err == nil {
+		err = d.parse(value)
+	}
+	return
+}
+
+func (d *Duration) parse(value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %v", value, err)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// Spec describes all the components of an environment
+type Spec struct {
+	Version   string            `yaml:"version" json:"version"`
+	Namespace string            `yaml:"namespace" json:"namespace"`
+	Variables map[string]string `yaml:"variables" json:"variables"`
+	Cluster   ClusterSpec       `yaml:"cluster" json:"cluster"`
+	Registry  RegistrySpec      `yaml:"registry" json:"registry"`
+	Builder   BuilderSpec       `yaml:"builder" json:"builder"`
+	Operator  OperatorSpec      `yaml:"operator" json:"operator"`
+	Databases []DatabaseSpec    `yaml:"databases" json:"databases"`
+	Kafka     []KafkaSpec       `yaml:"kafka" json:"kafka"`
+	Timeouts  TimeoutsSpec      `yaml:"timeouts" json:"timeouts"`
+	Backoff   BackoffSpec       `yaml:"backoff" json:"backoff"`
+}
+
+// TimeoutsSpec are the deadlines for every command and for every wait for a component to be ready
+type TimeoutsSpec struct {
+	Command   Duration `yaml:"command" json:"command"`
+	Operator  Duration `yaml:"operator" json:"operator"`
+	Database  Duration `yaml:"database" json:"database"`
+	Zookeeper Duration `yaml:"zookeeper" json:"zookeeper"`
+	Kafka     Duration `yaml:"kafka" json:"kafka"`
+	Job       Duration `yaml:"job" json:"job"`
+	Deletion  Duration `yaml:"deletion" json:"deletion"`
+}
+
+// BackoffSpec is how long we wait between checks, the delay grows by factor until max
+type BackoffSpec struct {
+	Initial Duration `yaml:"initial" json:"initial"`
+	Max     Duration `yaml:"max" json:"max"`
+	Factor  float64  `yaml:"factor" json:"factor"`
+}
+
+// ClusterSpec is how we talk with the cluster, with the kubectl command or with the API server, context selects
+// a context of the kubeconfig instead of its current one and the changes to a protected context must be confirmed,
+// the rest backend only supports specs without kafka topics or kudo, kudo runs the kubectl-kudo plugin and the
+// topics need exec in a broker
+type ClusterSpec struct {
+	Backend           string   `yaml:"backend" json:"backend"`
+	Kubeconfig        string   `yaml:"kubeconfig" json:"kubeconfig"`
+	Context           string   `yaml:"context" json:"context"`
+	ProtectedContexts []string `yaml:"protectedContexts" json:"protectedContexts"`
+}
+
+// RegistrySpec is the docker registry used for our images, when empty the environment variables are used,
+// the password of username is read from the passwordEnv variable and without username the credentials of
+// the docker config are used, caCert is a PEM file that signs the registry certificate
+type RegistrySpec struct {
+	URL          string `yaml:"url" json:"url"`
+	K8sURL       string `yaml:"k8sUrl" json:"k8sUrl"`
+	Username     string `yaml:"username" json:"username"`
+	PasswordEnv  string `yaml:"passwordEnv" json:"passwordEnv"`
+	DockerConfig string `yaml:"dockerConfig" json:"dockerConfig"`
+	CACert       string `yaml:"caCert" json:"caCert"`
+	Insecure     bool   `yaml:"insecure" json:"insecure"`
+}
+
+// BuilderSpec is the tool that builds and pushes the job images, auto uses the first one found in the path
+type BuilderSpec struct {
+	Tool string `yaml:"tool" json:"tool"`
+}
+
+// OperatorSpec is where we get the PostgreSQL operator from, the git source clones repository once in the
+// cache dir and checks out version, a tag or a commit, the embedded source uses the manifests of the binary and
+// the directory source the manifests in directory
+type OperatorSpec struct {
+	Source     string `yaml:"source" json:"source"`
+	Repository string `yaml:"repository" json:"repository"`
+	Version    string `yaml:"version" json:"version"`
+	CacheDir   string `yaml:"cacheDir" json:"cacheDir"`
+	Directory  string `yaml:"directory" json:"directory"`
+}
+
+// DatabaseSpec is a database cluster manifest and the job that runs once it is created
+type DatabaseSpec struct {
+	Manifest string  `yaml:"manifest" json:"manifest"`
+	Job      JobSpec `yaml:"job" json:"job"`
+}
+
+// JobSpec is a job image and manifest, when empty they are named after the database cluster, build args and
+// target are the --build-arg values and the stage of the dockerfile to build, migrations is the directory of the
+// versioned SQL files that the job applies, the job runs again when it has a version that no job applied yet
+type JobSpec struct {
+	Dockerfile string            `yaml:"dockerfile" json:"dockerfile"`
+	Manifest   string            `yaml:"manifest" json:"manifest"`
+	Context    string            `yaml:"context" json:"context"`
+	BuildArgs  map[string]string `yaml:"buildArgs" json:"buildArgs"`
+	Target     string            `yaml:"target" json:"target"`
+	Migrations string            `yaml:"migrations" json:"migrations"`
+}
+
+// KafkaSpec is a kafka cluster with its zookeeper and its topics, parameters are passed to kudo as -p
+type KafkaSpec struct {
+	Name        string            `yaml:"name" json:"name"`
+	Brokers     int               `yaml:"brokers" json:"brokers"`
+	StorageSize string            `yaml:"storageSize" json:"storageSize"`
+	Parameters  map[string]string `yaml:"parameters" json:"parameters"`
+	Zookeeper   ZookeeperSpec     `yaml:"zookeeper" json:"zookeeper"`
+	Topics      []TopicSpec       `yaml:"topics" json:"topics"`
+}
+
+// ZookeeperSpec is the zookeeper ensemble of a kafka cluster, parameters are passed to kudo as -p
+type ZookeeperSpec struct {
+	Nodes       int               `yaml:"nodes" json:"nodes"`
+	StorageSize string            `yaml:"storageSize" json:"storageSize"`
+	Parameters  map[string]string `yaml:"parameters" json:"parameters"`
+}
+
+// TopicSpec is a kafka topic, configs are topic configs like retention.ms or min.insync.replicas
+type TopicSpec struct {
+	Name              string            `yaml:"name" json:"name"`
+	Partitions        int               `yaml:"partitions" json:"partitions"`
+	ReplicationFactor int               `yaml:"replicationFactor" json:"replicationFactor"`
+	Configs           map[string]string `yaml:"configs" json:"configs"`
+}
+
+// DefaultSpec returns the pets environment
+func DefaultSpec() *Spec {
+	spec := &Spec{
+		Version: SpecVersion,
+		Operator: OperatorSpec{
+			Repository: zalandoPsqlOperator,
+			Version:    zalandoPsqlOperatorVersion,
+		},
+		Databases: []DatabaseSpec{
+			{Manifest: "pets-db.yml"},
+		},
+		Kafka: []KafkaSpec{
+			{
+				Name: "pets",
+				Topics: []TopicSpec{
+					{
+						Name:              "pet-commands",
+						Partitions:        3,
+						ReplicationFactor: 3,
+						Configs: map[string]string{
+							"min.insync.replicas": "2",
+							"retention.ms":        "604800000",
+						},
+					},
+				},
+			},
+		},
+	}
+	spec.setDefaults()
+	return spec
+}
+
+// LoadSpec reads a yaml or json spec file, json is used when the file extension is .json
+func LoadSpec(fileName string) (*Spec, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading spec file %q: %v", fileName, err)
+	}
+
+	spec := &Spec{}
+	if strings.ToLower(filepath.Ext(fileName)) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(spec)
+	} else {
+		err = yaml.UnmarshalStrict(content, spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing spec file %q: %v", fileName, err)
+	}
+
+	spec.setDefaults()
+	if err = spec.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid spec file %q: %v", fileName, err)
+	}
+	return spec, nil
+}
+
+func defaultDuration(value *Duration, defaultValue Duration) {
+	if *value == 0 {
+		*value = defaultValue
+	}
+}
+
+func validateBuilder(tool string) error {
+	if tool == autoBuilder {
+		return nil
+	}
+	for _, v := range imageBuilderTools {
+		if tool == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported image builder %q, expect %s or %s", tool, autoBuilder, strings.Join(imageBuilderTools, ", "))
+}
+
+func validateVariables(variables map[string]string) error {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !variableNameRegex.MatchString(name) {
+			return fmt.Errorf("variable %q has an invalid name", name)
+		}
+		for _, v := range computedVariables {
+			if name == v {
+				return fmt.Errorf("variable %s is computed and could not be set", name)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Spec) setDefaults() {
+	if s.Namespace == "" {
+		s.Namespace = defaultNamespace
+	}
+	if s.Cluster.Backend == "" {
+		s.Cluster.Backend = kubectlBackend
+	}
+	if s.Registry.PasswordEnv == "" {
+		s.Registry.PasswordEnv = defaultRegistryPasswordVar
+	}
+	if s.Builder.Tool == "" {
+		s.Builder.Tool = autoBuilder
+	}
+	if s.Operator.Source == "" {
+		s.Operator.Source = gitOperatorSource
+	}
+	if s.Operator.Repository == "" {
+		s.Operator.Repository = zalandoPsqlOperator
+	}
+	if s.Operator.Version == "" {
+		s.Operator.Version = zalandoPsqlOperatorVersion
+	}
+	if s.Operator.CacheDir == "" {
+		s.Operator.CacheDir = defaultOperatorCacheDir
+	}
+	defaultDuration(&s.Timeouts.Command, defaultTimeouts.Command)
+	defaultDuration(&s.Timeouts.Operator, defaultTimeouts.Operator)
+	defaultDuration(&s.Timeouts.Database, defaultTimeouts.Database)
+	defaultDuration(&s.Timeouts.Zookeeper, defaultTimeouts.Zookeeper)
+	defaultDuration(&s.Timeouts.Kafka, defaultTimeouts.Kafka)
+	defaultDuration(&s.Timeouts.Job, defaultTimeouts.Job)
+	defaultDuration(&s.Timeouts.Deletion, defaultTimeouts.Deletion)
+	defaultDuration(&s.Backoff.Initial, defaultBackoff.Initial)
+	defaultDuration(&s.Backoff.Max, defaultBackoff.Max)
+	if s.Backoff.Factor == 0 {
+		s.Backoff.Factor = defaultBackoff.Factor
+	}
+	for i := range s.Kafka {
+		s.Kafka[i].setDefaults()
+		for j := range s.Kafka[i].Topics {
+			topic := &s.Kafka[i].Topics[j]
+			if topic.Partitions == 0 {
+				topic.Partitions = 1
+			}
+			if topic.ReplicationFactor == 0 {
+				topic.ReplicationFactor = 1
+			}
+		}
+	}
+}
+
+// Validate checks that the spec could be executed
+func (s Spec) Validate() error {
+	if s.Version != SpecVersion {
+		return fmt.Errorf("unsupported spec version %q, expect %q", s.Version, SpecVersion)
+	}
+	if s.Cluster.Backend != kubectlBackend && s.Cluster.Backend != restBackend {
+		return fmt.Errorf("unsupported cluster backend %q, expect %q or %q", s.Cluster.Backend, kubectlBackend, restBackend)
+	}
+	if len(s.Namespace) > 63 || !clusterNameRegex.MatchString(s.Namespace) {
+		return fmt.Errorf("namespace %q is not a valid namespace name", s.Namespace)
+	}
+	if err := validateBuilder(s.Builder.Tool); err != nil {
+		return err
+	}
+	switch s.Operator.Source {
+	case gitOperatorSource:
+	case embeddedOperatorSource:
+		if s.Operator.Version != zalandoPsqlOperatorVersion {
+			return fmt.Errorf("operator version %q is not embedded, the embedded source only has %q", s.Operator.Version, zalandoPsqlOperatorVersion)
+		}
+	case directoryOperatorSource:
+		if s.Operator.Directory == "" {
+			return errors.New("operator directory source has no directory")
+		}
+	default:
+		return fmt.Errorf("unsupported operator source %q, expect %q, %q or %q", s.Operator.Source, gitOperatorSource, embeddedOperatorSource, directoryOperatorSource)
+	}
+	if err := validateVariables(s.Variables); err != nil {
+		return err
+	}
+	if len(s.Databases) == 0 && len(s.Kafka) == 0 {
+		return errors.New("no databases nor kafka clusters defined")
+	}
+	for _, v := range []struct {
+		name  string
+		value Duration
+	}{
+		{"command", s.Timeouts.Command},
+		{"operator", s.Timeouts.Operator},
+		{"database", s.Timeouts.Database},
+		{"zookeeper", s.Timeouts.Zookeeper},
+		{"kafka", s.Timeouts.Kafka},
+		{"job", s.Timeouts.Job},
+		{"deletion", s.Timeouts.Deletion},
+	} {
+		if v.value <= 0 {
+			return fmt.Errorf("timeout %s must be positive", v.name)
+		}
+	}
+	if s.Backoff.Initial <= 0 || s.Backoff.Max < s.Backoff.Initial {
+		return errors.New("backoff initial must be positive and not greater than max")
+	}
+	if s.Backoff.Factor < 1 {
+		return fmt.Errorf("backoff factor %v must be at least 1", s.Backoff.Factor)
+	}
+
+	manifests := map[string]bool{}
+	for i, v := range s.Databases {
+		if v.Manifest == "" {
+			return fmt.Errorf("database %d has no manifest", i)
+		}
+		if manifests[v.Manifest] {
+			return fmt.Errorf("database manifest %q is duplicated", v.Manifest)
+		}
+		manifests[v.Manifest] = true
+	}
+
+	names := map[string]bool{}
+	for i, v := range s.Kafka {
+		if !clusterNameRegex.MatchString(v.Name) {
+			return fmt.Errorf("kafka cluster %d has an invalid name %q", i, v.Name)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("kafka cluster %q is duplicated", v.Name)
+		}
+		names[v.Name] = true
+		if err := v.validateTopology(); err != nil {
+			return fmt.Errorf("kafka cluster %q %v", v.Name, err)
+		}
+		if err := v.validateTopics(); err != nil {
+			return fmt.Errorf("kafka cluster %q %v", v.Name, err)
+		}
+		if len(v.Topics) != 0 && s.Cluster.Backend == restBackend {
+			return fmt.Errorf("kafka cluster %q has topics, the %q backend only supports specs without kafka topics or kudo", v.Name, restBackend)
+		}
+	}
+	return nil
+}
+
+// hasTopics returns true when a kafka cluster has topics, they need exec in its first broker
+func (s Spec) hasTopics() bool {
+	for _, v := range s.Kafka {
+		if len(v.Topics) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *KafkaSpec) setDefaults() {
+	if k.Brokers == 0 {
+		k.Brokers = defaultKafkaBrokers
+	}
+	if k.Zookeeper.Nodes == 0 {
+		k.Zookeeper.Nodes = defaultZookeeperNodes
+	}
+}
+
+func validateTopology(what string, count int, storageSize string, parameters map[string]string, derived []string) error {
+	if count < 1 {
+		return fmt.Errorf("%s must have at least one node", what)
+	}
+	if storageSize != "" && !storageSizeRegex.MatchString(storageSize) {
+		return fmt.Errorf("%s has an invalid storage size %q", what, storageSize)
+	}
+	for _, v := range derived {
+		if _, ok := parameters[v]; ok {
+			return fmt.Errorf("%s parameter %s is derived from the spec and could not be set", what, v)
+		}
+	}
+	return nil
+}
+
+func (k KafkaSpec) validateTopology() error {
+	if err := validateTopology("kafka", k.Brokers, k.StorageSize, k.Parameters, kafkaDerivedParameters); err != nil {
+		return err
+	}
+	return validateTopology("zookeeper", k.Zookeeper.Nodes, k.Zookeeper.StorageSize, k.Zookeeper.Parameters, zookeeperDerivedParameters)
+}
+
+func (k KafkaSpec) validateTopics() error {
+	topics := map[string]bool{}
+	for i, v := range k.Topics {
+		if !topicNameRegex.MatchString(v.Name) {
+			return fmt.Errorf("topic %d has an invalid name %q", i, v.Name)
+		}
+		if topics[v.Name] {
+			return fmt.Errorf("topic %q is duplicated", v.Name)
+		}
+		topics[v.Name] = true
+		if v.Partitions < 1 || v.ReplicationFactor < 1 {
+			return fmt.Errorf("topic %q must have at least one partition and one replica", v.Name)
+		}
+		if v.ReplicationFactor > k.Brokers {
+			return fmt.Errorf("topic %q replication factor %d is greater than %d broker(s)", v.Name, v.ReplicationFactor, k.Brokers)
+		}
+	}
+	return nil
+}
+
+// DatabaseManifests returns the manifests of all the databases
+func (s Spec) DatabaseManifests() (manifests []string) {
+	for _, v := range s.Databases {
+		manifests = append(manifests, v.Manifest)
+	}
+	return
+}
+
+// KafkaClusters returns the names of all the kafka clusters
+func (s Spec) KafkaClusters() (names []string) {
+	for _, v := range s.Kafka {
+		names = append(names, v.Name)
+	}
+	return
+}
+
+// isProtectedContext returns true when the changes to the kube context must be confirmed
+func (s Spec) isProtectedContext(name string) bool {
+	for _, v := range s.Cluster.ProtectedContexts {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// kafkaCluster returns the kafka cluster of the spec, when it is not in the spec the default topology is used
+func (s Spec) kafkaCluster(cluster string) KafkaSpec {
+	for _, v := range s.Kafka {
+		if v.Name == cluster {
+			return v
+		}
+	}
+	kafka := KafkaSpec{Name: cluster}
+	kafka.setDefaults()
+	return kafka
+}
+
+func (s Spec) databaseJob(manifest string) JobSpec {
+	for _, v := range s.Databases {
+		if v.Manifest == manifest {
+			return v.Job
+		}
+	}
+	return JobSpec{}
+}
@@ -0,0 +1,276 @@
+package k8ssetup
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func Test_LoadSpec(t *testing.T) {
+	t.Run("must load a yaml spec", func(t *testing.T) {
+		expect := &Spec{
+			Version:  SpecVersion,
+			Registry: RegistrySpec{URL: "http://localhost:5000"},
+			Operator: OperatorSpec{Repository: zalandoPsqlOperator},
+			Databases: []DatabaseSpec{
+				{Manifest: "psql-cluster.yml", Job: JobSpec{Dockerfile: "Dockerfile-cluster-job"}},
+			},
+			Kafka:    []KafkaSpec{{Name: "pets"}},
+			Timeouts: TimeoutsSpec{Database: Duration(30 * time.Minute)},
+			Backoff:  BackoffSpec{Initial: Duration(2 * time.Second), Max: Duration(time.Minute)},
+		}
+		expect.setDefaults()
+		got, gotErr := LoadSpec(getFilePath("spec.yml"))
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %+v, expect %+v", got, expect)
+		}
+	})
+
+	t.Run("must load a json spec", func(t *testing.T) {
+		expect := &Spec{
+			Version:  SpecVersion,
+			Operator: OperatorSpec{Repository: "http://localhost/postgres-operator.git"},
+			Kafka:    []KafkaSpec{{Name: "pets"}},
+			Timeouts: TimeoutsSpec{Kafka: Duration(5 * time.Minute)},
+		}
+		expect.setDefaults()
+		got, gotErr := LoadSpec(getFilePath("spec.json"))
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %+v, expect %+v", got, expect)
+		}
+	})
+
+	t.Run("must return error when file does not exist", func(t *testing.T) {
+		expect := "error reading spec file"
+		_, got := LoadSpec(getFilePath("not-exist.yml"))
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must return error when file has unknown fields", func(t *testing.T) {
+		expect := "error parsing spec file"
+		_, got := LoadSpec(getFilePath("spec-unknown-field.yml"))
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must return error when spec is not valid", func(t *testing.T) {
+		expect := "unsupported spec version \"v0\""
+		_, got := LoadSpec(getFilePath("spec-invalid-version.yml"))
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
+
+func Test_Validate(t *testing.T) {
+	type TestCase struct {
+		name   string
+		spec   Spec
+		expect string
+	}
+
+	cases := []TestCase{
+		{
+			name:   "default spec is valid",
+			spec:   *DefaultSpec(),
+			expect: "",
+		},
+		{
+			name:   "spec without components is not valid",
+			spec:   Spec{Version: SpecVersion},
+			expect: "no databases nor kafka clusters defined",
+		},
+		{
+			name:   "database without manifest is not valid",
+			spec:   Spec{Version: SpecVersion, Databases: []DatabaseSpec{{}}},
+			expect: "database 0 has no manifest",
+		},
+		{
+			name:   "duplicated database is not valid",
+			spec:   Spec{Version: SpecVersion, Databases: []DatabaseSpec{{Manifest: "db.yml"}, {Manifest: "db.yml"}}},
+			expect: "database manifest \"db.yml\" is duplicated",
+		},
+		{
+			name:   "kafka cluster with invalid name is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "Pets_1"}}},
+			expect: "kafka cluster 0 has an invalid name \"Pets_1\"",
+		},
+		{
+			name:   "duplicated kafka cluster is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "pets"}, {Name: "pets"}}},
+			expect: "kafka cluster \"pets\" is duplicated",
+		},
+		{
+			name:   "invalid namespace is not valid",
+			spec:   Spec{Version: SpecVersion, Namespace: "Pets_Staging", Kafka: []KafkaSpec{{Name: "pets"}}},
+			expect: "namespace \"Pets_Staging\" is not a valid namespace name",
+		},
+		{
+			name:   "computed variable is not valid",
+			spec:   Spec{Version: SpecVersion, Variables: map[string]string{"NAMESPACE": "pets"}, Kafka: []KafkaSpec{{Name: "pets"}}},
+			expect: "variable NAMESPACE is computed and could not be set",
+		},
+		{
+			name:   "schema version variable is not valid",
+			spec:   Spec{Version: SpecVersion, Variables: map[string]string{"SCHEMA_VERSION": "3"}, Kafka: []KafkaSpec{{Name: "pets"}}},
+			expect: "variable SCHEMA_VERSION is computed and could not be set",
+		},
+		{
+			name:   "variable with invalid name is not valid",
+			spec:   Spec{Version: SpecVersion, Variables: map[string]string{"VOLUME-SIZE": "1Gi"}, Kafka: []KafkaSpec{{Name: "pets"}}},
+			expect: "variable \"VOLUME-SIZE\" has an invalid name",
+		},
+		{
+			name:   "unknown cluster backend is not valid",
+			spec:   Spec{Version: SpecVersion, Cluster: ClusterSpec{Backend: "ssh"}, Kafka: []KafkaSpec{{Name: "pets"}}},
+			expect: "unsupported cluster backend \"ssh\", expect \"kubectl\" or \"rest\"",
+		},
+		{
+			name:   "unknown image builder is not valid",
+			spec:   Spec{Version: SpecVersion, Builder: BuilderSpec{Tool: "kaniko"}, Kafka: []KafkaSpec{{Name: "pets"}}},
+			expect: "unsupported image builder \"kaniko\", expect auto or docker, podman, nerdctl, buildah",
+		},
+		{
+			name:   "unknown operator source is not valid",
+			spec:   Spec{Version: SpecVersion, Operator: OperatorSpec{Source: "s3"}, Kafka: []KafkaSpec{{Name: "pets"}}},
+			expect: "unsupported operator source \"s3\", expect \"git\", \"embedded\" or \"directory\"",
+		},
+		{
+			name:   "operator directory source without directory is not valid",
+			spec:   Spec{Version: SpecVersion, Operator: OperatorSpec{Source: "directory"}, Kafka: []KafkaSpec{{Name: "pets"}}},
+			expect: "operator directory source has no directory",
+		},
+		{
+			name:   "operator embedded source is valid",
+			spec:   Spec{Version: SpecVersion, Operator: OperatorSpec{Source: "embedded"}, Kafka: []KafkaSpec{{Name: "pets"}}},
+			expect: "",
+		},
+		{
+			name:   "operator embedded source with another version is not valid",
+			spec:   Spec{Version: SpecVersion, Operator: OperatorSpec{Source: "embedded", Version: "v1.6.0"}, Kafka: []KafkaSpec{{Name: "pets"}}},
+			expect: "operator version \"v1.6.0\" is not embedded, the embedded source only has \"v1.5.0\"",
+		},
+		{
+			name:   "duplicated topic is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "pets", Topics: []TopicSpec{{Name: "a"}, {Name: "a"}}}}},
+			expect: "kafka cluster \"pets\" topic \"a\" is duplicated",
+		},
+		{
+			name:   "topic with invalid name is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "pets", Topics: []TopicSpec{{Name: "pet commands"}}}}},
+			expect: "kafka cluster \"pets\" topic 0 has an invalid name \"pet commands\"",
+		},
+		{
+			name:   "topic without partitions is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "pets", Topics: []TopicSpec{{Name: "a", Partitions: -1}}}}},
+			expect: "kafka cluster \"pets\" topic \"a\" must have at least one partition and one replica",
+		},
+		{
+			name:   "topic replicated on more brokers than the cluster has is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "pets", Brokers: 1, Topics: []TopicSpec{{Name: "a", ReplicationFactor: 3}}}}},
+			expect: "kafka cluster \"pets\" topic \"a\" replication factor 3 is greater than 1 broker(s)",
+		},
+		{
+			name: "topics with the rest backend are not valid",
+			spec: Spec{Version: SpecVersion, Cluster: ClusterSpec{Backend: restBackend},
+				Kafka: []KafkaSpec{{Name: "pets", Topics: []TopicSpec{{Name: "a"}}}}},
+			expect: "kafka cluster \"pets\" has topics, the \"rest\" backend only supports specs without kafka topics or kudo",
+		},
+		{
+			name:   "kafka cluster without brokers is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "pets", Brokers: -1}}},
+			expect: "kafka cluster \"pets\" kafka must have at least one node",
+		},
+		{
+			name:   "zookeeper with invalid storage size is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "pets", Zookeeper: ZookeeperSpec{StorageSize: "5 gigas"}}}},
+			expect: "kafka cluster \"pets\" zookeeper has an invalid storage size \"5 gigas\"",
+		},
+		{
+			name:   "derived kafka parameter is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "pets", Parameters: map[string]string{"ZOOKEEPER_URI": "zk:2181"}}}},
+			expect: "kafka cluster \"pets\" kafka parameter ZOOKEEPER_URI is derived from the spec and could not be set",
+		},
+		{
+			name:   "negative timeout is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "pets"}}, Timeouts: TimeoutsSpec{Zookeeper: Duration(-time.Second)}},
+			expect: "timeout zookeeper must be positive",
+		},
+		{
+			name:   "backoff factor lower than one is not valid",
+			spec:   Spec{Version: SpecVersion, Kafka: []KafkaSpec{{Name: "pets"}}, Backoff: BackoffSpec{Factor: 0.5}},
+			expect: "backoff factor 0.5 must be at least 1",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec.setDefaults()
+			got := tt.spec.Validate()
+			if tt.expect == "" {
+				if got != nil {
+					t.Fatalf("Got %v, expect nil", got)
+				}
+			} else if got == nil || got.Error() != tt.expect {
+				t.Fatalf("Got %v, expect %v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func Test_databaseJob(t *testing.T) {
+	spec := Spec{
+		Databases: []DatabaseSpec{
+			{Manifest: "db.yml", Job: JobSpec{Manifest: "job.yml"}},
+		},
+	}
+
+	t.Run("must return the job of the database", func(t *testing.T) {
+		expect := JobSpec{Manifest: "job.yml"}
+		got := spec.databaseJob("db.yml")
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must return an empty job when database is not in the spec", func(t *testing.T) {
+		expect := JobSpec{}
+		got := spec.databaseJob("other.yml")
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+}
+
+func Test_Duration(t *testing.T) {
+	t.Run("must read a duration from yaml", func(t *testing.T) {
+		var got Duration
+		if err := yaml.Unmarshal([]byte("1m30s"), &got); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if expect := Duration(90 * time.Second); got != expect {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must fail on an invalid duration", func(t *testing.T) {
+		var got Duration
+		expect := "invalid duration \"soon\""
+		if err := json.Unmarshal([]byte(`"soon"`), &got); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", err, expect)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package k8ssetup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// StateVersion is the version of the state file that we write
+const StateVersion = 1
+
+// State is what a run has done, it is saved after every step so a failed run could resume from the failed step
+type State struct {
+	mu         sync.Mutex
+	Version    int         `json:"version"`
+	SpecDigest string      `json:"specDigest"`
+	Steps      []StepState `json:"steps"`
+}
+
+// StepState is the last result of a step, with the outputs that later runs need like the cluster or the image
+type StepState struct {
+	ID        string            `json:"id"`
+	Status    StepStatus        `json:"status"`
+	Outputs   map[string]string `json:"outputs,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// StepID identifies a step by its name and its component, like database/pets-db.yml
+func StepID(name, component string) string {
+	if component == "" {
+		return name
+	}
+	return name + "/" + component
+}
+
+// SpecDigest returns the digest of a spec, a state is only resumed with the spec that wrote it
+func SpecDigest(spec *Spec) string {
+	content, _ := json.Marshal(spec)
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewState returns the empty state of a spec
+func NewState(spec *Spec) *State {
+	return &State{Version: StateVersion, SpecDigest: SpecDigest(spec)}
+}
+
+// LoadState reads the state file of a spec, there is an empty state when the file does not exist
+func LoadState(fileName string, spec *Spec) (*State, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return NewState(spec), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading state file %q: %v", fileName, err)
+	}
+
+	state := &State{}
+	if err = json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("error reading state file %q: %v", fileName, err)
+	}
+	if state.Version != StateVersion {
+		return nil, fmt.Errorf("unsupported state version %d in %q, expect %d", state.Version, fileName, StateVersion)
+	}
+	if state.SpecDigest != SpecDigest(spec) {
+		return nil, fmt.Errorf("state file %q was written for another spec, remove it to run every step again", fileName)
+	}
+	return state, nil
+}
+
+// Save writes the state in a temporary file that replaces the state file, a crash never leaves half a state
+func (s *State) Save(fileName string) error {
+	s.mu.Lock()
+	content, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error writing state file %q: %v", fileName, err)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".*")
+	if err != nil {
+		return fmt.Errorf("error writing state file %q: %v", fileName, err)
+	}
+	if _, err = file.Write(append(content, '\n')); err == nil {
+		err = file.Close()
+	} else {
+		_ = file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), fileName)
+	}
+	if err != nil {
+		removeFile(file.Name())
+		return fmt.Errorf("error writing state file %q: %v", fileName, err)
+	}
+	return nil
+}
+
+// Step returns the state of a step, false when it never ran
+func (s *State) Step(id string) (StepState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.Steps {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return StepState{}, false
+}
+
+// Completed returns true when the last run of a step did not fail
+func (s *State) Completed(id string) bool {
+	step, ok := s.Step(id)
+	return ok && (step.Status == StatusCreated || step.Status == StatusAlreadyPresent)
+}
+
+// Update sets the state of a step from its result, skipped steps keep their state
+func (s *State) Update(result StepResult) {
+	if result.Status == StatusSkipped {
+		return
+	}
+	step := StepState{
+		ID:        StepID(result.Name, result.Component),
+		Status:    result.Status,
+		Outputs:   result.Outputs,
+		Error:     result.Error,
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.Steps {
+		if v.ID == step.ID {
+			s.Steps[i] = step
+			return
+		}
+	}
+	s.Steps = append(s.Steps, step)
+}
+
+// Failed returns the number of steps whose last run failed
+func (s *State) Failed() (failed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.Steps {
+		if v.Status == StatusFailed {
+			failed++
+		}
+	}
+	return
+}
+
+// Write writes the state in the table or json format
+func (s *State) Write(w io.Writer, format string) error {
+	switch format {
+	case TableReport:
+		return s.WriteTable(w)
+	case JSONReport:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(s)
+	}
+	return fmt.Errorf("unsupported state format %q, expect %q or %q", format, TableReport, JSONReport)
+}
+
+// WriteTable writes a row per step with its last status, when it ran, its outputs and its error
+func (s *State) WriteTable(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.Steps) == 0 {
+		_, err := fmt.Fprintln(w, "No step has run")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tSTATUS\tUPDATED\tOUTPUTS\tERROR")
+	for _, v := range s.Steps {
+		outputs := make([]string, 0, len(v.Outputs))
+		for key, value := range v.Outputs {
+			outputs = append(outputs, key+"="+value)
+		}
+		sort.Strings(outputs)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", v.ID, v.Status, v.UpdatedAt.Format(time.RFC3339),
+			orDash(strings.Join(outputs, ", ")), orDash(v.Error))
+	}
+	return tw.Flush()
+}
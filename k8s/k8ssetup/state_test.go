@@ -0,0 +1,139 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_StateWrite(t *testing.T) {
+	state := NewState(DefaultSpec())
+	state.Steps = []StepState{
+		{ID: "namespace/default", Status: StatusAlreadyPresent, Outputs: map[string]string{"namespace": "default"},
+			UpdatedAt: time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)},
+		{ID: "kafka-cluster/pets", Status: StatusFailed, Error: "timed out",
+			UpdatedAt: time.Date(2021, 6, 1, 10, 5, 0, 0, time.UTC)},
+	}
+
+	t.Run("must write a table", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := state.Write(&out, TableReport); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		expect := strings.Join([]string{
+			"STEP                STATUS           UPDATED               OUTPUTS            ERROR",
+			"namespace/default   already-present  2021-06-01T10:00:00Z  namespace=default  -",
+			"kafka-cluster/pets  failed           2021-06-01T10:05:00Z  -                  timed out",
+			"",
+		}, "\n")
+		if got := out.String(); got != expect {
+			t.Fatalf("Got\n%s\nexpect\n%s", got, expect)
+		}
+		if failed := state.Failed(); failed != 1 {
+			t.Fatalf("Got %d failed, expect 1", failed)
+		}
+	})
+
+	t.Run("must write json", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := state.Write(&out, JSONReport); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		got := State{}
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil || len(got.Steps) != 2 || got.Steps[1].Error != "timed out" {
+			t.Fatalf("Got %+v and error %v, expect the steps", &got, err)
+		}
+	})
+
+	t.Run("must tell when no step has run", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := NewState(DefaultSpec()).Write(&out, TableReport); err != nil || out.String() != "No step has run\n" {
+			t.Fatalf("Got %q and error %v, expect no step", out.String(), err)
+		}
+	})
+
+	t.Run("must return error on an unknown format", func(t *testing.T) {
+		if got := state.Write(&bytes.Buffer{}, JUnitReport); got == nil {
+			t.Fatalf("Got nil, expect error")
+		}
+	})
+}
+
+func Test_State(t *testing.T) {
+	spec := DefaultSpec()
+
+	t.Run("must load an empty state when the file does not exist", func(t *testing.T) {
+		state, err := LoadState(filepath.Join(t.TempDir(), "state.json"), spec)
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if len(state.Steps) != 0 || state.Version != StateVersion || state.SpecDigest != SpecDigest(spec) {
+			t.Fatalf("Got %+v, expect an empty state of the spec", state)
+		}
+	})
+
+	t.Run("must save and load the steps with their outputs", func(t *testing.T) {
+		fileName := filepath.Join(t.TempDir(), "state.json")
+		state := NewState(spec)
+		state.Update(StepResult{Name: "database", Component: "pets-db.yml", Status: StatusCreated,
+			Outputs: map[string]string{"cluster": "pets-db", "image": "registry:5000/pets-db-job@sha256:1234"}})
+		state.Update(StepResult{Name: "kafka-cluster", Component: "pets", Status: StatusFailed, Error: "timed out"})
+		state.Update(StepResult{Name: "kafka-topics", Component: "pets", Status: StatusSkipped})
+		if err := state.Save(fileName); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+
+		got, err := LoadState(fileName, spec)
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if !got.Completed("database/pets-db.yml") || got.Completed("kafka-cluster/pets") || got.Completed("kafka-topics/pets") {
+			t.Fatalf("Got %+v, expect only the database completed", got.Steps)
+		}
+		step, _ := got.Step("database/pets-db.yml")
+		if expect := state.Steps[0].Outputs; !reflect.DeepEqual(step.Outputs, expect) {
+			t.Fatalf("Got %v, expect %v", step.Outputs, expect)
+		}
+		if files, _ := ioutil.ReadDir(filepath.Dir(fileName)); len(files) != 1 {
+			t.Fatalf("Got %d files, expect only the state file", len(files))
+		}
+	})
+
+	t.Run("must replace the state of a step run again", func(t *testing.T) {
+		state := NewState(spec)
+		state.Update(StepResult{Name: "kafka-cluster", Component: "pets", Status: StatusFailed})
+		state.Update(StepResult{Name: "kafka-cluster", Component: "pets", Status: StatusAlreadyPresent})
+		if len(state.Steps) != 1 || !state.Completed("kafka-cluster/pets") {
+			t.Fatalf("Got %+v, expect a completed step", state.Steps)
+		}
+	})
+
+	t.Run("must return error when the state is of another spec", func(t *testing.T) {
+		fileName := filepath.Join(t.TempDir(), "state.json")
+		if err := NewState(DefaultSpec()).Save(fileName); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		other := DefaultSpec()
+		other.Namespace = "pets-staging"
+
+		expect := "state file \"" + fileName + "\" was written for another spec, remove it to run every step again"
+		if _, got := LoadState(fileName, other); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must return error on an unknown version", func(t *testing.T) {
+		fileName := filepath.Join(t.TempDir(), "state.json")
+		_ = ioutil.WriteFile(fileName, []byte(`{"version": 9}`), 0600)
+
+		expect := "unsupported state version 9 in \"" + fileName + "\", expect 1"
+		if _, got := LoadState(fileName, spec); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
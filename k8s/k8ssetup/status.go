@@ -0,0 +1,327 @@
+package k8ssetup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// results of the last job of a database cluster
+const (
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobRunning   = "running"
+)
+
+// ComponentStatus is the live state of a component of the spec, the pods are zero for a component without pods
+type ComponentStatus struct {
+	Name      string `json:"name"`
+	Installed bool   `json:"installed"`
+	Ready     bool   `json:"ready"`
+	Version   string `json:"version,omitempty"`
+	ReadyPods int    `json:"readyPods"`
+	Pods      int    `json:"pods"`
+	// Job is the result of the last job of a database cluster, succeeded, failed or running
+	Job     string `json:"job,omitempty"`
+	Healthy bool   `json:"healthy"`
+	// Detail is why the component is not healthy
+	Detail string `json:"detail,omitempty"`
+}
+
+// Status is the live state of every component of the spec in the cluster
+type Status struct {
+	Components []ComponentStatus `json:"components"`
+}
+
+// Unhealthy returns the number of components that are not healthy
+func (s Status) Unhealthy() (unhealthy int) {
+	for _, v := range s.Components {
+		if !v.Healthy {
+			unhealthy++
+		}
+	}
+	return
+}
+
+// Write writes the status in the table or json format
+func (s Status) Write(w io.Writer, format string) error {
+	switch format {
+	case TableReport:
+		return s.WriteTable(w)
+	case JSONReport:
+		return s.WriteJSON(w)
+	}
+	return fmt.Errorf("unsupported status format %q, expect %q or %q", format, TableReport, JSONReport)
+}
+
+// WriteTable writes a row per component
+func (s Status) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMPONENT\tINSTALLED\tREADY\tVERSION\tPODS\tJOB\tDETAIL")
+	for _, v := range s.Components {
+		pods := ""
+		if v.Pods != 0 {
+			pods = fmt.Sprintf("%d/%d", v.ReadyPods, v.Pods)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", v.Name, yesNo(v.Installed), yesNo(v.Ready), orDash(v.Version),
+			orDash(pods), orDash(v.Job), orDash(v.Detail))
+	}
+	return tw.Flush()
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+// WriteJSON writes the components and whether all of them are healthy
+func (s Status) WriteJSON(w io.Writer) error {
+	components := s.Components
+	if components == nil {
+		components = []ComponentStatus{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Healthy    bool              `json:"healthy"`
+		Components []ComponentStatus `json:"components"`
+	}{s.Unhealthy() == 0, components})
+}
+
+// Status probes every component of the spec in the cluster without changing anything or logging, a component that
+// could not be probed is not healthy and has the error as detail
+func (k *k8sSetUpImpl) Status(ctx context.Context) (Status, error) {
+	if err := k.initializeCluster(ctx); err != nil {
+		return Status{}, err
+	}
+
+	status := Status{Components: []ComponentStatus{k.namespaceStatus(ctx)}}
+	if len(k.spec.Databases) != 0 {
+		status.Components = append(status.Components, k.psqlOperatorStatus(ctx))
+		for _, v := range k.spec.Databases {
+			status.Components = append(status.Components, k.databaseStatus(ctx, v.Manifest))
+		}
+	}
+	for _, v := range k.spec.Kafka {
+		status.Components = append(status.Components, k.kafkaStatus(ctx, v.Name)...)
+	}
+	return status, nil
+}
+
+func (k k8sSetUpImpl) namespaceStatus(ctx context.Context) ComponentStatus {
+	status := ComponentStatus{Name: "namespace/" + k.namespace()}
+	exists, err := k.cluster.resourceExists(ctx, "namespace", k.namespace(), k.namespace())
+	status.Installed = err == nil && exists
+	status.Ready, status.Healthy = status.Installed, status.Installed
+	if !status.Installed {
+		status.Detail = "not found"
+	}
+	return status
+}
+
+func (k k8sSetUpImpl) psqlOperatorStatus(ctx context.Context) ComponentStatus {
+	status := ComponentStatus{Name: "postgresql-operator"}
+	if installed, err := k.cluster.resourceExists(ctx, "service", "postgres-operator", k.namespace()); err != nil || !installed {
+		status.Detail = "service/postgres-operator not found"
+		return status
+	}
+	status.Installed = true
+	if image, err := k.cluster.getField(ctx, "deployment/postgres-operator", k.namespace(), ".spec.template.spec.containers[0].image"); err == nil {
+		status.Version = imageVersion(image)
+	}
+
+	pods, err := k.cluster.listResources(ctx, "pod", psqlOperatorSelector, k.namespace())
+	if err != nil {
+		status.Detail = err.Error()
+		return status
+	}
+	status.ReadyPods, status.Pods, status.Detail = k.countReadyPods(ctx, pods)
+	status.Ready = status.Pods != 0 && status.ReadyPods == status.Pods
+	status.Healthy = status.Ready
+	if status.Detail == "" && !status.Ready {
+		status.Detail = "not running"
+	}
+	return status
+}
+
+func (k k8sSetUpImpl) databaseStatus(ctx context.Context, fileName string) ComponentStatus {
+	status := ComponentStatus{Name: "database/" + fileName}
+	manifest, err := k.renderManifest(fileName, nil)
+	if err != nil {
+		status.Detail = err.Error()
+		return status
+	}
+	defer removeFile(manifest)
+	cluster, err := k.getClusterName(manifest)
+	if err != nil {
+		status.Detail = fmt.Sprintf("error getting cluster name from yaml file: %v", err)
+		return status
+	}
+
+	status.Name = "database/" + cluster
+	if created, err := k.cluster.resourceExists(ctx, "postgresql", cluster, k.namespace()); err != nil || !created {
+		status.Detail = fmt.Sprintf("postgresql/%s not found", cluster)
+		return status
+	}
+	status.Installed = true
+	status.Version, _ = k.cluster.getField(ctx, "postgresql/"+cluster, k.namespace(), ".spec.postgresql.version")
+
+	// the operator labels the pods of a database cluster with its name
+	pods, err := k.cluster.listResources(ctx, "pod", "cluster-name="+cluster, k.namespace())
+	var podDetail string
+	if err == nil {
+		status.ReadyPods, status.Pods, podDetail = k.countReadyPods(ctx, pods)
+		status.Ready, err = k.databaseClusterRunning(ctx, cluster)
+		status.Ready = status.Ready && status.ReadyPods == status.Pods
+	}
+	var jobDetail string
+	if err == nil {
+		status.Job, jobDetail, err = k.lastDatabaseJob(ctx, cluster)
+	}
+	status.Healthy = status.Ready && status.Job != jobFailed
+	switch {
+	case err != nil:
+		status.Detail = err.Error()
+	case podDetail != "":
+		status.Detail = podDetail
+	case !status.Ready:
+		status.Detail = "not running"
+	default:
+		status.Detail = jobDetail
+	}
+	return status
+}
+
+// lastDatabaseJob returns the result of the last job of a database cluster, empty when it has none, and why it
+// failed
+func (k k8sSetUpImpl) lastDatabaseJob(ctx context.Context, cluster string) (result, detail string, err error) {
+	jobs, err := k.cluster.listResources(ctx, "job", databaseJobsSelector(cluster), k.namespace())
+	if err != nil {
+		return "", "", err
+	}
+	var last, lastStart string
+	for _, job := range jobs {
+		start, err := k.cluster.getField(ctx, job, k.namespace(), ".status.startTime")
+		if err != nil {
+			return "", "", err
+		}
+		// RFC 3339 times in UTC sort as strings
+		if last == "" || start > lastStart {
+			last, lastStart = job, start
+		}
+	}
+	if last == "" {
+		return "", "", nil
+	}
+
+	succeeded, err := k.cluster.getField(ctx, last, k.namespace(), ".status.succeeded")
+	if err != nil {
+		return "", "", err
+	}
+	if succeeded != "" && succeeded != "0" {
+		return jobSucceeded, "", nil
+	}
+	failed, err := k.cluster.getField(ctx, last, k.namespace(), `.status.conditions[?(@.type=="Failed")].status`)
+	if err != nil {
+		return "", "", err
+	}
+	if failed == "True" {
+		reason, _ := k.cluster.getField(ctx, last, k.namespace(), `.status.conditions[?(@.type=="Failed")].reason`)
+		return jobFailed, fmt.Sprintf("%s failed, %s", last, reason), nil
+	}
+	return jobRunning, "", nil
+}
+
+// kafkaStatus returns the status of the zookeeper ensemble and of the brokers of a kafka cluster
+func (k k8sSetUpImpl) kafkaStatus(ctx context.Context, name string) []ComponentStatus {
+	kafka := k.spec.kafkaCluster(name)
+	components := []struct {
+		operator string
+		prefix   string
+		pods     int
+	}{
+		{"zookeeper", zookeeperPodPrefix(name), kafka.Zookeeper.Nodes},
+		{"kafka", kafkaPodPrefix(name), kafka.Brokers},
+	}
+
+	instances, err := k.getKudoInstances(ctx)
+	var pods []string
+	if err == nil && len(instances) != 0 {
+		pods, err = k.cluster.listResources(ctx, "pod", "", k.namespace())
+	}
+	statuses := make([]ComponentStatus, len(components))
+	for i, v := range components {
+		status := ComponentStatus{Name: v.operator + "/" + name}
+		instance := v.operator + "-" + name
+		for _, item := range instances {
+			if item.Metadata.Name == instance {
+				status.Installed = true
+				status.Version = strings.TrimPrefix(item.Spec.OperatorVersion.Name, v.operator+"-")
+			}
+		}
+		switch {
+		case err != nil:
+			status.Detail = err.Error()
+		case !status.Installed:
+			status.Detail = fmt.Sprintf("kudo instance %s not found", instance)
+		default:
+			status.ReadyPods, status.Pods, status.Detail = k.countReadyPods(ctx, statefulSetPods(pods, v.prefix))
+			// a pod of the spec that is missing is not running either
+			status.Ready = status.Pods >= v.pods && status.ReadyPods == status.Pods
+			if status.Detail == "" && status.Pods < v.pods {
+				status.Detail = fmt.Sprintf("%d of %d pods found", status.Pods, v.pods)
+			} else if status.Detail == "" && !status.Ready {
+				status.Detail = "not running"
+			}
+		}
+		status.Healthy = status.Ready
+		statuses[i] = status
+	}
+	return statuses
+}
+
+// statefulSetPods returns the pods of a stateful set, they are named with its prefix then their ordinal
+func statefulSetPods(pods []string, prefix string) (instancePods []string) {
+	for _, v := range pods {
+		name := strings.TrimPrefix(v, "pod/")
+		if ordinal := strings.TrimPrefix(name, prefix+"-"); ordinal != name {
+			if _, err := strconv.Atoi(ordinal); err == nil {
+				instancePods = append(instancePods, v)
+			}
+		}
+	}
+	return
+}
+
+// countReadyPods returns how many of the pods have their container ready and why the first one that is not ready
+// failed, empty when they are only starting
+func (k k8sSetUpImpl) countReadyPods(ctx context.Context, pods []string) (ready, total int, failure string) {
+	for _, pod := range pods {
+		output, err := k.cluster.getField(ctx, pod, k.namespace(), ".status.containerStatuses[0].ready")
+		if err == nil && output == "true" {
+			ready++
+			continue
+		}
+		if err == nil {
+			err = k.checkPodFailure(ctx, pod, k.namespace())
+		}
+		if err != nil && failure == "" {
+			failure = err.Error()
+		}
+	}
+	return ready, len(pods), failure
+}
+
+// imageVersion returns the tag of an image, the image itself when it has none
+func imageVersion(image string) string {
+	if i := strings.LastIndex(image, ":"); i != -1 && !strings.Contains(image[i:], "/") {
+		return image[i+1:]
+	}
+	return image
+}
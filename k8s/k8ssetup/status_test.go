@@ -0,0 +1,259 @@
+package k8ssetup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testPods = `pod/postgres-operator-6c8b7d9f5-x2k4p
+pod/cluster-0
+pod/cluster-1
+pod/zookeeper-pets-zookeeper-0
+pod/zookeeper-pets-zookeeper-1
+pod/zookeeper-pets-zookeeper-2
+pod/kafka-pets-kafka-0
+pod/kafka-pets-kafka-1
+pod/kafka-pets-kafka-2
+`
+
+// statusCommands answers the commands of the status like a cluster where the last job of the database failed and
+// the third kafka broker is not ready
+func statusCommands(ctx context.Context, cmdName string, params ...string) (string, error) {
+	command := strings.Join(params, " ")
+	field := func(ref, path string) bool {
+		return command == "get "+ref+" -o jsonpath='{"+path+"}' -n default"
+	}
+	switch {
+	case strings.HasPrefix(command, "describe namespace/default"),
+		strings.HasPrefix(command, "describe service/postgres-operator"),
+		strings.HasPrefix(command, "describe postgresql/cluster"):
+		return "", nil
+	case field("deployment/postgres-operator", ".spec.template.spec.containers[0].image"):
+		return "'registry.opensource.zalan.do/acid/postgres-operator:v1.5.0'", nil
+	case command == "get pod -o name -n default":
+		return testPods, nil
+	case command == "get pod -o name -n default -l "+psqlOperatorSelector:
+		return "pod/postgres-operator-6c8b7d9f5-x2k4p\n", nil
+	case command == "get pod -o name -n default -l cluster-name=cluster":
+		return "pod/cluster-0\npod/cluster-1\n", nil
+	case field("pod/kafka-pets-kafka-2", ".status.containerStatuses[0].ready"):
+		return "'false'", nil
+	case strings.HasSuffix(command, "-o jsonpath='{.status.containerStatuses[0].ready}' -n default"):
+		return "'true'", nil
+	case strings.HasSuffix(command, "-o jsonpath='{.status.containerStatuses[0].state.waiting.reason}' -n default"):
+		return "'ContainerCreating'", nil
+	case field("postgresql/cluster", ".spec.postgresql.version"):
+		return "'11'", nil
+	case field("postgresql/cluster", ".status.PostgresClusterStatus"):
+		return "'Running'", nil
+	case command == "get job -o name -n default -l "+databaseJobsSelector("cluster"):
+		return "job.batch/cluster-job-2\njob.batch/cluster-job-1\n", nil
+	case field("job.batch/cluster-job-1", ".status.startTime"):
+		return "'2026-10-01T10:00:00Z'", nil
+	case field("job.batch/cluster-job-2", ".status.startTime"):
+		return "'2026-10-02T10:00:00Z'", nil
+	case field("job.batch/cluster-job-2", ".status.succeeded"):
+		return "''", nil
+	case field("job.batch/cluster-job-2", `.status.conditions[?(@.type=="Failed")].status`):
+		return "'True'", nil
+	case field("job.batch/cluster-job-2", `.status.conditions[?(@.type=="Failed")].reason`):
+		return "'BackoffLimitExceeded'", nil
+	case strings.HasPrefix(command, "kudo get instances -o json"):
+		return KudoInstancesFound, nil
+	}
+	return "", errors.New("unexpected command " + command)
+}
+
+func Test_Status(t *testing.T) {
+	newStatus := func(commands func(ctx context.Context, cmdName string, params ...string) (string, error)) *k8sSetUpImpl {
+		spec := DefaultSpec()
+		spec.Databases[0].Manifest = "_test/psql-cluster.yml"
+		k8sImpl := NewK8sSetUpWithSpec(spec, Options{}).(*k8sSetUpImpl)
+		k8sImpl.executeCommand = commands
+		return k8sImpl
+	}
+
+	t.Run("must report the live state of every component", func(t *testing.T) {
+		_ = setUpTestFindKubectlPath(true)
+		defer tearDown()
+		got, err := newStatus(statusCommands).Status(context.Background())
+		if err != nil {
+			t.Fatalf("Got error %v, expect no error", err)
+		}
+
+		expect := []ComponentStatus{
+			{Name: "namespace/default", Installed: true, Ready: true, Healthy: true},
+			{Name: "postgresql-operator", Installed: true, Ready: true, Version: "v1.5.0", ReadyPods: 1, Pods: 1, Healthy: true},
+			{Name: "database/cluster", Installed: true, Ready: true, Version: "11", ReadyPods: 2, Pods: 2, Job: jobFailed,
+				Detail: "job.batch/cluster-job-2 failed, BackoffLimitExceeded"},
+			{Name: "zookeeper/pets", Installed: true, Ready: true, Version: "3.4.14-0.3.1", ReadyPods: 3, Pods: 3, Healthy: true},
+			{Name: "kafka/pets", Installed: true, Version: "2.5.1-1.3.3", ReadyPods: 2, Pods: 3, Detail: "not running"},
+		}
+		if len(got.Components) != len(expect) {
+			t.Fatalf("Got %+v, expect %+v", got.Components, expect)
+		}
+		for i, v := range expect {
+			if got.Components[i] != v {
+				t.Errorf("Got %+v, expect %+v", got.Components[i], v)
+			}
+		}
+		if unhealthy := got.Unhealthy(); unhealthy != 2 {
+			t.Fatalf("Got %v, expect %v", unhealthy, 2)
+		}
+	})
+
+	t.Run("must not log the probes", func(t *testing.T) {
+		_ = setUpTestFindKubectlPath(true)
+		defer tearDown()
+		logs := useTestLogger(t, InfoLevel, ConsoleFormat)
+		if _, err := newStatus(statusCommands).Status(context.Background()); err != nil {
+			t.Fatalf("Got error %v, expect no error", err)
+		}
+		if logs.Len() != 0 {
+			t.Fatalf("Got logs %q, expect none", logs.String())
+		}
+	})
+
+	t.Run("must tell why a database pod failed", func(t *testing.T) {
+		_ = setUpTestFindKubectlPath(true)
+		defer tearDown()
+		got, err := newStatus(func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			switch strings.Join(params, " ") {
+			case "get pod/cluster-1 -o jsonpath='{.status.containerStatuses[0].ready}' -n default":
+				return "'false'", nil
+			case "get pod/cluster-1 -o jsonpath='{.status.containerStatuses[0].state.waiting.reason}' -n default":
+				return "'ImagePullBackOff'", nil
+			}
+			return statusCommands(ctx, cmdName, params...)
+		}).Status(context.Background())
+		if err != nil {
+			t.Fatalf("Got error %v, expect no error", err)
+		}
+
+		expect := ComponentStatus{Name: "database/cluster", Installed: true, Version: "11", ReadyPods: 1, Pods: 2,
+			Job: jobFailed, Detail: "pod/cluster-1 is ImagePullBackOff"}
+		if got.Components[2] != expect {
+			t.Fatalf("Got %+v, expect %+v", got.Components[2], expect)
+		}
+	})
+
+	t.Run("must count the pods of the cluster instead of the ones of the spec", func(t *testing.T) {
+		_ = setUpTestFindKubectlPath(true)
+		defer tearDown()
+		k8sImpl := newStatus(statusCommands)
+		k8sImpl.spec.Kafka[0].Zookeeper.Nodes = 1
+		k8sImpl.spec.Kafka[0].Brokers = 4
+		got, err := k8sImpl.Status(context.Background())
+		if err != nil {
+			t.Fatalf("Got error %v, expect no error", err)
+		}
+
+		expect := []ComponentStatus{
+			{Name: "zookeeper/pets", Installed: true, Ready: true, Version: "3.4.14-0.3.1", ReadyPods: 3, Pods: 3, Healthy: true},
+			{Name: "kafka/pets", Installed: true, Version: "2.5.1-1.3.3", ReadyPods: 2, Pods: 3, Detail: "3 of 4 pods found"},
+		}
+		for i, v := range expect {
+			if component := got.Components[len(got.Components)-2+i]; component != v {
+				t.Errorf("Got %+v, expect %+v", component, v)
+			}
+		}
+	})
+
+	t.Run("must report the components that are not installed", func(t *testing.T) {
+		_ = setUpTestFindKubectlPath(true)
+		defer tearDown()
+		got, err := newStatus(func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "kudo" {
+				return "[]", nil
+			}
+			return "Error from server (NotFound)", errors.New("exit status 1")
+		}).Status(context.Background())
+		if err != nil {
+			t.Fatalf("Got error %v, expect no error", err)
+		}
+
+		expect := map[string]string{
+			"namespace/default":   "not found",
+			"postgresql-operator": "service/postgres-operator not found",
+			"database/cluster":    "postgresql/cluster not found",
+			"zookeeper/pets":      "kudo instance zookeeper-pets not found",
+			"kafka/pets":          "kudo instance kafka-pets not found",
+		}
+		for _, v := range got.Components {
+			if v.Installed || v.Healthy || v.Detail != expect[v.Name] {
+				t.Errorf("Got %+v, expect %q", v, expect[v.Name])
+			}
+		}
+		if unhealthy := got.Unhealthy(); unhealthy != len(expect) {
+			t.Fatalf("Got %v, expect %v", unhealthy, len(expect))
+		}
+	})
+
+	t.Run("must return an error without kubectl", func(t *testing.T) {
+		_ = setUpTestFindKubectlPath(false)
+		defer tearDown()
+		if _, err := newStatus(statusCommands).Status(context.Background()); err == nil {
+			t.Fatalf("Got no error, expect an error getting kubectl path")
+		}
+	})
+}
+
+func Test_StatusWrite(t *testing.T) {
+	status := Status{Components: []ComponentStatus{
+		{Name: "namespace/default", Installed: true, Ready: true, Healthy: true},
+		{Name: "database/cluster", Installed: true, Ready: true, Version: "11", ReadyPods: 1, Pods: 2, Job: jobFailed, Detail: "job failed"},
+	}}
+
+	t.Run("must write a row per component", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := status.Write(&out, TableReport); err != nil {
+			t.Fatalf("Got error %v, expect no error", err)
+		}
+		expect := "COMPONENT          INSTALLED  READY  VERSION  PODS  JOB     DETAIL\n" +
+			"namespace/default  yes        yes    -        -     -       -\n" +
+			"database/cluster   yes        yes    11       1/2   failed  job failed\n"
+		if got := out.String(); got != expect {
+			t.Fatalf("Got\n%s, expect\n%s", got, expect)
+		}
+	})
+
+	t.Run("must write the components as json", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := status.Write(&out, JSONReport); err != nil {
+			t.Fatalf("Got error %v, expect no error", err)
+		}
+		got := struct {
+			Healthy    bool              `json:"healthy"`
+			Components []ComponentStatus `json:"components"`
+		}{}
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatalf("Got error %v, expect json", err)
+		}
+		if got.Healthy || len(got.Components) != 2 || got.Components[1] != status.Components[1] {
+			t.Fatalf("Got %+v, expect the unhealthy database", got)
+		}
+	})
+
+	t.Run("must return an error on an unsupported format", func(t *testing.T) {
+		if err := status.Write(&bytes.Buffer{}, JUnitReport); err == nil {
+			t.Fatalf("Got no error, expect an unsupported format")
+		}
+	})
+}
+
+func Test_imageVersion(t *testing.T) {
+	cases := map[string]string{
+		"registry.opensource.zalan.do/acid/postgres-operator:v1.5.0": "v1.5.0",
+		"localhost:5000/kudobuilder/controller":                      "localhost:5000/kudobuilder/controller",
+		"kudobuilder/controller":                                     "kudobuilder/controller",
+	}
+	for image, expect := range cases {
+		if got := imageVersion(image); got != expect {
+			t.Errorf("Got %q, expect %q", got, expect)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package k8ssetup
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	jobGroupSelector = "job-group=petstore-jobs"
+	// psqlOperatorSelector selects the pods of the operator deployment
+	psqlOperatorSelector = "name=postgres-operator"
+)
+
+// ownedBy tells if a resource listed as kind/name is the one named match or, when match is a stateful set, one of its
+// pods <match>-<ordinal> or of their volume claims <volume>-<match>-<ordinal>, so an instance whose name only starts
+// with match is not ours, every resource matches an empty match
+func ownedBy(resource, match string) bool {
+	if match == "" {
+		return true
+	}
+	name := resource[strings.Index(resource, "/")+1:]
+	if name == match {
+		return true
+	}
+	i := strings.LastIndex(name, "-")
+	if i == -1 {
+		return false
+	}
+	if _, err := strconv.Atoi(name[i+1:]); err != nil {
+		return false
+	}
+	name = name[:i]
+	return name == match || strings.HasSuffix(name, "-"+match)
+}
+
+// describeMatch tells which resources a match and a selector select in the messages
+func describeMatch(match, selector string) string {
+	if match == "" {
+		return fmt.Sprintf("with label %q", selector)
+	}
+	return fmt.Sprintf("matching %q", match)
+}
+
+func (k k8sSetUpImpl) remainingResources(ctx context.Context, kinds []string, match, selector, namespace string) (remaining []string) {
+	for _, kind := range kinds {
+		names, err := k.cluster.listResources(ctx, kind, selector, namespace)
+		if err != nil {
+			remaining = append(remaining, fmt.Sprintf("%s %s (%v)", kind, describeMatch(match, selector), err))
+			continue
+		}
+		for _, name := range names {
+			if ownedBy(name, match) {
+				remaining = append(remaining, name)
+			}
+		}
+	}
+	return
+}
+
+func (k k8sSetUpImpl) waitResourcesDeleted(ctx context.Context, kinds []string, match, selector, namespace string) (remaining []string) {
+	what := fmt.Sprintf("%s %s deleted", strings.Join(kinds, ", "), describeMatch(match, selector))
+	if err := k.waitFor(ctx, what, k.spec.Timeouts.Deletion, func(ctx context.Context) (bool, error) {
+		remaining = k.remainingResources(ctx, kinds, match, selector, namespace)
+		return len(remaining) == 0, nil
+	}); err != nil {
+		logger.Warnf(ctx, "Error waiting for %s: %v", what, err)
+		return
+	}
+	return nil
+}
+
+func (k k8sSetUpImpl) deletePersistentVolumeClaims(ctx context.Context, match, selector, namespace string) (remaining []string) {
+	names, err := k.cluster.listResources(ctx, "pvc", selector, namespace)
+	if err != nil {
+		return []string{fmt.Sprintf("pvc %s (%v)", describeMatch(match, selector), err)}
+	}
+	for _, name := range names {
+		if ownedBy(name, match) {
+			logger.Infof(ctx, "Deleting %q ...", name)
+			if err := k.cluster.deleteResource(ctx, name, namespace); err != nil {
+				remaining = append(remaining, fmt.Sprintf("%s (%v)", name, err))
+			}
+		}
+	}
+	return
+}
+
+// deleteKudoInstance uninstalls an instance then deletes the volume claims of its stateful set
+func (k k8sSetUpImpl) deleteKudoInstance(ctx context.Context, instance, statefulSet string) (remaining []string) {
+	logger.Infof(ctx, "Deleting kudo instance %q ...", instance)
+	if created, err := k.isKudoInstanceCreated(ctx, instance); err != nil {
+		return []string{fmt.Sprintf("kudo instance %q (%v)", instance, err)}
+	} else if created {
+		if _, err := k.cluster.kudo(ctx, "uninstall", "--instance", instance, "--namespace", k.namespace()); err != nil {
+			return []string{fmt.Sprintf("kudo instance %q (%v)", instance, err)}
+		}
+		recordResource(ctx, "kudo-instance/"+instance, resourceDeleted)
+	} else {
+		logger.Infof(ctx, "Kudo instance %q does not exist ...", instance)
+	}
+	remaining = append(remaining, k.waitResourcesDeleted(ctx, []string{"pod"}, statefulSet, "", k.namespace())...)
+	remaining = append(remaining, k.deletePersistentVolumeClaims(ctx, statefulSet, "", k.namespace())...)
+	remaining = append(remaining, k.waitResourcesDeleted(ctx, []string{"pvc"}, statefulSet, "", k.namespace())...)
+	return
+}
+
+func (k k8sSetUpImpl) deleteKafkaCluster(ctx context.Context, name string) (remaining []string) {
+	logger.Infof(ctx, "Deleting kafka cluster %q ...", name)
+	remaining = append(remaining, k.deleteKudoInstance(ctx, "kafka-"+name, kafkaPodPrefix(name))...)
+	remaining = append(remaining, k.deleteKudoInstance(ctx, "zookeeper-"+name, zookeeperPodPrefix(name))...)
+	return
+}
+
+// deleteDatabaseJobs deletes the jobs of a database cluster, the jobs of the other clusters of the namespace are kept
+func (k k8sSetUpImpl) deleteDatabaseJobs(ctx context.Context, cluster string) (remaining []string) {
+	logger.Infof(ctx, "Deleting database jobs of cluster %q ...", cluster)
+	selector := databaseJobsSelector(cluster)
+	jobs, err := k.cluster.listResources(ctx, "job", selector, k.namespace())
+	if err != nil {
+		return []string{fmt.Sprintf("jobs with label %q (%v)", selector, err)}
+	}
+	if err = k.cluster.deleteSelected(ctx, "job", selector, k.namespace()); err != nil {
+		return []string{fmt.Sprintf("jobs with label %q (%v)", selector, err)}
+	}
+	remaining = k.waitResourcesDeleted(ctx, []string{"job"}, "", selector, k.namespace())
+	if len(jobs) == 0 {
+		return
+	}
+	// the pods of a job only have its name, the pods of the database itself have the label of the cluster
+	names := make([]string, len(jobs))
+	for i, v := range jobs {
+		names[i] = v[strings.Index(v, "/")+1:]
+	}
+	podSelector := fmt.Sprintf("job-name in (%s)", strings.Join(names, ","))
+	return append(remaining, k.waitResourcesDeleted(ctx, []string{"pod"}, "", podSelector, k.namespace())...)
+}
+
+func (k k8sSetUpImpl) deleteDatabase(ctx context.Context, fileName string) (remaining []string) {
+	logger.Infof(ctx, "Deleting database from file %q ...", fileName)
+	manifest, err := k.renderManifest(fileName, nil)
+	if err != nil {
+		return []string{fmt.Sprintf("database cluster from file %q (%v)", fileName, err)}
+	}
+	defer removeFile(manifest)
+
+	cluster, err := k.getClusterName(manifest)
+	if err != nil {
+		return []string{fmt.Sprintf("database cluster from file %q (%v)", fileName, err)}
+	}
+
+	remaining = append(remaining, k.deleteDatabaseJobs(ctx, cluster)...)
+	if err := k.cluster.deleteFile(ctx, manifest, k.namespace()); err != nil {
+		return append(remaining, fmt.Sprintf("postgresql/%s (%v)", cluster, err))
+	}
+	recordResource(ctx, "postgresql/"+cluster, resourceDeleted)
+	// the operator labels the pods, the volume claims and the secrets of a database cluster with its name
+	selector := "cluster-name=" + cluster
+	remaining = append(remaining, k.waitResourcesDeleted(ctx, []string{"postgresql"}, cluster, "", k.namespace())...)
+	remaining = append(remaining, k.waitResourcesDeleted(ctx, []string{"pod"}, "", selector, k.namespace())...)
+	remaining = append(remaining, k.deletePersistentVolumeClaims(ctx, "", selector, k.namespace())...)
+	remaining = append(remaining, k.waitResourcesDeleted(ctx, []string{"pvc", "secret"}, "", selector, k.namespace())...)
+	return
+}
+
+func (k k8sSetUpImpl) uninstallPsqlOperator(ctx context.Context) (remaining []string) {
+	logger.Infof(ctx, "Uninstalling PostgreSQL operator ...")
+	fsys, err := k.psqlOperatorFiles(ctx)
+	if err != nil {
+		return []string{fmt.Sprintf("postgresql operator (%v)", err)}
+	}
+
+	for i := len(psqlOperatorManifests) - 1; i >= 0; i-- {
+		manifest := psqlOperatorManifests[i]
+		logger.Infof(ctx, "Deleting %q", manifest)
+		fileName, clusterScoped, err := k.splitPsqlOperatorManifest(fsys, manifest)
+		if err != nil {
+			remaining = append(remaining, fmt.Sprintf("%s (%v)", manifest, err))
+			continue
+		}
+		if fileName != "" {
+			if err = k.cluster.deleteFile(ctx, fileName, k.namespace()); err != nil {
+				remaining = append(remaining, fmt.Sprintf("%s (%v)", manifest, err))
+			} else {
+				recordResource(ctx, manifest, resourceDeleted)
+			}
+			removeFile(fileName)
+		}
+		remaining = append(remaining, k.releaseClusterScoped(ctx, clusterScoped)...)
+	}
+	remaining = append(remaining, k.waitResourcesDeleted(ctx, []string{"pod"}, "", psqlOperatorSelector, k.namespace())...)
+	return
+}
+
+func (k *k8sSetUpImpl) Teardown(ctx context.Context, databaseFiles []string, kafkaClusters []string) error {
+	logger.Infof(ctx, "Tearing down infrastructure ...")
+
+	var remaining []string
+	for i := len(kafkaClusters) - 1; i >= 0; i-- {
+		remaining = append(remaining, k.deleteKafkaCluster(ctx, kafkaClusters[i])...)
+	}
+	for i := len(databaseFiles) - 1; i >= 0; i-- {
+		remaining = append(remaining, k.deleteDatabase(ctx, databaseFiles[i])...)
+	}
+	if len(databaseFiles) != 0 {
+		remaining = append(remaining, k.uninstallPsqlOperator(ctx)...)
+	}
+
+	if len(remaining) != 0 {
+		for _, v := range remaining {
+			logger.Warnf(ctx, "Left behind: %s", v)
+		}
+		return fmt.Errorf("teardown left %d resource(s) behind: %s", len(remaining), strings.Join(remaining, ", "))
+	}
+
+	logger.Infof(ctx, "Infrastructure teardown completed ...")
+	return nil
+}
@@ -0,0 +1,313 @@
+package k8ssetup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_waitResourcesDeleted(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+
+	t.Run("must wait until resources are deleted", func(t *testing.T) {
+		count := 0
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			count++
+			if count == 3 {
+				return "pod/other-0", nil
+			}
+			return "pod/cluster-0\npod/other-0", nil
+		}
+
+		got := k8sImpl.waitResourcesDeleted(context.Background(), []string{"pod"}, "cluster", "", "default")
+		if len(got) != 0 {
+			t.Fatalf("Got %v, expect nothing remaining", got)
+		}
+		if count != 3 {
+			t.Fatalf("Got %d checks, expect %d", count, 3)
+		}
+	})
+
+	t.Run("must return what is remaining after all checks", func(t *testing.T) {
+		k8sImpl.spec.Timeouts.Deletion = Duration(20 * time.Millisecond)
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			return "pvc/pgdata-cluster-0", nil
+		}
+
+		expect := []string{"pvc/pgdata-cluster-0"}
+		got := k8sImpl.waitResourcesDeleted(context.Background(), []string{"pvc"}, "cluster", "", "default")
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+}
+
+func Test_deleteKafkaCluster(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+
+	t.Run("must uninstall kafka and zookeeper and delete their volumes", func(t *testing.T) {
+		var commands []string
+		pvcs := "persistentvolumeclaim/data-kafka-pets-kafka-0\npersistentvolumeclaim/data-zookeeper-pets-zookeeper-0"
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			cmd := strings.Join(params, " ")
+			commands = append(commands, cmd)
+			switch {
+			case cmd == "kudo get instances -o json --namespace default":
+				return KudoInstancesFound, nil
+			case params[0] == "get" && params[1] == "pvc":
+				return pvcs, nil
+			case params[0] == "delete":
+				pvcs = ""
+			}
+			return "", nil
+		}
+
+		got := k8sImpl.deleteKafkaCluster(context.Background(), "pets")
+		if len(got) != 0 {
+			t.Fatalf("Got %v, expect nothing remaining", got)
+		}
+		expect := []string{
+			"kudo uninstall --instance kafka-pets --namespace default",
+			"delete persistentvolumeclaim/data-kafka-pets-kafka-0 -n default --ignore-not-found",
+			"kudo uninstall --instance zookeeper-pets --namespace default",
+		}
+		for _, v := range expect {
+			if !strings.Contains(strings.Join(commands, "\n"), v) {
+				t.Fatalf("Got commands %v, expect %q", commands, v)
+			}
+		}
+	})
+
+	t.Run("must keep the volumes of an instance whose name starts with ours", func(t *testing.T) {
+		var deletes []string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			switch {
+			case params[0] == "kudo":
+				return KudoInstancesFound, nil
+			case params[0] == "get" && params[1] == "pod":
+				return "pod/kafka-pets-ci-kafka-0\npod/zookeeper-pets-ci-zookeeper-0", nil
+			case params[0] == "get" && params[1] == "pvc" && len(deletes) == 0:
+				return "persistentvolumeclaim/data-kafka-pets-kafka-0\npersistentvolumeclaim/data-kafka-pets-ci-kafka-0\n" +
+					"persistentvolumeclaim/data-kafka-pets-kafka-10\npersistentvolumeclaim/data-zookeeper-pets-ci-zookeeper-0", nil
+			case params[0] == "get" && params[1] == "pvc":
+				return "persistentvolumeclaim/data-kafka-pets-ci-kafka-0\npersistentvolumeclaim/data-zookeeper-pets-ci-zookeeper-0", nil
+			case params[0] == "delete":
+				deletes = append(deletes, params[1])
+			}
+			return "", nil
+		}
+
+		got := k8sImpl.deleteKafkaCluster(context.Background(), "pets")
+		if len(got) != 0 {
+			t.Fatalf("Got %v, expect nothing remaining", got)
+		}
+		expect := []string{"persistentvolumeclaim/data-kafka-pets-kafka-0", "persistentvolumeclaim/data-kafka-pets-kafka-10"}
+		if !reflect.DeepEqual(deletes, expect) {
+			t.Fatalf("Got deletes %v, expect %v", deletes, expect)
+		}
+	})
+
+	t.Run("must skip uninstall when instances do not exist", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "kudo" && params[1] == "uninstall" {
+				t.Fatalf("Unexpected uninstall %v", params)
+			}
+			if params[0] == "kudo" {
+				return "[]", nil
+			}
+			return "", nil
+		}
+
+		got := k8sImpl.deleteKafkaCluster(context.Background(), "pets")
+		if len(got) != 0 {
+			t.Fatalf("Got %v, expect nothing remaining", got)
+		}
+	})
+
+	t.Run("must report instances that could not be uninstalled", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "kudo" && params[1] == "uninstall" {
+				return "error", errors.New("error uninstalling")
+			}
+			return KudoInstancesFound, nil
+		}
+
+		got := k8sImpl.deleteKafkaCluster(context.Background(), "pets")
+		if len(got) != 2 || !strings.Contains(got[0], "kafka-pets") || !strings.Contains(got[1], "zookeeper-pets") {
+			t.Fatalf("Got %v, expect kafka and zookeeper instances remaining", got)
+		}
+	})
+}
+
+func Test_deleteDatabase(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+
+	t.Run("must delete jobs, cluster and volumes", func(t *testing.T) {
+		var deletes []string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "delete" {
+				if params[1] == "-f" && strings.HasSuffix(params[2], "-psql-cluster.yml") {
+					// the manifest is deleted from a temp file with its variables replaced
+					params[2] = "psql-cluster.yml"
+				}
+				deletes = append(deletes, strings.Join(params, " "))
+				return "", nil
+			}
+			if strings.Join(params, " ") == "get pvc -o name -n default -l cluster-name=cluster" && len(deletes) < 3 {
+				return "persistentvolumeclaim/pgdata-cluster-0", nil
+			}
+			return "", nil
+		}
+
+		got := k8sImpl.deleteDatabase(context.Background(), getFilePath("psql-cluster.yml"))
+		if len(got) != 0 {
+			t.Fatalf("Got %v, expect nothing remaining", got)
+		}
+		expect := []string{
+			"delete job -l job-group=petstore-jobs,cluster-name=cluster -n default --ignore-not-found",
+			"delete -f psql-cluster.yml -n default --ignore-not-found",
+			"delete persistentvolumeclaim/pgdata-cluster-0 -n default --ignore-not-found",
+		}
+		if !reflect.DeepEqual(deletes, expect) {
+			t.Fatalf("Got %v, expect %v", deletes, expect)
+		}
+	})
+
+	t.Run("must report the cluster when the file is not valid", func(t *testing.T) {
+		got := k8sImpl.deleteDatabase(context.Background(), getFilePath("invalid.yml"))
+		if len(got) != 1 || !strings.Contains(got[0], "database cluster from file") {
+			t.Fatalf("Got %v, expect database cluster remaining", got)
+		}
+	})
+
+	t.Run("must report the cluster when it could not be deleted", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "delete" && params[1] == "-f" {
+				return "error", errors.New("error deleting")
+			}
+			return "", nil
+		}
+
+		got := k8sImpl.deleteDatabase(context.Background(), getFilePath("psql-cluster.yml"))
+		if len(got) != 1 || !strings.Contains(got[0], "postgresql/cluster") {
+			t.Fatalf("Got %v, expect postgresql/cluster remaining", got)
+		}
+	})
+}
+
+func Test_deleteDatabaseJobs(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+
+	t.Run("must delete only the jobs of the cluster and wait for their pods", func(t *testing.T) {
+		var commands []string
+		deleted := false
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			command := strings.Join(params, " ")
+			commands = append(commands, command)
+			switch {
+			case params[0] == "delete":
+				deleted = true
+			case command == "get job -o name -n default -l "+databaseJobsSelector("cluster") && !deleted:
+				return "job.batch/cluster-run-x1\njob.batch/cluster-run-x2", nil
+			case command == "get pod -o name -n default -l job-name in (cluster-run-x1,cluster-run-x2)" && len(commands) < 5:
+				return "pod/cluster-run-x1-abcde", nil
+			}
+			return "", nil
+		}
+
+		if got := k8sImpl.deleteDatabaseJobs(context.Background(), "cluster"); len(got) != 0 {
+			t.Fatalf("Got %v, expect nothing remaining", got)
+		}
+		expect := []string{
+			"get job -o name -n default -l job-group=petstore-jobs,cluster-name=cluster",
+			"delete job -l job-group=petstore-jobs,cluster-name=cluster -n default --ignore-not-found",
+			"get job -o name -n default -l job-group=petstore-jobs,cluster-name=cluster",
+			"get pod -o name -n default -l job-name in (cluster-run-x1,cluster-run-x2)",
+			"get pod -o name -n default -l job-name in (cluster-run-x1,cluster-run-x2)",
+		}
+		if !reflect.DeepEqual(commands, expect) {
+			t.Fatalf("Got %v, expect %v", commands, expect)
+		}
+	})
+}
+
+func Test_uninstallPsqlOperator(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+
+	t.Run("must delete the manifests in reverse order", func(t *testing.T) {
+		k8sImpl.psqlOperatorRepo = newLocalPsqlOperatorRepo(t)
+		var deletes []string
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "delete" {
+				deletes = append(deletes, params[2])
+			}
+			return "", nil
+		}
+
+		got := k8sImpl.uninstallPsqlOperator(context.Background())
+		if len(got) != 0 {
+			t.Fatalf("Got %v, expect nothing remaining", got)
+		}
+		if len(deletes) != len(psqlOperatorManifests) || !strings.HasSuffix(deletes[0], "api-service.yaml") {
+			t.Fatalf("Got %v, expect manifests deleted in reverse order", deletes)
+		}
+	})
+
+	t.Run("must report the operator when repo clone fails", func(t *testing.T) {
+		k8sImpl.psqlOperatorRepo = "http://no-repo.com"
+		got := k8sImpl.uninstallPsqlOperator(context.Background())
+		if len(got) != 1 || !strings.Contains(got[0], "error clonning postgres operator") {
+			t.Fatalf("Got %v, expect postgresql operator remaining", got)
+		}
+	})
+}
+
+func Test_Teardown(t *testing.T) {
+	// setup
+	wd, _ := os.Getwd()
+	os.Chdir("_test")
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	k8sImpl.spec.Timeouts.Deletion = Duration(20 * time.Millisecond)
+
+	t.Run("we should tear down everything", func(t *testing.T) {
+		k8sImpl.psqlOperatorRepo = newLocalPsqlOperatorRepo(t)
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "kudo" && params[1] == "get" {
+				return "[]", nil
+			}
+			return "", nil
+		}
+
+		var expect error = nil
+		got := k8sImpl.Teardown(context.Background(), []string{"psql-cluster.yml"}, []string{"pets"})
+		if got != expect {
+			t.Fatalf("Got error %v, expect error %v", got, expect)
+		}
+	})
+
+	t.Run("we should report what was left behind", func(t *testing.T) {
+		k8sImpl.psqlOperatorRepo = newLocalPsqlOperatorRepo(t)
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "kudo" && params[1] == "get" {
+				return "[]", nil
+			}
+			if params[0] == "get" && params[1] == "secret" {
+				return "secret/petdba.cluster.credentials", nil
+			}
+			return "", nil
+		}
+
+		expect := "teardown left 1 resource(s) behind: secret/petdba.cluster.credentials"
+		got := k8sImpl.Teardown(context.Background(), []string{"psql-cluster.yml"}, []string{"pets"})
+		if got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect error %v", got, expect)
+		}
+	})
+
+	//tear down
+	os.Chdir(wd)
+}
@@ -0,0 +1,120 @@
+package k8ssetup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	namespaceTemplateVar     = "NAMESPACE"
+	clusterNameTemplateVar   = "CLUSTER_NAME"
+	registryTemplateVar      = "DOCKER_REGISTRY"
+	registryK8sTemplateVar   = "DOCKER_REGISTRY_K8S"
+	jobImageTemplateVar      = "JOB_IMAGE"
+	schemaVersionTemplateVar = "SCHEMA_VERSION"
+)
+
+var (
+	// templateVarRegex matches $$, $NAME, ${NAME} and ${NAME:-default}
+	templateVarRegex  = regexp.MustCompile(`\$(\$|[A-Za-z_][A-Za-z0-9_]*|\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\})`)
+	variableNameRegex = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+	// computedVariables are set by us for every manifest, they could not be set in the spec
+	computedVariables = []string{namespaceTemplateVar, clusterNameTemplateVar, registryTemplateVar, registryK8sTemplateVar,
+		jobImageTemplateVar, schemaVersionTemplateVar}
+)
+
+// renderTemplate replaces the variables of content, a variable without value nor default is an error
+func renderTemplate(name, content string, lookup func(name string) (string, bool)) (string, error) {
+	var undefined []string
+	lines := strings.SplitAfter(content, "\n")
+	for i, line := range lines {
+		lines[i] = templateVarRegex.ReplaceAllStringFunc(line, func(match string) string {
+			if match == "$$" {
+				return "$"
+			}
+			groups := templateVarRegex.FindStringSubmatch(match)
+			varName, defaultValue := groups[1], ""
+			if groups[2] != "" {
+				varName, defaultValue = groups[2], strings.TrimPrefix(groups[3], ":-")
+			}
+			if value, ok := lookup(varName); ok {
+				return value
+			}
+			if groups[3] != "" {
+				return defaultValue
+			}
+			undefined = append(undefined, fmt.Sprintf("%s (line %d)", varName, i+1))
+			return match
+		})
+	}
+	if len(undefined) != 0 {
+		return "", fmt.Errorf("undefined variables in %q: %s", name, strings.Join(undefined, ", "))
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// templateValue returns the value of a variable, computed values go first, then the spec variables and then the environment
+func (k k8sSetUpImpl) templateValue(computed map[string]string, name string) (string, bool) {
+	if value, ok := computed[name]; ok {
+		return value, true
+	}
+	if value, ok := k.spec.Variables[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+func (k k8sSetUpImpl) computedValues(values map[string]string) map[string]string {
+	computed := map[string]string{
+		namespaceTemplateVar:   k.namespace(),
+		registryTemplateVar:    trimScheme(k.dockerRegistry),
+		registryK8sTemplateVar: trimScheme(k.dockerRegistryK8s),
+	}
+	for name, value := range values {
+		computed[name] = value
+	}
+	return computed
+}
+
+func trimScheme(url string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+}
+
+// renderManifest writes the manifest with its variables replaced in a temp file and returns its name,
+// values are computed values of the manifest like its cluster name
+func (k k8sSetUpImpl) renderManifest(fileName string, values map[string]string) (string, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("error reading file %q", fileName)
+	}
+	computed := k.computedValues(values)
+	rendered, err := renderTemplate(fileName, string(content), func(name string) (string, bool) {
+		return k.templateValue(computed, name)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	_, onlyFileName := path.Split(fileName)
+	newFile, err := ioutil.TempFile("", "*-"+onlyFileName)
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file for %q", fileName)
+	}
+	defer newFile.Close()
+	if _, err = newFile.WriteString(rendered); err != nil {
+		removeFile(newFile.Name())
+		return "", fmt.Errorf("error writting in temp file %q", newFile.Name())
+	}
+	return newFile.Name(), nil
+}
+
+func removeFile(fileName string) {
+	if err := os.Remove(fileName); err != nil {
+		logger.Warnf(context.Background(), "error removing file %s: %v", fileName, err)
+	}
+}
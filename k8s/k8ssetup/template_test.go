@@ -0,0 +1,108 @@
+package k8ssetup
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_renderTemplate(t *testing.T) {
+	values := map[string]string{"NAMESPACE": "pets", "IMAGE": "localhost:5000/job", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+
+	type TestCase struct {
+		name    string
+		content string
+		expect  string
+		err     string
+	}
+
+	cases := []TestCase{
+		{
+			name:    "must replace plain and braced variables",
+			content: "namespace: $NAMESPACE\nimage: ${IMAGE}:latest\n",
+			expect:  "namespace: pets\nimage: localhost:5000/job:latest\n",
+		},
+		{
+			name:    "must use the default when the variable is not defined",
+			content: "size: ${VOLUME_SIZE:-1Gi}\nnamespace: ${NAMESPACE:-default}",
+			expect:  "size: 1Gi\nnamespace: pets",
+		},
+		{
+			name:    "must keep empty values",
+			content: "value: \"$EMPTY\"",
+			expect:  "value: \"\"",
+		},
+		{
+			name:    "must escape dollars",
+			content: "command: echo $$HOME costs $ 5",
+			expect:  "command: echo $HOME costs $ 5",
+		},
+		{
+			name:    "must return error with every undefined variable",
+			content: "namespace: $NAMESPACE\nimage: $IMAGE_TYPO\nsize: ${SIZE}\n",
+			err:     "undefined variables in \"job.yml\": IMAGE_TYPO (line 2), SIZE (line 3)",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotErr := renderTemplate("job.yml", tt.content, lookup)
+			if tt.err != "" {
+				if gotErr == nil || gotErr.Error() != tt.err {
+					t.Fatalf("Got error %v, expect %v", gotErr, tt.err)
+				}
+				return
+			}
+			if gotErr != nil {
+				t.Fatalf("Got error %v, expect nil", gotErr)
+			}
+			if got != tt.expect {
+				t.Fatalf("Got %q, expect %q", got, tt.expect)
+			}
+		})
+	}
+}
+
+func Test_renderManifest(t *testing.T) {
+	spec := DefaultSpec()
+	spec.Namespace = "pets-staging"
+	spec.Variables = map[string]string{"TEAM": "petstore", "PETS_TEST_VAR": "spec"}
+	k8sImpl := NewK8sSetUpWithSpec(spec, Options{}).(*k8sSetUpImpl)
+	k8sImpl.dockerRegistryK8s = "https://registry.local:5000"
+
+	_ = os.Setenv("PETS_TEST_VAR", "environment")
+	_ = os.Setenv("PETS_TEST_ENV", "environment")
+	defer os.Unsetenv("PETS_TEST_VAR")
+	defer os.Unsetenv("PETS_TEST_ENV")
+
+	fileName := tempDir(t) + "/manifest.yml"
+	content := "$NAMESPACE $CLUSTER_NAME $DOCKER_REGISTRY_K8S $TEAM $PETS_TEST_VAR $PETS_TEST_ENV"
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatalf("Got error %v, expect nil", err)
+	}
+
+	t.Run("must use computed values, then spec variables and then the environment", func(t *testing.T) {
+		rendered, gotErr := k8sImpl.renderManifest(fileName, map[string]string{clusterNameTemplateVar: "cluster"})
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		defer removeFile(rendered)
+		got, _ := ioutil.ReadFile(rendered)
+		expect := "pets-staging cluster registry.local:5000 petstore spec environment"
+		if string(got) != expect {
+			t.Fatalf("Got %q, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must return error when a computed value is missing", func(t *testing.T) {
+		expect := "CLUSTER_NAME (line 1)"
+		if _, got := k8sImpl.renderManifest(fileName, nil); got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package k8ssetup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	kafkaTopicsCommand   = "/opt/kafka/bin/kafka-topics.sh"
+	kafkaConfigsCommand  = "/opt/kafka/bin/kafka-configs.sh"
+	kafkaBootstrapServer = "localhost:9093"
+)
+
+// topicDescription is what kafka-topics.sh --describe tells about a topic
+type topicDescription struct {
+	partitions        int
+	replicationFactor int
+	configs           map[string]string
+}
+
+func kafkaBrokerPod(cluster string) string {
+	return fmt.Sprintf("pod/%s-0", kafkaPodPrefix(cluster))
+}
+
+func (k k8sSetUpImpl) kafkaTopicsCommand(ctx context.Context, cluster string, params ...string) (string, error) {
+	command := append([]string{kafkaTopicsCommand, "--bootstrap-server", kafkaBootstrapServer}, params...)
+	return k.cluster.exec(ctx, kafkaBrokerPod(cluster), k.namespace(), command...)
+}
+
+func (k k8sSetUpImpl) listTopics(ctx context.Context, cluster string) (map[string]bool, error) {
+	output, err := k.kafkaTopicsCommand(ctx, cluster, "--list")
+	if err != nil {
+		return nil, fmt.Errorf("error listing topics: %v", err)
+	}
+	topics := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); topicNameRegex.MatchString(line) {
+			topics[line] = true
+		}
+	}
+	return topics, nil
+}
+
+// existingTopics lists the topics of the kafka cluster, in plan mode a broker that is not running was only planned so
+// the cluster has no topic yet
+func (k k8sSetUpImpl) existingTopics(ctx context.Context, cluster string) (map[string]bool, error) {
+	if k.planMode() {
+		if running, err := k.isPodRunning(ctx, kafkaPodPrefix(cluster)+"-0", k.namespace()); err != nil || !running {
+			logger.Infof(ctx, "Kafka broker of cluster %q is not running, every topic will be created ...", cluster)
+			return map[string]bool{}, nil
+		}
+	}
+	return k.listTopics(ctx, cluster)
+}
+
+// parseTopicDescription reads the summary line of kafka-topics.sh --describe, like
+// "Topic: pet-commands	PartitionCount: 3	ReplicationFactor: 3	Configs: min.insync.replicas=2"
+func parseTopicDescription(topic, output string) (*topicDescription, error) {
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "Topic: "+topic) || !strings.Contains(line, "PartitionCount:") {
+			continue
+		}
+		description := &topicDescription{configs: map[string]string{}}
+		for _, field := range strings.Split(line, "\t") {
+			parts := strings.SplitN(strings.TrimSpace(field), ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			value := strings.TrimSpace(parts[1])
+			var err error
+			switch parts[0] {
+			case "PartitionCount":
+				description.partitions, err = strconv.Atoi(value)
+			case "ReplicationFactor":
+				description.replicationFactor, err = strconv.Atoi(value)
+			case "Configs":
+				for _, config := range strings.Split(value, ",") {
+					if kv := strings.SplitN(config, "=", 2); len(kv) == 2 {
+						description.configs[kv[0]] = kv[1]
+					}
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid description of topic %q: %v", topic, err)
+			}
+		}
+		return description, nil
+	}
+	return nil, fmt.Errorf("no description found for topic %q", topic)
+}
+
+func (k k8sSetUpImpl) describeTopic(ctx context.Context, cluster, topic string) (*topicDescription, error) {
+	output, err := k.kafkaTopicsCommand(ctx, cluster, "--describe", "--topic", topic)
+	if err != nil {
+		return nil, fmt.Errorf("error describing topic %q: %v", topic, err)
+	}
+	return parseTopicDescription(topic, output)
+}
+
+func sortedConfigs(configs map[string]string) (pairs []string) {
+	for k, v := range configs {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return
+}
+
+func (k k8sSetUpImpl) createTopic(ctx context.Context, cluster string, topic TopicSpec) error {
+	logger.Infof(ctx, "Creating topic %q ...", topic.Name)
+	params := []string{"--create", "--if-not-exists", "--topic", topic.Name,
+		"--partitions", strconv.Itoa(topic.Partitions), "--replication-factor", strconv.Itoa(topic.ReplicationFactor)}
+	for _, v := range sortedConfigs(topic.Configs) {
+		params = append(params, "--config", v)
+	}
+	if _, err := k.kafkaTopicsCommand(ctx, cluster, params...); err != nil {
+		return fmt.Errorf("error creating topic %q: %v", topic.Name, err)
+	}
+	recordResource(ctx, "topic/"+topic.Name, resourceCreated)
+	return nil
+}
+
+// alterTopic changes what could be changed in a topic, partitions could only grow and replication factor is fixed
+func (k k8sSetUpImpl) alterTopic(ctx context.Context, cluster string, topic TopicSpec, current *topicDescription) error {
+	if current.replicationFactor != topic.ReplicationFactor {
+		return fmt.Errorf("topic %q has replication factor %d, expect %d, it could not be changed", topic.Name, current.replicationFactor, topic.ReplicationFactor)
+	}
+	if current.partitions > topic.Partitions {
+		return fmt.Errorf("topic %q has %d partitions, expect %d, partitions could not be removed", topic.Name, current.partitions, topic.Partitions)
+	}
+	if current.partitions < topic.Partitions {
+		logger.Infof(ctx, "Increasing partitions of topic %q from %d to %d ...", topic.Name, current.partitions, topic.Partitions)
+		if _, err := k.kafkaTopicsCommand(ctx, cluster, "--alter", "--topic", topic.Name, "--partitions", strconv.Itoa(topic.Partitions)); err != nil {
+			return fmt.Errorf("error altering partitions of topic %q: %v", topic.Name, err)
+		}
+	}
+
+	changed := map[string]string{}
+	for name, value := range topic.Configs {
+		if current.configs[name] != value {
+			changed[name] = value
+		}
+	}
+	if len(changed) != 0 {
+		logger.Infof(ctx, "Altering configs of topic %q ...", topic.Name)
+		if _, err := k.cluster.exec(ctx, kafkaBrokerPod(cluster), k.namespace(), kafkaConfigsCommand, "--bootstrap-server", kafkaBootstrapServer,
+			"--alter", "--entity-type", "topics", "--entity-name", topic.Name, "--add-config", strings.Join(sortedConfigs(changed), ",")); err != nil {
+			return fmt.Errorf("error altering configs of topic %q: %v", topic.Name, err)
+		}
+	}
+	if current.partitions < topic.Partitions || len(changed) != 0 {
+		recordResource(ctx, "topic/"+topic.Name, resourceUpdated)
+	} else {
+		recordResource(ctx, "topic/"+topic.Name, resourcePresent)
+	}
+	return nil
+}
+
+// KafkaTopicsCreation creates the topics of the kafka cluster or alters them when they differ from the spec
+func (k *k8sSetUpImpl) KafkaTopicsCreation(ctx context.Context, clusterName string) error {
+	topics := k.spec.kafkaCluster(clusterName).Topics
+	if len(topics) == 0 {
+		return nil
+	}
+	logger.Infof(ctx, "Creating topics of kafka cluster %q ...", clusterName)
+	names := make([]string, len(topics))
+	for i, topic := range topics {
+		names[i] = topic.Name
+	}
+	recordOutput(ctx, "topics", strings.Join(names, ","))
+
+	existing, err := k.existingTopics(ctx, clusterName)
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if !existing[topic.Name] {
+			if err = k.createTopic(ctx, clusterName, topic); err != nil {
+				return err
+			}
+			continue
+		}
+		var current *topicDescription
+		if current, err = k.describeTopic(ctx, clusterName, topic.Name); err != nil {
+			return err
+		}
+		if err = k.alterTopic(ctx, clusterName, topic, current); err != nil {
+			return err
+		}
+	}
+
+	if k.planMode() {
+		return nil
+	}
+	if existing, err = k.listTopics(ctx, clusterName); err != nil {
+		return err
+	}
+	var missing []string
+	for _, topic := range topics {
+		if !existing[topic.Name] {
+			missing = append(missing, topic.Name)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("topics not found after creation: %s", strings.Join(missing, ", "))
+	}
+	logger.Infof(ctx, "Topics of kafka cluster %q created ...", clusterName)
+	return nil
+}
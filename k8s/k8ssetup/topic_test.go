@@ -0,0 +1,197 @@
+package k8ssetup
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const petCommandsDescription = "Topic: pet-commands\tPartitionCount: 3\tReplicationFactor: 3\tConfigs: min.insync.replicas=2,retention.ms=604800000,segment.bytes=1073741824\n" +
+	"\tTopic: pet-commands\tPartition: 0\tLeader: 0\tReplicas: 0,1,2\tIsr: 0,1,2\n"
+
+func Test_parseTopicDescription(t *testing.T) {
+	t.Run("must read partitions, replication factor and configs", func(t *testing.T) {
+		expect := &topicDescription{
+			partitions:        3,
+			replicationFactor: 3,
+			configs: map[string]string{
+				"min.insync.replicas": "2",
+				"retention.ms":        "604800000",
+				"segment.bytes":       "1073741824",
+			},
+		}
+		got, gotErr := parseTopicDescription("pet-commands", petCommandsDescription)
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %+v, expect %+v", got, expect)
+		}
+	})
+
+	t.Run("must return error when there is no description", func(t *testing.T) {
+		expect := "no description found for topic \"other\""
+		_, got := parseTopicDescription("other", petCommandsDescription)
+		if got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
+
+// fakeKafkaTopics answers kafka-topics.sh and kafka-configs.sh with the topics that exist, recording what changes
+type fakeKafkaTopics struct {
+	topics   map[string]string
+	commands []string
+}
+
+func (f *fakeKafkaTopics) execute(ctx context.Context, cmdName string, params ...string) (string, error) {
+	if params[0] != "exec" || params[1] != "pod/kafka-pets-kafka-0" {
+		return "", errors.New("unexpected command")
+	}
+	command := strings.Join(params[5:], " ")
+	switch {
+	case strings.Contains(command, "--list"):
+		var names []string
+		for k := range f.topics {
+			names = append(names, k)
+		}
+		return strings.Join(names, "\n"), nil
+	case strings.Contains(command, "--describe"):
+		return f.topics[params[len(params)-1]], nil
+	case strings.Contains(command, "--create"):
+		f.topics[params[11]] = "created"
+	}
+	f.commands = append(f.commands, command)
+	return "", nil
+}
+
+func Test_KafkaTopicsCreationPlan(t *testing.T) {
+	plan := NewPlan()
+	k8sImpl := NewK8sSetUpWithSpec(DefaultSpec(), Options{Plan: plan}).(*k8sSetUpImpl)
+	k8sImpl.kubectlPath = "kubectl"
+
+	t.Run("must plan every topic when the broker was only planned", func(t *testing.T) {
+		k8sImpl.executeCommand = planExecuteCommand(plan, func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			if params[0] == "exec" {
+				t.Fatalf("Unexpected exec in a broker that does not exist %v", params)
+			}
+			if params[0] == "get" && params[1] == "pod" {
+				return "", nil
+			}
+			return "", errors.New("unexpected command " + strings.Join(params, " "))
+		})
+
+		if got := k8sImpl.KafkaTopicsCreation(context.Background(), "pets"); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		expect := []string{"kubectl exec pod/kafka-pets-kafka-0 -n default -- " + kafkaTopicsCommand + " --bootstrap-server localhost:9093 " +
+			"--create --if-not-exists --topic pet-commands --partitions 3 --replication-factor 3 --config min.insync.replicas=2 " +
+			"--config retention.ms=604800000"}
+		if got := plan.Steps(); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must list the topics of a running broker", func(t *testing.T) {
+		fake := &fakeKafkaTopics{topics: map[string]string{"pet-commands": petCommandsDescription}}
+		k8sImpl.executeCommand = planExecuteCommand(plan, func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			switch {
+			case params[0] == "get" && params[1] == "pod":
+				return "pod/kafka-pets-kafka-0\n", nil
+			case params[0] == "get" && params[1] == "pod/kafka-pets-kafka-0":
+				return "'true'", nil
+			}
+			return fake.execute(ctx, cmdName, params...)
+		})
+
+		if got := k8sImpl.KafkaTopicsCreation(context.Background(), "pets"); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		if got := len(plan.Steps()); got != 1 {
+			t.Fatalf("Got %d steps %v, expect no new step", got, plan.Steps())
+		}
+	})
+}
+
+func Test_KafkaTopicsCreation(t *testing.T) {
+	spec := DefaultSpec()
+	k8sImpl := NewK8sSetUpWithSpec(spec, Options{}).(*k8sSetUpImpl)
+
+	t.Run("must create the topics that do not exist", func(t *testing.T) {
+		fake := &fakeKafkaTopics{topics: map[string]string{"__consumer_offsets": ""}}
+		k8sImpl.executeCommand = fake.execute
+
+		if got := k8sImpl.KafkaTopicsCreation(context.Background(), "pets"); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		expect := []string{kafkaTopicsCommand + " --bootstrap-server localhost:9093 --create --if-not-exists --topic pet-commands " +
+			"--partitions 3 --replication-factor 3 --config min.insync.replicas=2 --config retention.ms=604800000"}
+		if !reflect.DeepEqual(fake.commands, expect) {
+			t.Fatalf("Got %v, expect %v", fake.commands, expect)
+		}
+	})
+
+	t.Run("must do nothing when the topics are up to date", func(t *testing.T) {
+		fake := &fakeKafkaTopics{topics: map[string]string{"pet-commands": petCommandsDescription}}
+		k8sImpl.executeCommand = fake.execute
+
+		if got := k8sImpl.KafkaTopicsCreation(context.Background(), "pets"); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		if fake.commands != nil {
+			t.Fatalf("Got %v, expect no changes", fake.commands)
+		}
+	})
+
+	t.Run("must alter partitions and configs that differ", func(t *testing.T) {
+		description := strings.Replace(petCommandsDescription, "PartitionCount: 3", "PartitionCount: 1", 1)
+		description = strings.Replace(description, "min.insync.replicas=2", "min.insync.replicas=1", 1)
+		fake := &fakeKafkaTopics{topics: map[string]string{"pet-commands": description}}
+		k8sImpl.executeCommand = fake.execute
+
+		if got := k8sImpl.KafkaTopicsCreation(context.Background(), "pets"); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		expect := []string{
+			kafkaTopicsCommand + " --bootstrap-server localhost:9093 --alter --topic pet-commands --partitions 3",
+			kafkaConfigsCommand + " --bootstrap-server localhost:9093 --alter --entity-type topics --entity-name pet-commands --add-config min.insync.replicas=2",
+		}
+		if !reflect.DeepEqual(fake.commands, expect) {
+			t.Fatalf("Got %v, expect %v", fake.commands, expect)
+		}
+	})
+
+	t.Run("must fail when the replication factor differs", func(t *testing.T) {
+		description := strings.Replace(petCommandsDescription, "ReplicationFactor: 3", "ReplicationFactor: 1", 1)
+		k8sImpl.executeCommand = (&fakeKafkaTopics{topics: map[string]string{"pet-commands": description}}).execute
+
+		expect := "topic \"pet-commands\" has replication factor 1, expect 3, it could not be changed"
+		if got := k8sImpl.KafkaTopicsCreation(context.Background(), "pets"); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must fail when the topic is not there after creation", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			return "", nil
+		}
+
+		expect := "topics not found after creation: pet-commands"
+		if got := k8sImpl.KafkaTopicsCreation(context.Background(), "pets"); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must do nothing for clusters without topics", func(t *testing.T) {
+		k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+			t.Fatalf("Unexpected command %v", params)
+			return "", nil
+		}
+
+		if got := k8sImpl.KafkaTopicsCreation(context.Background(), "other"); got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+	})
+}
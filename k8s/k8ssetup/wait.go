@@ -0,0 +1,76 @@
+package k8ssetup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// permanentError is a check failure that will not be fixed by waiting longer
+type permanentError struct {
+	err error
+}
+
+func (p permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p permanentError) Unwrap() error {
+	return p.err
+}
+
+func isPermanentError(err error) bool {
+	var perm permanentError
+	return errors.As(err, &perm)
+}
+
+func (b BackoffSpec) next(delay time.Duration) time.Duration {
+	delay = time.Duration(float64(delay) * b.Factor)
+	if delay > time.Duration(b.Max) {
+		delay = time.Duration(b.Max)
+	}
+	return delay
+}
+
+// waitFor runs check with an exponential backoff until it is ready, it fails or the timeout is reached
+func (k k8sSetUpImpl) waitFor(ctx context.Context, what string, timeout Duration, check func(ctx context.Context) (bool, error)) error {
+	if k.planMode() {
+		logger.Infof(ctx, "Plan: skip waiting for %s", what)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout))
+	defer cancel()
+
+	var lastErr error
+	delay := time.Duration(k.spec.Backoff.Initial)
+	for {
+		ready, err := check(ctx)
+		if err == nil && ready {
+			logger.Infof(ctx, "Done waiting for %s", what)
+			return nil
+		}
+		if err != nil {
+			if isPermanentError(err) {
+				return fmt.Errorf("%s failed: %v", what, err)
+			}
+			lastErr = err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("cancelled waiting for %s: %v", what, ctx.Err())
+			}
+			if lastErr != nil {
+				return fmt.Errorf("timed out after %v waiting for %s, last error: %v", timeout, what, lastErr)
+			}
+			return fmt.Errorf("timed out after %v waiting for %s", timeout, what)
+		case <-timer.C:
+		}
+		delay = k.spec.Backoff.next(delay)
+	}
+}
@@ -0,0 +1,110 @@
+package k8ssetup
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_waitFor(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+	timeout := Duration(20 * time.Millisecond)
+
+	t.Run("must wait until is ready", func(t *testing.T) {
+		count := 0
+		got := k8sImpl.waitFor(context.Background(), "test", timeout, func(ctx context.Context) (bool, error) {
+			count++
+			return count == 3, nil
+		})
+		if got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+		if count != 3 {
+			t.Fatalf("Got %d checks, expect %d", count, 3)
+		}
+	})
+
+	t.Run("must fail naming what never became ready", func(t *testing.T) {
+		expect := "timed out after 20ms waiting for kafka \"pets\" running, last error: invalid"
+		got := k8sImpl.waitFor(context.Background(), "kafka \"pets\" running", timeout, func(ctx context.Context) (bool, error) {
+			return false, errors.New("invalid")
+		})
+		if got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must fail without waiting on a permanent error", func(t *testing.T) {
+		count := 0
+		expect := "test failed: image is ErrImagePull"
+		got := k8sImpl.waitFor(context.Background(), "test", Duration(time.Hour), func(ctx context.Context) (bool, error) {
+			count++
+			return false, permanentError{errors.New("image is ErrImagePull")}
+		})
+		if got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+		if count != 1 {
+			t.Fatalf("Got %d checks, expect %d", count, 1)
+		}
+	})
+
+	t.Run("must stop when the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		expect := "cancelled waiting for test"
+		got := k8sImpl.waitFor(ctx, "test", Duration(time.Hour), func(ctx context.Context) (bool, error) {
+			cancel()
+			return false, nil
+		})
+		if got == nil || !strings.Contains(got.Error(), expect) {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must not wait in plan mode", func(t *testing.T) {
+		k8sImpl.plan = NewPlan()
+		defer func() { k8sImpl.plan = nil }()
+		got := k8sImpl.waitFor(context.Background(), "test", timeout, func(ctx context.Context) (bool, error) {
+			t.Fatal("Unexpected check in plan mode")
+			return false, nil
+		})
+		if got != nil {
+			t.Fatalf("Got error %v, expect nil", got)
+		}
+	})
+}
+
+func Test_backoffNext(t *testing.T) {
+	backoff := BackoffSpec{Initial: Duration(time.Second), Max: Duration(5 * time.Second), Factor: 2}
+
+	if got, expect := backoff.next(time.Second), 2*time.Second; got != expect {
+		t.Fatalf("Got %v, expect %v", got, expect)
+	}
+	if got, expect := backoff.next(4*time.Second), 5*time.Second; got != expect {
+		t.Fatalf("Got %v, expect %v", got, expect)
+	}
+}
+
+func Test_isPodRunningFailure(t *testing.T) {
+	k8sImpl := NewK8sSetUp().(*k8sSetUpImpl)
+
+	k8sImpl.executeCommand = func(ctx context.Context, cmdName string, params ...string) (string, error) {
+		if params[1] == "pod" {
+			return "pod/petstore-pets-cluster-run-x1", nil
+		}
+		if strings.Contains(params[3], "waiting.reason") {
+			return "'ImagePullBackOff'", nil
+		}
+		return "'false'", nil
+	}
+
+	got, gotErr := k8sImpl.isPodRunning(context.Background(), "petstore-pets-cluster-run", "default")
+	if got {
+		t.Fatalf("Got %v, expect %v", got, false)
+	}
+	if !isPermanentError(gotErr) {
+		t.Fatalf("Got error %v, expect permanent error", gotErr)
+	}
+}
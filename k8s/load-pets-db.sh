@@ -8,4 +8,4 @@ export PGDATABASE="pets"
 export PGUSER="petdba"
 export PGPASSWORD=`kubectl get secret petdba.petstore-pets-cluster.credentials -o 'jsonpath={.data.password}' | base64 -d`
 
-psql < ../pet-sql/schema.sql
\ No newline at end of file
+../pet-sql/migrate.sh ../pet-sql/migrations
\ No newline at end of file
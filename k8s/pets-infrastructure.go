@@ -1,33 +1,145 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"k8s/k8ssetup"
-	"log"
+	"os"
 )
 
-func run(stp k8ssetup.K8sSetUp) error {
-	if err := stp.Initialize(); err != nil {
-		return fmt.Errorf("error on initialize, %v", err)
+// setUpSteps returns the graph of the set up, the databases need the operator and the kafka clusters need kudo
+// so both branches run concurrently once the namespace exists
+func setUpSteps(stp k8ssetup.K8sSetUp, spec *k8ssetup.Spec) []step {
+	namespace := k8ssetup.StepID("namespace", spec.Namespace)
+	steps := []step{
+		{name: initializeStep, run: stp.Initialize, failure: "error on initialize"},
+		{name: "namespace", component: spec.Namespace, needs: []string{initializeStep}, run: stp.NamespaceCreation, failure: "error creating namespace"},
 	}
-	if err := stp.InstallPostgresqlOperator(); err != nil {
-		return fmt.Errorf("error installing PostgreSQL operator, %v", err)
+
+	operator := step{name: "postgresql-operator", needs: []string{namespace}, failure: "error installing PostgreSQL operator"}
+	if len(spec.Databases) != 0 {
+		operator.run = stp.InstallPostgresqlOperator
+	}
+	steps = append(steps, operator)
+	for _, db := range spec.Databases {
+		manifest := db.Manifest
+		steps = append(steps, step{name: "database", component: manifest, needs: []string{operator.id()}, run: func(ctx context.Context) error {
+			return stp.DatabaseCreation(ctx, manifest)
+		}, failure: "error installing database"})
 	}
-	if err := stp.DatabaseCreation("pets-db.yml"); err != nil {
-		return fmt.Errorf("error installing database, %v", err)
+
+	kudo := step{name: "kudo", needs: []string{namespace}, failure: "error checking kudo installation"}
+	if len(spec.Kafka) != 0 {
+		kudo.run = stp.CheckKudoInstallation
 	}
-	if err := stp.CheckKudoInstallation(); err != nil {
-		return fmt.Errorf("error checking kudo installation, %v", err)
+	steps = append(steps, kudo)
+	for _, kafka := range spec.Kafka {
+		name := kafka.Name
+		cluster := step{name: "kafka-cluster", component: name, needs: []string{kudo.id()}, run: func(ctx context.Context) error {
+			return stp.KafkaClusterCreation(ctx, name)
+		}, failure: "error installing Kafka cluster"}
+		steps = append(steps, cluster, step{name: "kafka-topics", component: name, needs: []string{cluster.id()}, run: func(ctx context.Context) error {
+			return stp.KafkaTopicsCreation(ctx, name)
+		}, failure: "error creating Kafka topics"})
 	}
-	if err := stp.KafkaClusterCreation("pets"); err != nil {
-		return fmt.Errorf("error installing Kafka cluster, %v", err)
+	return steps
+}
+
+func run(ctx context.Context, stp k8ssetup.K8sSetUp, spec *k8ssetup.Spec, r *runner) error {
+	return r.runGraph(ctx, setUpSteps(stp, spec))
+}
+
+func teardown(ctx context.Context, stp k8ssetup.K8sSetUp, spec *k8ssetup.Spec, r *runner) error {
+	return r.runGraph(ctx, []step{
+		{name: initializeStep, run: stp.Initialize, failure: "error on initialize"},
+		{name: "teardown", needs: []string{initializeStep}, run: func(ctx context.Context) error {
+			return stp.Teardown(ctx, spec.DatabaseManifests(), spec.KafkaClusters())
+		}, failure: "error tearing down"},
+	})
+}
+
+// doctor prints the checklist of the doctor as a table or json, it returns an error when a check failed
+func doctor(ctx context.Context, stp k8ssetup.K8sSetUp, w io.Writer, format string) (err error) {
+	list := stp.Doctor(stepContext(ctx, "doctor", ""))
+	if format == k8ssetup.JSONReport {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(list)
+	} else {
+		err = list.Print(w)
+	}
+	if err != nil {
+		return err
+	}
+	if failed := list.Failed(); failed != 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
 	}
 	return nil
 }
 
-func main() {
-	stp := k8ssetup.NewK8sSetUp()
-	if err := run(stp); err != nil {
-		log.Fatalf("Error running the set up, %v", err)
+// status prints the live state of every component, it returns an error when one is not healthy
+func status(ctx context.Context, stp k8ssetup.K8sSetUp, w io.Writer, format string) error {
+	components, err := stp.Status(stepContext(ctx, "status", ""))
+	if err != nil {
+		return err
+	}
+	if err = components.Write(w, format); err != nil {
+		return err
+	}
+	if unhealthy := components.Unhealthy(); unhealthy != 0 {
+		return fmt.Errorf("%d component(s) not healthy", unhealthy)
+	}
+	return nil
+}
+
+// stepStatus prints the last result of every step that the state file has, it returns an error when one failed
+func stepStatus(spec *k8ssetup.Spec, stateFile string, w io.Writer, format string) error {
+	if stateFile == "" {
+		return usageError("no state file to read the status from")
+	}
+	state, err := k8ssetup.LoadState(stateFile, spec)
+	if err != nil {
+		return exitError{code: exitConfig, err: err}
+	}
+	if err = state.Write(w, format); err != nil {
+		return err
+	}
+	if failed := state.Failed(); failed != 0 {
+		return fmt.Errorf("%d step(s) failed", failed)
+	}
+	return nil
+}
+
+// writeReport writes the report of the run in the format, to the file when there is one or to w
+func writeReport(w io.Writer, report *k8ssetup.Report, format, fileName string) error {
+	if fileName == "" {
+		return report.Write(w, format)
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating report file %q: %v", fileName, err)
 	}
+	if err = report.Write(file, format); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// newLogger returns the logger of the flags, verbose is the debug level that logs the output of every command
+func newLogger(w io.Writer, level, format string, verbose bool) (*k8ssetup.Logger, error) {
+	logLevel, err := k8ssetup.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		logLevel = k8ssetup.DebugLevel
+	}
+	return k8ssetup.NewLogger(w, logLevel, format)
+}
+
+func main() {
+	os.Exit(newCLI(os.Stdout, os.Stderr, k8ssetup.NewK8sSetUpWithSpec).run(os.Args[1:]))
 }
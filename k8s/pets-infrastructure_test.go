@@ -1,66 +1,114 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"k8s/k8ssetup"
+	"reflect"
+	"strings"
 	"testing"
 )
 
 type k8sSetUpFake struct {
 	failOnInitialize                bool
+	failOnNamespaceCreation         bool
 	failOnInstallPostgresqlOperator bool
 	failOnDatabaseCreation          bool
 	failOnKafkaClusterCreation      bool
-	failOnCheckKudoInstallation		bool
+	failOnKafkaTopicsCreation       bool
+	failOnCheckKudoInstallation     bool
+	failOnTeardown                  bool
+	failOnStatus                    bool
+	checklist                       k8ssetup.Checklist
+	status                          k8ssetup.Status
 }
 
 var (
-	errorInit                 = errors.New("error on initialize")
-	errorInstallPsqlOperator  = errors.New("error on installing postgresql operator")
-	errorDBCreation           = errors.New("error on database creation")
-	errorKafkaClusterCreation = errors.New("error on kafka cluster creation")
+	errorInit                  = errors.New("error on initialize")
+	errorNamespaceCreation     = errors.New("error on namespace creation")
+	errorInstallPsqlOperator   = errors.New("error on installing postgresql operator")
+	errorDBCreation            = errors.New("error on database creation")
+	errorKafkaClusterCreation  = errors.New("error on kafka cluster creation")
+	errorKafkaTopicsCreation   = errors.New("error on kafka topics creation")
 	errorCheckKudoInstallation = errors.New("error on kudo checking kudo installation")
+	errorTeardown              = errors.New("error on teardown")
+	errorStatus                = errors.New("error on status")
 )
 
-func (k k8sSetUpFake) Initialize() error {
+func (k k8sSetUpFake) Initialize(ctx context.Context) error {
 	if k.failOnInitialize {
 		return errorInit
 	}
 	return nil
 }
 
-func (k k8sSetUpFake) InstallPostgresqlOperator() error {
+func (k k8sSetUpFake) NamespaceCreation(ctx context.Context) error {
+	if k.failOnNamespaceCreation {
+		return errorNamespaceCreation
+	}
+	return nil
+}
+
+func (k k8sSetUpFake) InstallPostgresqlOperator(ctx context.Context) error {
 	if k.failOnInstallPostgresqlOperator {
 		return errorInstallPsqlOperator
 	}
 	return nil
 }
 
-func (k k8sSetUpFake) DatabaseCreation(fileName string) error {
+func (k k8sSetUpFake) DatabaseCreation(ctx context.Context, fileName string) error {
 	if k.failOnDatabaseCreation {
 		return errorDBCreation
 	}
 	return nil
 }
 
-func (k k8sSetUpFake) KafkaClusterCreation(fileName string) error {
+func (k k8sSetUpFake) KafkaClusterCreation(ctx context.Context, fileName string) error {
 	if k.failOnKafkaClusterCreation {
 		return errorKafkaClusterCreation
 	}
 	return nil
 }
 
-func (k k8sSetUpFake) CheckKudoInstallation() error {
+func (k k8sSetUpFake) KafkaTopicsCreation(ctx context.Context, clusterName string) error {
+	if k.failOnKafkaTopicsCreation {
+		return errorKafkaTopicsCreation
+	}
+	return nil
+}
+
+func (k k8sSetUpFake) CheckKudoInstallation(ctx context.Context) error {
 	if k.failOnCheckKudoInstallation {
 		return errorCheckKudoInstallation
 	}
 	return nil
 }
 
+func (k k8sSetUpFake) Teardown(ctx context.Context, databaseFiles []string, kafkaClusters []string) error {
+	if k.failOnTeardown {
+		return errorTeardown
+	}
+	return nil
+}
+
+func (k k8sSetUpFake) Doctor(ctx context.Context) k8ssetup.Checklist {
+	return k.checklist
+}
+
+func (k k8sSetUpFake) Status(ctx context.Context) (k8ssetup.Status, error) {
+	if k.failOnStatus {
+		return k8ssetup.Status{}, errorStatus
+	}
+	return k.status, nil
+}
+
 func Test_run(t *testing.T) {
 	type TestCase struct {
 		name   string
 		stp    k8sSetUpFake
+		spec   *k8ssetup.Spec
 		expect error
 	}
 
@@ -77,6 +125,13 @@ func Test_run(t *testing.T) {
 			},
 			expect: fmt.Errorf("error on initialize, %v", errorInit),
 		},
+		{
+			name: "should run error when namespace creation fails",
+			stp: k8sSetUpFake{
+				failOnNamespaceCreation: true,
+			},
+			expect: fmt.Errorf("error creating namespace, %v", errorNamespaceCreation),
+		},
 		{
 			name: "should run error when install postgresql operator fails",
 			stp: k8sSetUpFake{
@@ -105,11 +160,131 @@ func Test_run(t *testing.T) {
 			},
 			expect: fmt.Errorf("error installing Kafka cluster, %v", errorKafkaClusterCreation),
 		},
+		{
+			name: "should run error when creation kafka topics fails",
+			stp: k8sSetUpFake{
+				failOnKafkaTopicsCreation: true,
+			},
+			expect: fmt.Errorf("error creating Kafka topics, %v", errorKafkaTopicsCreation),
+		},
+		{
+			name: "should not check kudo when the spec has no kafka clusters",
+			stp: k8sSetUpFake{
+				failOnCheckKudoInstallation: true,
+			},
+			spec: &k8ssetup.Spec{
+				Version:   k8ssetup.SpecVersion,
+				Databases: []k8ssetup.DatabaseSpec{{Manifest: "pets-db.yml"}},
+			},
+			expect: nil,
+		},
+		{
+			name: "should not install the operator when the spec has no databases",
+			stp: k8sSetUpFake{
+				failOnInstallPostgresqlOperator: true,
+			},
+			spec: &k8ssetup.Spec{
+				Version: k8ssetup.SpecVersion,
+				Kafka:   []k8ssetup.KafkaSpec{{Name: "pets"}},
+			},
+			expect: nil,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.spec
+			if spec == nil {
+				spec = k8ssetup.DefaultSpec()
+			}
+			got := run(context.Background(), tt.stp, spec, newTestRunner(k8ssetup.NewReport()))
+			if tt.expect == nil {
+				if got != nil {
+					t.Errorf("Got %v, expect nil", got)
+				}
+			} else {
+				if got.Error() != tt.expect.Error() {
+					t.Errorf("Got %v, expect %v", got, tt.expect)
+				}
+			}
+		})
+	}
+}
+
+func Test_runReport(t *testing.T) {
+	t.Run("must report every step and the skipped ones", func(t *testing.T) {
+		report := k8ssetup.NewReport()
+		spec := &k8ssetup.Spec{
+			Version:   k8ssetup.SpecVersion,
+			Namespace: "pets",
+			Databases: []k8ssetup.DatabaseSpec{{Manifest: "pets-db.yml"}},
+		}
+		if err := run(context.Background(), k8sSetUpFake{}, spec, newTestRunner(report)); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		expect := []string{
+			"database/pets-db.yml already-present",
+			"initialize already-present",
+			"kudo skipped",
+			"namespace/pets already-present",
+			"postgresql-operator already-present",
+		}
+		if got := stepStatuses(report); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must report the failed step with its error", func(t *testing.T) {
+		report := k8ssetup.NewReport()
+		_ = run(context.Background(), k8sSetUpFake{failOnKafkaClusterCreation: true}, k8ssetup.DefaultSpec(), newTestRunner(report))
+
+		var failed []k8ssetup.StepResult
+		for _, v := range report.Steps() {
+			if v.Status == k8ssetup.StatusFailed {
+				failed = append(failed, v)
+			}
+		}
+		if len(failed) != 1 || failed[0].Name != "kafka-cluster" || failed[0].Error != errorKafkaClusterCreation.Error() {
+			t.Fatalf("Got %+v, expect the failed kafka cluster step", failed)
+		}
+		if !report.Failed() {
+			t.Fatalf("Got not failed, expect failed")
+		}
+	})
+}
+
+func Test_teardown(t *testing.T) {
+	type TestCase struct {
+		name   string
+		stp    k8sSetUpFake
+		expect error
+	}
+
+	cases := []TestCase{
+		{
+			name:   "should tear down without errors",
+			stp:    k8sSetUpFake{},
+			expect: nil,
+		},
+		{
+			name: "should return error when initialize fails",
+			stp: k8sSetUpFake{
+				failOnInitialize: true,
+			},
+			expect: fmt.Errorf("error on initialize, %v", errorInit),
+		},
+		{
+			name: "should return error when teardown fails",
+			stp: k8sSetUpFake{
+				failOnTeardown: true,
+			},
+			expect: fmt.Errorf("error tearing down, %v", errorTeardown),
+		},
 	}
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			got := run(tt.stp)
+			got := teardown(context.Background(), tt.stp, k8ssetup.DefaultSpec(), newTestRunner(k8ssetup.NewReport()))
 			if tt.expect == nil {
 				if got != nil {
 					t.Errorf("Got %v, expect nil", got)
@@ -122,3 +297,76 @@ func Test_run(t *testing.T) {
 		})
 	}
 }
+
+func Test_newLogger(t *testing.T) {
+	t.Run("must create a debug logger when verbose", func(t *testing.T) {
+		got, gotErr := newLogger(&bytes.Buffer{}, "warn", k8ssetup.JSONFormat, true)
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if !got.Enabled(k8ssetup.DebugLevel) {
+			t.Fatalf("Got debug disabled, expect enabled")
+		}
+	})
+
+	t.Run("must create a logger of the level", func(t *testing.T) {
+		got, gotErr := newLogger(&bytes.Buffer{}, "warn", k8ssetup.ConsoleFormat, false)
+		if gotErr != nil {
+			t.Fatalf("Got error %v, expect nil", gotErr)
+		}
+		if got.Enabled(k8ssetup.InfoLevel) || !got.Enabled(k8ssetup.WarnLevel) {
+			t.Fatalf("Got info enabled or warn disabled, expect warn level")
+		}
+	})
+
+	t.Run("must return error on an unknown level", func(t *testing.T) {
+		if _, got := newLogger(&bytes.Buffer{}, "loud", k8ssetup.ConsoleFormat, false); got == nil {
+			t.Fatalf("Got nil, expect error")
+		}
+	})
+
+	t.Run("must return error on an unknown format", func(t *testing.T) {
+		if _, got := newLogger(&bytes.Buffer{}, "info", "xml", false); got == nil {
+			t.Fatalf("Got nil, expect error")
+		}
+	})
+}
+
+func Test_stepContext(t *testing.T) {
+	var out bytes.Buffer
+	logger, _ := k8ssetup.NewLogger(&out, k8ssetup.InfoLevel, k8ssetup.ConsoleFormat)
+
+	logger.Infof(stepContext(context.Background(), "database", "pets-db.yml"), "created")
+	logger.Infof(stepContext(context.Background(), "kudo", ""), "checked")
+
+	if got := out.String(); !strings.Contains(got, "created step=database component=pets-db.yml\n") ||
+		!strings.HasSuffix(got, "checked step=kudo\n") {
+		t.Fatalf("Got %q, expect the step and the component of every event", got)
+	}
+}
+
+func Test_doctor(t *testing.T) {
+	t.Run("must print the checklist", func(t *testing.T) {
+		var out bytes.Buffer
+		stp := k8sSetUpFake{checklist: k8ssetup.Checklist{Checks: []k8ssetup.Check{
+			{Name: "kubectl", Passed: true, Detail: "found in /usr/bin/kubectl"},
+		}}}
+		if err := doctor(context.Background(), stp, &out, k8ssetup.TableReport); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if expect := "Doctor: 1 check(s), 0 failed\n[PASS] kubectl: found in /usr/bin/kubectl\n"; out.String() != expect {
+			t.Fatalf("Got %q, expect %q", out.String(), expect)
+		}
+	})
+
+	t.Run("must return error when a check fails", func(t *testing.T) {
+		stp := k8sSetUpFake{checklist: k8ssetup.Checklist{Checks: []k8ssetup.Check{
+			{Name: "kubectl", Passed: true},
+			{Name: "default storage class", Hint: "mark one"},
+		}}}
+		expect := "1 check(s) failed"
+		if got := doctor(context.Background(), stp, &bytes.Buffer{}, k8ssetup.TableReport); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
@@ -8,9 +8,9 @@ export PGDATABASE="pets"
 export PGUSER=$DATABASE_USERNAME
 export PGPASSWORD=$DATABASE_PASSWORD
 
-echo "running init script.."
-psql < /usr/src/schema.sql
-echo "init script completed"
+echo "running migrations.."
+/usr/src/migrate.sh /usr/src/migrations
+echo "migrations completed"
 
 echo "job completed"
 
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"k8s/k8ssetup"
+	"sync"
+)
+
+const initializeStep = "initialize"
+
+// stepContext returns the context of a step, its log events have the step and the component when there is one
+func stepContext(ctx context.Context, step, component string) context.Context {
+	ctx = k8ssetup.WithLogField(ctx, k8ssetup.StepField, step)
+	if component != "" {
+		ctx = k8ssetup.WithLogField(ctx, k8ssetup.ComponentField, component)
+	}
+	return ctx
+}
+
+// step is a node of the run graph, it runs once every step it needs is done
+type step struct {
+	name      string
+	component string
+	// needs are the ids of the steps that must be done before this one
+	needs []string
+	// run is nil when the spec does not need the step
+	run func(ctx context.Context) error
+	// failure is the error message of the run when the step fails
+	failure string
+}
+
+func (s step) id() string {
+	return k8ssetup.StepID(s.name, s.component)
+}
+
+// runner runs the steps that the options select and saves the state after every step
+type runner struct {
+	report *k8ssetup.Report
+	logger *k8ssetup.Logger
+	// mu keeps the state file in the order of the steps that end concurrently
+	mu sync.Mutex
+	// state is nil when the run does not save its state
+	state     *k8ssetup.State
+	stateFile string
+	// resume skips the steps that a previous run completed
+	resume bool
+	// fromStep skips the steps before it and onlyStep skips every other step, both are a name or a step id
+	fromStep string
+	onlyStep string
+	started  bool
+	matched  bool
+}
+
+func newRunner(report *k8ssetup.Report, logger *k8ssetup.Logger) *runner {
+	return &runner{report: report, logger: logger}
+}
+
+func matchStep(selector, name, component string) bool {
+	return selector == name || selector == k8ssetup.StepID(name, component)
+}
+
+// selected returns true when the options select the step, initialize always runs as the other steps need it
+func (r *runner) selected(name, component string) bool {
+	if name == initializeStep {
+		return true
+	}
+	if r.onlyStep != "" {
+		if !matchStep(r.onlyStep, name, component) {
+			return false
+		}
+		r.matched = true
+	}
+	if r.fromStep != "" && !r.started {
+		if !matchStep(r.fromStep, name, component) {
+			return false
+		}
+		r.started, r.matched = true, true
+	}
+	return true
+}
+
+// step runs a step with its log fields, adds its result to the report and saves it in the state
+func (r *runner) step(ctx context.Context, name, component string, fn func(ctx context.Context) error) error {
+	ctx = stepContext(ctx, name, component)
+	id := k8ssetup.StepID(name, component)
+	if r.resume && name != initializeStep && r.state != nil && r.state.Completed(id) {
+		r.logger.Infof(ctx, "Step %q completed by a previous run, skipping it ...", id)
+		r.report.Skip(name, component)
+		return nil
+	}
+
+	result, err := r.report.Run(ctx, name, component, fn)
+	if r.state != nil && name != initializeStep {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.state.Update(result)
+		if r.stateFile != "" {
+			if saveErr := r.state.Save(r.stateFile); saveErr != nil && err == nil {
+				err = saveErr
+			}
+		}
+	}
+	return err
+}
+
+// runGraph runs every step once the steps it needs are done, so independent branches run concurrently. The
+// first failure cancels the other steps and the steps that need a failed one are skipped
+func (r *runner) runGraph(ctx context.Context, steps []step) error {
+	done := map[string]chan struct{}{}
+	for _, v := range steps {
+		done[v.id()] = make(chan struct{})
+	}
+	// the options select the steps in the order of the graph, before any of them runs
+	selected := make([]bool, len(steps))
+	for i, v := range steps {
+		for _, need := range v.needs {
+			if _, ok := done[need]; !ok {
+				return fmt.Errorf("step %q needs unknown step %q", v.id(), need)
+			}
+		}
+		selected[i] = v.run != nil && r.selected(v.name, v.component)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		failed   = map[string]bool{}
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for i, v := range steps {
+		wg.Add(1)
+		go func(s step, selected bool) {
+			defer wg.Done()
+			defer close(done[s.id()])
+			for _, need := range s.needs {
+				<-done[need]
+			}
+
+			mu.Lock()
+			blocked := ctx.Err() != nil
+			for _, need := range s.needs {
+				blocked = blocked || failed[need]
+			}
+			if blocked {
+				failed[s.id()] = true
+			}
+			mu.Unlock()
+			if blocked || !selected {
+				r.report.Skip(s.name, s.component)
+				return
+			}
+
+			if err := r.step(ctx, s.name, s.component, s.run); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				failed[s.id()] = true
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s, %v", s.failure, err)
+					cancel()
+				}
+			}
+		}(v, selected[i])
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return r.checkSelection()
+}
+
+// loadState sets the state of the runner, a resumed run reads the state file and a plan never writes it
+func (r *runner) loadState(spec *k8ssetup.Spec, fileName string, resume, plan bool) (err error) {
+	if resume && fileName == "" {
+		return errors.New("no state file to resume from")
+	}
+	r.resume = resume
+	if resume {
+		if r.state, err = k8ssetup.LoadState(fileName, spec); err != nil {
+			return err
+		}
+	} else {
+		r.state = k8ssetup.NewState(spec)
+	}
+	if !plan {
+		r.stateFile = fileName
+	}
+	return nil
+}
+
+// checkSelection returns an error when a step option matched no step of the run
+func (r *runner) checkSelection() error {
+	if r.matched {
+		return nil
+	}
+	if r.onlyStep != "" {
+		return fmt.Errorf("no step %q in the run", r.onlyStep)
+	}
+	if r.fromStep != "" {
+		return fmt.Errorf("no step %q in the run", r.fromStep)
+	}
+	return nil
+}
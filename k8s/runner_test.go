@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"k8s/k8ssetup"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newTestRunner(report *k8ssetup.Report) *runner {
+	logger, _ := k8ssetup.NewLogger(ioutil.Discard, k8ssetup.InfoLevel, k8ssetup.ConsoleFormat)
+	return newRunner(report, logger)
+}
+
+// stepStatuses returns the id and the status of every step sorted, the steps of a run end in any order
+func stepStatuses(report *k8ssetup.Report) (got []string) {
+	for _, v := range report.Steps() {
+		got = append(got, k8ssetup.StepID(v.Name, v.Component)+" "+string(v.Status))
+	}
+	sort.Strings(got)
+	return
+}
+
+func Test_runner(t *testing.T) {
+	t.Run("must save the state and resume from the failed step", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		spec := &k8ssetup.Spec{
+			Version:   k8ssetup.SpecVersion,
+			Namespace: "pets",
+			Kafka:     []k8ssetup.KafkaSpec{{Name: "pets"}},
+		}
+
+		first := newTestRunner(k8ssetup.NewReport())
+		if err := first.loadState(spec, stateFile, false, false); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if err := run(context.Background(), k8sSetUpFake{failOnKafkaClusterCreation: true}, spec, first); err == nil {
+			t.Fatalf("Got nil, expect error")
+		}
+
+		second := newTestRunner(k8ssetup.NewReport())
+		if err := second.loadState(spec, stateFile, true, false); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if err := run(context.Background(), k8sSetUpFake{}, spec, second); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		expect := []string{
+			"initialize already-present",
+			"kafka-cluster/pets already-present",
+			"kafka-topics/pets already-present",
+			"kudo skipped",
+			"namespace/pets skipped",
+			"postgresql-operator skipped",
+		}
+		if got := stepStatuses(second.report); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+
+		state, err := k8ssetup.LoadState(stateFile, spec)
+		if err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if !state.Completed("kafka-cluster/pets") || state.Completed("initialize") {
+			t.Fatalf("Got %+v, expect the kafka cluster completed and no initialize", state.Steps)
+		}
+	})
+
+	t.Run("must run only the step", func(t *testing.T) {
+		r := newTestRunner(k8ssetup.NewReport())
+		r.onlyStep = "kafka-topics/pets"
+		if err := run(context.Background(), k8sSetUpFake{}, k8ssetup.DefaultSpec(), r); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		var ran []string
+		for _, v := range r.report.Steps() {
+			if v.Status != k8ssetup.StatusSkipped {
+				ran = append(ran, v.Name)
+			}
+		}
+		if expect := []string{"initialize", "kafka-topics"}; !reflect.DeepEqual(ran, expect) {
+			t.Fatalf("Got %v, expect %v", ran, expect)
+		}
+	})
+
+	t.Run("must run from the step", func(t *testing.T) {
+		r := newTestRunner(k8ssetup.NewReport())
+		r.fromStep = "database"
+		if err := run(context.Background(), k8sSetUpFake{failOnNamespaceCreation: true}, k8ssetup.DefaultSpec(), r); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		expect := []string{
+			"database/pets-db.yml already-present",
+			"initialize already-present",
+			"kafka-cluster/pets already-present",
+			"kafka-topics/pets already-present",
+			"kudo already-present",
+			"namespace/default skipped",
+			"postgresql-operator skipped",
+		}
+		if got := stepStatuses(r.report); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must return error when no step matches", func(t *testing.T) {
+		r := newTestRunner(k8ssetup.NewReport())
+		r.onlyStep = "database/orders-db.yml"
+		expect := "no step \"database/orders-db.yml\" in the run"
+		if got := run(context.Background(), k8sSetUpFake{}, k8ssetup.DefaultSpec(), r); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+
+	t.Run("must not write the state of a plan", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		r := newTestRunner(k8ssetup.NewReport())
+		if err := r.loadState(k8ssetup.DefaultSpec(), stateFile, false, true); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if err := run(context.Background(), k8sSetUpFake{}, k8ssetup.DefaultSpec(), r); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+		if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+			t.Fatalf("Got %v, expect no state file", err)
+		}
+	})
+
+	t.Run("must return error when resuming without a state file", func(t *testing.T) {
+		if got := newTestRunner(k8ssetup.NewReport()).loadState(k8ssetup.DefaultSpec(), "", true, false); got == nil {
+			t.Fatalf("Got nil, expect error")
+		}
+	})
+}
+
+func Test_runGraph(t *testing.T) {
+	t.Run("must run the independent steps concurrently", func(t *testing.T) {
+		started := map[string]chan struct{}{"a": make(chan struct{}), "b": make(chan struct{})}
+		waitOther := func(name, other string) func(ctx context.Context) error {
+			return func(ctx context.Context) error {
+				close(started[name])
+				select {
+				case <-started[other]:
+					return nil
+				case <-time.After(5 * time.Second):
+					return errors.New("the other step did not start")
+				}
+			}
+		}
+		r := newTestRunner(k8ssetup.NewReport())
+		if err := r.runGraph(context.Background(), []step{
+			{name: "root", run: func(ctx context.Context) error { return nil }},
+			{name: "a", needs: []string{"root"}, run: waitOther("a", "b"), failure: "error on a"},
+			{name: "b", needs: []string{"root"}, run: waitOther("b", "a"), failure: "error on b"},
+		}); err != nil {
+			t.Fatalf("Got error %v, expect nil", err)
+		}
+	})
+
+	t.Run("must cancel the other steps and skip the steps that need the failed one", func(t *testing.T) {
+		started := make(chan struct{})
+		r := newTestRunner(k8ssetup.NewReport())
+		got := r.runGraph(context.Background(), []step{
+			{name: "a", run: func(ctx context.Context) error {
+				<-started
+				return errors.New("boom")
+			}, failure: "error on a"},
+			{name: "b", run: func(ctx context.Context) error {
+				close(started)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(5 * time.Second):
+					return nil
+				}
+			}, failure: "error on b"},
+			{name: "c", needs: []string{"a"}, run: func(ctx context.Context) error { return nil }, failure: "error on c"},
+		})
+		if expect := "error on a, boom"; got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+		expect := []string{"a failed", "b failed", "c skipped"}
+		if got := stepStatuses(r.report); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("Got %v, expect %v", got, expect)
+		}
+	})
+
+	t.Run("must return error when a step needs an unknown step", func(t *testing.T) {
+		expect := "step \"a\" needs unknown step \"nope\""
+		if got := newTestRunner(k8ssetup.NewReport()).runGraph(context.Background(), []step{
+			{name: "a", needs: []string{"nope"}},
+		}); got == nil || got.Error() != expect {
+			t.Fatalf("Got error %v, expect %q", got, expect)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+#!/bin/sh -
+
+# applies the pending migrations of a dir in a single transaction and prints the resulting schema version, a
+# migration is named <version>_<description>.sql, the applied versions are tracked in the schema_migrations table
+# and the PG* variables select the database
+
+set -o errexit
+set -o nounset
+
+MIGRATIONS_DIR=${1:-/usr/src/migrations}
+PSQL="psql --no-psqlrc --quiet --set ON_ERROR_STOP=1"
+
+schema_version() {
+    $PSQL --tuples-only --no-align --command "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"
+}
+
+$PSQL <<EOF
+CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version INTEGER NOT NULL CONSTRAINT schema_migrations_pk PRIMARY KEY,
+    name VARCHAR(100) NOT NULL CHECK(name<>''),
+    creation TIMESTAMP DEFAULT NOW() NOT NULL
+);
+EOF
+
+current=$(schema_version)
+echo "schema version $current"
+
+plan=$(mktemp)
+trap 'rm -f "$plan"' EXIT
+# a job running at the same time waits for the lock, then fails inserting a version that is already there
+echo "LOCK TABLE schema_migrations IN EXCLUSIVE MODE;" > "$plan"
+
+pending=0
+previous=0
+for name in $(ls "$MIGRATIONS_DIR" | grep '\.sql$' | sort -n); do
+    if ! expr "$name" : '[0-9][0-9]*_[a-z0-9_]*\.sql$' > /dev/null; then
+        echo "migration $name is not named <version>_<description>.sql" >&2
+        exit 1
+    fi
+    version=$(expr "${name%%_*}" + 0)
+    if [ "$version" -eq "$previous" ]; then
+        echo "migration $name has the version $version of another migration" >&2
+        exit 1
+    fi
+    previous=$version
+    if [ "$version" -le "$current" ]; then
+        continue
+    fi
+
+    echo "pending migration $name"
+    pending=$((pending + 1))
+    cat >> "$plan" <<EOF
+\\echo applying migration $name
+\\i $MIGRATIONS_DIR/$name
+INSERT INTO schema_migrations (version, name) VALUES ($version, '$name');
+EOF
+done
+
+if [ "$pending" -eq 0 ]; then
+    echo "no pending migration"
+else
+    echo "applying $pending migration(s) in a transaction"
+    $PSQL --single-transaction --file "$plan"
+fi
+echo "schema version $(schema_version)"
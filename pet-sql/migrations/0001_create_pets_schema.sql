@@ -1,3 +1,5 @@
+-- tables keep IF NOT EXISTS so a database created before the migrations starts at this version
+
 CREATE TABLE IF NOT EXISTS categories
 (
     id SERIAL NOT NULL CONSTRAINT categories_pk PRIMARY KEY,
@@ -1,6 +1,7 @@
 package org.learning.by.example.petstore.petstream.configuration
 
 import io.r2dbc.spi.ConnectionFactory
+import java.io.File
 import org.springframework.boot.autoconfigure.condition.ConditionalOnProperty
 import org.springframework.core.io.FileSystemResource
 import org.springframework.data.r2dbc.connectionfactory.init.CompositeDatabasePopulator
@@ -12,15 +13,22 @@ import org.springframework.stereotype.Service
 @ConditionalOnProperty(prefix = "db", name = ["initialize"], havingValue = "true")
 class DBInitializer(connectionFactory: ConnectionFactory) : ConnectionFactoryInitializer() {
     companion object {
-        const val SQL_SCHEMA_PATH = "../pet-sql/schema.sql"
+        const val SQL_MIGRATIONS_PATH = "../pet-sql/migrations"
     }
 
     init {
         this.setConnectionFactory(connectionFactory)
         this.setDatabasePopulator(
             CompositeDatabasePopulator().apply {
-                addPopulators(ResourceDatabasePopulator(FileSystemResource(SQL_SCHEMA_PATH)))
+                addPopulators(ResourceDatabasePopulator(*migrations()))
             }
         )
     }
+
+    // the migrations of the schema in the order of their version, named <version>_<description>.sql
+    private fun migrations() = (File(SQL_MIGRATIONS_PATH).listFiles { file -> file.name.endsWith(".sql") }
+        ?: error("no migrations dir $SQL_MIGRATIONS_PATH"))
+        .sortedBy { it.name.substringBefore("_").toInt() }
+        .map { FileSystemResource(it) }
+        .toTypedArray()
 }